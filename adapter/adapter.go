@@ -4,22 +4,54 @@ import "time"
 
 // Message 代表一条聊天消息，是程序内部流转的通用结构
 type Message struct {
+	ID         int64     // 数据库中的行号，尚未入库时为 0
+	MessageID  string    // OneBot 的 message_id，用于回复和撤回，导入的历史消息没有
 	ChatID     string    // 群号或 QQ 号
 	ChatType   string    // "group" 或 "private"
 	SenderID   string    // 发送者 QQ 号
 	SenderName string    // 发-送者昵称
 	Content    string    // 消息内容
 	Time       time.Time // 消息时间
+	Mentioned  bool      // 是否 @ 了自己（包括 @全体成员）
+}
+
+// Notice 代表一条通知事件，如入群、退群、撤回、禁言、戳一戳
+type Notice struct {
+	ID         int64     // 数据库中的行号，尚未入库时为 0
+	ChatID     string    // 群号或 QQ 号
+	ChatType   string    // "group" 或 "private"
+	Type       string    // OneBot 的 notice_type，如 "group_increase"
+	SubType    string    // OneBot 的 sub_type，如 "poke"
+	UserID     string    // 事件涉及的用户
+	OperatorID string    // 操作者，可能为空
+	Text       string    // 便于阅读的描述
+	Time       time.Time // 事件时间
 }
 
 // ChatInfo 代表一个聊天会话（私聊或群聊），用于在 TUI 左侧列表显示
 type ChatInfo struct {
 	ID        string // 群号或 QQ 号
 	Name      string // 群名称或好友昵称
+	Remark    string // 好友备注，群聊为空
 	Type      string // "group" 或 "private"
 	LatestMsg string // 最新一条消息预览
 }
 
+// UserProfile 是用户的资料，群聊中还包含群名片、身份等成员信息
+type UserProfile struct {
+	UserID       string
+	Nickname     string
+	Sex          string // "male"、"female" 或 "unknown"
+	Age          int
+	Level        int
+	Sign         string    // 个性签名
+	Card         string    // 群名片
+	Role         string    // "owner"、"admin" 或 "member"
+	Title        string    // 群头衔
+	JoinTime     time.Time // 入群时间
+	LastSentTime time.Time // 最后发言时间
+}
+
 // BotAdapter 定义了所有后端适配器都必须实现的通用方法
 type BotAdapter interface {
 	// Connect 连接到 OneBot 后端
@@ -27,12 +59,27 @@ type BotAdapter interface {
 	// Disconnect 断开连接
 	Disconnect() error
 
-	// SendMessage 发送消息到指定聊天
-	SendMessage(chatID string, chatType string, message string) error
+	// SendMessage 发送消息到指定聊天，返回新消息的 message_id
+	SendMessage(chatID string, chatType string, message string) (string, error)
+	// RecallMessage 撤回一条消息
+	RecallMessage(messageID string) error
+	// GetMessage 通过 message_id 获取一条消息，用于显示本地没有保存的被回复消息
+	GetMessage(messageID string) (Message, error)
+	// GetForwardMessages 获取合并转发中的消息，forwardID 是 [CQ:forward] 的 id 参数
+	GetForwardMessages(forwardID string) ([]Message, error)
+	// GetUserProfile 获取用户资料，群聊中同时获取群成员信息
+	GetUserProfile(chatID string, chatType string, userID string) (UserProfile, error)
+	// GetGroupMembers 获取群成员列表，只有 QQ 号、昵称、群名片、身份等成员信息
+	GetGroupMembers(groupID string) ([]UserProfile, error)
+	// MuteMember 禁言群成员，duration 为 0 表示解除禁言
+	MuteMember(groupID string, userID string, duration time.Duration) error
 
 	// GetChats 获取分离的好友和群聊列表
 	GetChats() (friends []ChatInfo, groups []ChatInfo, err error)
 
+	// SelfID 返回登录账号的 QQ 号，还没有收到任何事件时为空
+	SelfID() string
+
 	// Listen 开始监听并接收一个 channel，它的职责是把从 WebSocket 收到的消息送入这个 channel
 	Listen(msgChan chan<- Message)
 }
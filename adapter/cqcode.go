@@ -0,0 +1,87 @@
+package adapter
+
+import (
+	"sort"
+	"strings"
+)
+
+// Segment 是消息中的一个片段：纯文本或一个 CQ 码
+type Segment struct {
+	Type string            // "text" 表示纯文本，其余为 CQ 码类型，如 "image"、"at"
+	Data map[string]string // CQ 码参数；纯文本片段的内容保存在 Data["text"]
+}
+
+// Text 返回纯文本片段的内容
+func (s Segment) Text() string { return s.Data["text"] }
+
+// ParseCQ 把带 CQ 码的消息字符串拆分为片段，并还原转义字符
+func ParseCQ(content string) []Segment {
+	var segments []Segment
+	for len(content) > 0 {
+		start := strings.Index(content, "[CQ:")
+		if start < 0 {
+			segments = append(segments, textSegment(content))
+			break
+		}
+		end := strings.IndexByte(content[start:], ']')
+		if end < 0 {
+			segments = append(segments, textSegment(content))
+			break
+		}
+		if start > 0 {
+			segments = append(segments, textSegment(content[:start]))
+		}
+		segments = append(segments, parseCQCode(content[start+len("[CQ:"):start+end]))
+		content = content[start+end+1:]
+	}
+	return segments
+}
+
+// FormatCQ 把一个 CQ 码片段序列化为字符串，纯文本片段只做转义
+func FormatCQ(seg Segment) string {
+	if seg.Type == "text" {
+		return EscapeCQText(seg.Text())
+	}
+	var b strings.Builder
+	keys := make([]string, 0, len(seg.Data))
+	for k := range seg.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString("[CQ:" + seg.Type)
+	for _, k := range keys {
+		b.WriteString("," + k + "=" + EscapeCQParam(seg.Data[k]))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func textSegment(text string) Segment {
+	return Segment{Type: "text", Data: map[string]string{"text": UnescapeCQ(text)}}
+}
+
+func parseCQCode(body string) Segment {
+	parts := strings.Split(body, ",")
+	seg := Segment{Type: parts[0], Data: make(map[string]string, len(parts)-1)}
+	for _, part := range parts[1:] {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			seg.Data[k] = UnescapeCQ(v)
+		}
+	}
+	return seg
+}
+
+var (
+	cqUnescaper    = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&amp;", "&")
+	cqTextEscaper  = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+	cqParamEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+)
+
+// UnescapeCQ 还原 CQ 码中的转义字符
+func UnescapeCQ(s string) string { return cqUnescaper.Replace(s) }
+
+// EscapeCQText 转义纯文本中会被误认为 CQ 码的字符
+func EscapeCQText(s string) string { return cqTextEscaper.Replace(s) }
+
+// EscapeCQParam 转义 CQ 码参数值
+func EscapeCQParam(s string) string { return cqParamEscaper.Replace(s) }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,6 +29,8 @@ type NapCatAdapter struct {
 	writeMutex       sync.Mutex
 	responseChannels sync.Map
 	echoCounter      int64
+	noticeHandler    func(Notice)
+	selfID           atomic.Int64 // 登录账号的 QQ 号，从事件的 self_id 中得到
 }
 
 // --- 新增的 API 响应结构体 ---
@@ -35,6 +38,110 @@ type friendListResp struct {
 	Data []struct {
 		UserID   int64  `json:"user_id"`
 		Nickname string `json:"nickname"`
+		Remark   string `json:"remark"`
+	} `json:"data"`
+}
+
+// actionResp 是所有 API 响应共有的状态字段
+type actionResp struct {
+	Status  string `json:"status"`
+	RetCode int    `json:"retcode"`
+	Message string `json:"message"`
+	Wording string `json:"wording"`
+}
+
+func (r actionResp) err() error {
+	if r.Status == "" || r.Status == "ok" || r.Status == "async" {
+		return nil
+	}
+	reason := r.Wording
+	if reason == "" {
+		reason = r.Message
+	}
+	return fmt.Errorf("retcode %d: %s", r.RetCode, reason)
+}
+
+type sendMsgResp struct {
+	actionResp
+	Data struct {
+		MessageID int64 `json:"message_id"`
+	} `json:"data"`
+}
+
+type messageSegment struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+type forwardNode struct {
+	Sender struct {
+		UserID   int64  `json:"user_id"`
+		Nickname string `json:"nickname"`
+	} `json:"sender"`
+	Time       int64            `json:"time"`
+	RawMessage string           `json:"raw_message"`
+	Content    []messageSegment `json:"content"`
+	Message    []messageSegment `json:"message"`
+}
+
+type getMsgResp struct {
+	actionResp
+	Data struct {
+		MessageID   int64           `json:"message_id"`
+		Time        int64           `json:"time"`
+		MessageType string          `json:"message_type"`
+		GroupID     int64           `json:"group_id"`
+		UserID      int64           `json:"user_id"`
+		RawMessage  string          `json:"raw_message"`
+		Message     json.RawMessage `json:"message"`
+		Sender      struct {
+			UserID   int64  `json:"user_id"`
+			Nickname string `json:"nickname"`
+			Card     string `json:"card"`
+		} `json:"sender"`
+	} `json:"data"`
+}
+
+type forwardMsgResp struct {
+	actionResp
+	Data struct {
+		Messages []forwardNode `json:"messages"`
+		Message  []forwardNode `json:"message"`
+	} `json:"data"`
+}
+
+type strangerInfoResp struct {
+	actionResp
+	Data struct {
+		Nickname string `json:"nickname"`
+		Sex      string `json:"sex"`
+		Age      int    `json:"age"`
+		Level    int    `json:"level"`
+		LongNick string `json:"long_nick"`
+	} `json:"data"`
+}
+
+type groupMemberInfoResp struct {
+	actionResp
+	Data struct {
+		Card         string `json:"card"`
+		Role         string `json:"role"`
+		Title        string `json:"title"`
+		JoinTime     int64  `json:"join_time"`
+		LastSentTime int64  `json:"last_sent_time"`
+	} `json:"data"`
+}
+
+type groupMemberListResp struct {
+	actionResp
+	Data []struct {
+		UserID       int64  `json:"user_id"`
+		Nickname     string `json:"nickname"`
+		Card         string `json:"card"`
+		Role         string `json:"role"`
+		Title        string `json:"title"`
+		JoinTime     int64  `json:"join_time"`
+		LastSentTime int64  `json:"last_sent_time"`
 	} `json:"data"`
 }
 
@@ -89,6 +196,10 @@ func (n *NapCatAdapter) Listen(msgChan chan<- Message) {
 				log.Printf("Adapter failed to unmarshal raw json: %v", err)
 				continue
 			}
+			// 每个事件（包括连接后的 lifecycle 事件）都带有 self_id
+			if selfID, ok := raw["self_id"].(float64); ok && selfID != 0 {
+				n.selfID.Store(int64(selfID))
+			}
 			if echo, ok := raw["echo"].(string); ok && echo != "" {
 				if ch, loaded := n.responseChannels.Load(echo); loaded {
 					ch.(chan []byte) <- payload
@@ -97,15 +208,103 @@ func (n *NapCatAdapter) Listen(msgChan chan<- Message) {
 				switch postType {
 				case "message":
 					n.handleMessageEvent(raw, msgChan)
+				case "notice":
+					if n.noticeHandler != nil {
+						if notice, ok := parseNotice(raw); ok {
+							n.noticeHandler(notice)
+						}
+					}
 				}
 			}
 		}
 	}()
 }
 
+// SelfID 返回登录账号的 QQ 号，还没有收到事件时为空
+func (n *NapCatAdapter) SelfID() string {
+	if id := n.selfID.Load(); id != 0 {
+		return strconv.FormatInt(id, 10)
+	}
+	return ""
+}
+
+// OnNotice 设置通知事件的回调，需在 Listen 之前调用
+func (n *NapCatAdapter) OnNotice(handler func(Notice)) { n.noticeHandler = handler }
+
+// parseNotice 把 OneBot 的 notice 事件转换为 Notice，不关心的事件返回 false
+func parseNotice(raw map[string]interface{}) (Notice, bool) {
+	idOf := func(key string) string {
+		if v, ok := raw[key].(float64); ok && v != 0 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return ""
+	}
+	notice := Notice{
+		Time:       time.Now(),
+		UserID:     idOf("user_id"),
+		OperatorID: idOf("operator_id"),
+	}
+	notice.Type, _ = raw["notice_type"].(string)
+	notice.SubType, _ = raw["sub_type"].(string)
+	if groupID := idOf("group_id"); groupID != "" {
+		notice.ChatID, notice.ChatType = groupID, "group"
+	} else {
+		notice.ChatID, notice.ChatType = notice.UserID, "private"
+	}
+
+	switch notice.Type {
+	case "group_increase":
+		notice.Text = notice.UserID + " 加入了群聊"
+	case "group_decrease":
+		if notice.SubType == "kick" || notice.SubType == "kick_me" {
+			notice.Text = notice.UserID + " 被 " + notice.OperatorID + " 移出了群聊"
+		} else {
+			notice.Text = notice.UserID + " 退出了群聊"
+		}
+	case "group_recall", "friend_recall":
+		notice.Text = notice.UserID + " 撤回了一条消息"
+		if notice.OperatorID != "" && notice.OperatorID != notice.UserID {
+			notice.Text = notice.OperatorID + " 撤回了 " + notice.UserID + " 的一条消息"
+		}
+	case "group_ban":
+		if notice.SubType == "lift_ban" {
+			notice.Text = notice.OperatorID + " 解除了 " + notice.UserID + " 的禁言"
+		} else {
+			duration, _ := raw["duration"].(float64)
+			notice.Text = fmt.Sprintf("%s 禁言了 %s %v", notice.OperatorID, notice.UserID, time.Duration(duration)*time.Second)
+		}
+	case "group_admin":
+		if notice.SubType == "set" {
+			notice.Text = notice.UserID + " 成为了管理员"
+		} else {
+			notice.Text = notice.UserID + " 不再是管理员"
+		}
+	case "group_upload":
+		notice.Text = notice.UserID + " 上传了文件"
+		if file, ok := raw["file"].(map[string]interface{}); ok {
+			if name, ok := file["name"].(string); ok {
+				notice.Text += " " + name
+			}
+		}
+	case "friend_add":
+		notice.Text = notice.UserID + " 添加了你为好友"
+	case "notify":
+		if notice.SubType != "poke" {
+			return notice, false
+		}
+		notice.Text = notice.UserID + " 戳了戳 " + idOf("target_id")
+	default:
+		return notice, false
+	}
+	return notice, notice.ChatID != ""
+}
+
 func (n *NapCatAdapter) handleMessageEvent(raw map[string]interface{}, msgChan chan<- Message) {
 	var msg Message
 	msg.Time = time.Now()
+	if messageID, ok := raw["message_id"].(float64); ok {
+		msg.MessageID = strconv.FormatInt(int64(messageID), 10)
+	}
 	if message, ok := raw["raw_message"].(string); ok {
 		msg.Content = message
 	}
@@ -127,13 +326,23 @@ func (n *NapCatAdapter) handleMessageEvent(raw map[string]interface{}, msgChan c
 			msg.ChatID = msg.SenderID
 		}
 	}
+	if selfID, ok := raw["self_id"].(float64); ok {
+		msg.Mentioned = isMentioned(msg.Content, strconv.FormatInt(int64(selfID), 10))
+	}
 	if msg.ChatID != "" {
 		msgChan <- msg
 	}
 }
 
-func (n *NapCatAdapter) SendMessage(chatID string, chatType string, message string) error {
-	action := onebotAction{Echo: "send_msg_" + chatID}
+// isMentioned 判断消息内容中是否包含 @自己 或 @全体成员 的 CQ 码
+func isMentioned(content string, selfID string) bool {
+	return strings.Contains(content, "[CQ:at,qq="+selfID+"]") ||
+		strings.Contains(content, "[CQ:at,qq="+selfID+",") ||
+		strings.Contains(content, "[CQ:at,qq=all")
+}
+
+func (n *NapCatAdapter) SendMessage(chatID string, chatType string, message string) (string, error) {
+	var action onebotAction
 	if chatType == "group" {
 		groupID, _ := strconv.ParseInt(chatID, 10, 64)
 		action.Action = "send_group_msg"
@@ -149,7 +358,248 @@ func (n *NapCatAdapter) SendMessage(chatID string, chatType string, message stri
 			Message string `json:"message"`
 		}{UserID: userID, Message: message}
 	}
-	return n.sendAction(action)
+	respPayload, err := n.sendRequest(action)
+	if err != nil {
+		return "", err
+	}
+	var resp sendMsgResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return "", err
+	}
+	if err := resp.err(); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(resp.Data.MessageID, 10), nil
+}
+
+func (n *NapCatAdapter) RecallMessage(messageID string) error {
+	id, err := strconv.ParseInt(messageID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid message id %q", messageID)
+	}
+	respPayload, err := n.sendRequest(onebotAction{Action: "delete_msg", Params: map[string]int64{"message_id": id}})
+	if err != nil {
+		return err
+	}
+	var resp actionResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return err
+	}
+	return resp.err()
+}
+
+func (n *NapCatAdapter) GetMessage(messageID string) (Message, error) {
+	id, err := strconv.ParseInt(messageID, 10, 64)
+	if err != nil {
+		return Message{}, fmt.Errorf("invalid message id %q", messageID)
+	}
+	respPayload, err := n.sendRequest(onebotAction{Action: "get_msg", Params: map[string]int64{"message_id": id}})
+	if err != nil {
+		return Message{}, err
+	}
+	var resp getMsgResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return Message{}, err
+	}
+	if err := resp.err(); err != nil {
+		return Message{}, err
+	}
+	d := resp.Data
+	msg := Message{
+		MessageID:  messageID,
+		ChatType:   d.MessageType,
+		SenderID:   strconv.FormatInt(d.Sender.UserID, 10),
+		SenderName: d.Sender.Card,
+		Content:    d.RawMessage,
+		Time:       time.Unix(d.Time, 0),
+	}
+	if d.Sender.UserID == 0 {
+		msg.SenderID = strconv.FormatInt(d.UserID, 10)
+	}
+	if msg.SenderName == "" {
+		msg.SenderName = d.Sender.Nickname
+	}
+	if d.MessageType == "group" {
+		msg.ChatID = strconv.FormatInt(d.GroupID, 10)
+	} else {
+		msg.ChatID = msg.SenderID
+	}
+	if msg.Content == "" {
+		// message 可能是 CQ 码字符串，也可能是消息段数组
+		var segments []messageSegment
+		if err := json.Unmarshal(d.Message, &segments); err == nil {
+			msg.Content = segmentsToCQ(segments)
+		} else {
+			json.Unmarshal(d.Message, &msg.Content)
+		}
+	}
+	return msg, nil
+}
+
+func (n *NapCatAdapter) GetForwardMessages(forwardID string) ([]Message, error) {
+	respPayload, err := n.sendRequest(onebotAction{Action: "get_forward_msg", Params: map[string]string{"id": forwardID}})
+	if err != nil {
+		return nil, err
+	}
+	var resp forwardMsgResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return nil, err
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	nodes := resp.Data.Messages
+	if len(nodes) == 0 {
+		nodes = resp.Data.Message // go-cqhttp 使用 message 字段
+	}
+	messages := make([]Message, 0, len(nodes))
+	for _, node := range nodes {
+		msg := Message{
+			SenderID:   strconv.FormatInt(node.Sender.UserID, 10),
+			SenderName: node.Sender.Nickname,
+			Time:       time.Unix(node.Time, 0),
+			Content:    node.RawMessage,
+		}
+		if msg.Content == "" {
+			msg.Content = segmentsToCQ(node.Content)
+		}
+		if msg.Content == "" {
+			msg.Content = segmentsToCQ(node.Message)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (n *NapCatAdapter) GetUserProfile(chatID string, chatType string, userID string) (UserProfile, error) {
+	profile := UserProfile{UserID: userID}
+	uid, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return profile, fmt.Errorf("invalid user id %q", userID)
+	}
+	respPayload, err := n.sendRequest(onebotAction{Action: "get_stranger_info", Params: map[string]int64{"user_id": uid}})
+	if err != nil {
+		return profile, err
+	}
+	var stranger strangerInfoResp
+	if err := json.Unmarshal(respPayload, &stranger); err != nil {
+		return profile, err
+	}
+	if err := stranger.err(); err != nil {
+		return profile, err
+	}
+	profile.Nickname = stranger.Data.Nickname
+	profile.Sex = stranger.Data.Sex
+	profile.Age = stranger.Data.Age
+	profile.Level = stranger.Data.Level
+	profile.Sign = stranger.Data.LongNick
+	if chatType != "group" {
+		return profile, nil
+	}
+
+	// 群成员信息获取失败时仍然返回陌生人信息
+	groupID, _ := strconv.ParseInt(chatID, 10, 64)
+	respPayload, err = n.sendRequest(onebotAction{Action: "get_group_member_info", Params: map[string]int64{"group_id": groupID, "user_id": uid}})
+	if err != nil {
+		return profile, err
+	}
+	var member groupMemberInfoResp
+	if err := json.Unmarshal(respPayload, &member); err != nil {
+		return profile, err
+	}
+	if err := member.err(); err != nil {
+		return profile, err
+	}
+	profile.Card = member.Data.Card
+	profile.Role = member.Data.Role
+	profile.Title = member.Data.Title
+	if member.Data.JoinTime > 0 {
+		profile.JoinTime = time.Unix(member.Data.JoinTime, 0)
+	}
+	if member.Data.LastSentTime > 0 {
+		profile.LastSentTime = time.Unix(member.Data.LastSentTime, 0)
+	}
+	return profile, nil
+}
+
+func (n *NapCatAdapter) GetGroupMembers(groupID string) ([]UserProfile, error) {
+	gid, err := strconv.ParseInt(groupID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group id %q", groupID)
+	}
+	respPayload, err := n.sendRequest(onebotAction{Action: "get_group_member_list", Params: map[string]int64{"group_id": gid}})
+	if err != nil {
+		return nil, err
+	}
+	var resp groupMemberListResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return nil, err
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	members := make([]UserProfile, 0, len(resp.Data))
+	for _, d := range resp.Data {
+		p := UserProfile{
+			UserID:   strconv.FormatInt(d.UserID, 10),
+			Nickname: d.Nickname,
+			Card:     d.Card,
+			Role:     d.Role,
+			Title:    d.Title,
+		}
+		if d.JoinTime > 0 {
+			p.JoinTime = time.Unix(d.JoinTime, 0)
+		}
+		if d.LastSentTime > 0 {
+			p.LastSentTime = time.Unix(d.LastSentTime, 0)
+		}
+		members = append(members, p)
+	}
+	return members, nil
+}
+
+func (n *NapCatAdapter) MuteMember(groupID string, userID string, duration time.Duration) error {
+	gid, err := strconv.ParseInt(groupID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid group id %q", groupID)
+	}
+	uid, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q", userID)
+	}
+	// OneBot 的禁言时长以秒为单位，0 表示解除
+	params := map[string]int64{"group_id": gid, "user_id": uid, "duration": int64(duration / time.Second)}
+	respPayload, err := n.sendRequest(onebotAction{Action: "set_group_ban", Params: params})
+	if err != nil {
+		return err
+	}
+	var resp actionResp
+	if err := json.Unmarshal(respPayload, &resp); err != nil {
+		return err
+	}
+	return resp.err()
+}
+
+// segmentsToCQ 把数组格式的消息段转换为 CQ 码字符串
+func segmentsToCQ(segments []messageSegment) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		data := make(map[string]string, len(seg.Data))
+		for k, v := range seg.Data {
+			switch v := v.(type) {
+			case string:
+				data[k] = v
+			case float64:
+				data[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			case nil:
+			default:
+				raw, _ := json.Marshal(v)
+				data[k] = string(raw)
+			}
+		}
+		b.WriteString(FormatCQ(Segment{Type: seg.Type, Data: data}))
+	}
+	return b.String()
 }
 
 func (n *NapCatAdapter) GetChats() (friends []ChatInfo, groups []ChatInfo, err error) {
@@ -174,9 +624,10 @@ func (n *NapCatAdapter) GetChats() (friends []ChatInfo, groups []ChatInfo, err e
 		}
 		for _, f := range resp.Data {
 			friends = append(friends, ChatInfo{
-				ID:   strconv.FormatInt(f.UserID, 10),
-				Name: f.Nickname,
-				Type: "private",
+				ID:     strconv.FormatInt(f.UserID, 10),
+				Name:   f.Nickname,
+				Remark: f.Remark,
+				Type:   "private",
 			})
 		}
 	}()
@@ -5,12 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/stats"
+	"golang.org/x/term"
 )
 
 const apiBaseURL = "http://localhost:9090"
@@ -33,6 +39,9 @@ func main() {
 			fmt.Println("--- 群聊 / 好友 ---")
 			for _, chat := range chats {
 				fmt.Printf("类型: %-7s | ID: %-12s | 名称: %s\n", chat.Type, chat.ID, chat.Name)
+				if chat.LatestMsg != "" {
+					fmt.Printf("    └ %s\n", chat.LatestMsg)
+				}
 			}
 		},
 	}
@@ -69,6 +78,290 @@ func main() {
 		},
 	}
 
-	rootCmd.AddCommand(listCmd, useCmd, sendCmd)
+	var markRead string
+	var unreadCmd = &cobra.Command{
+		Use:   "unread",
+		Short: "列出有未读消息的聊天",
+		Run: func(cmd *cobra.Command, args []string) {
+			if markRead != "" {
+				resp, err := http.Post(apiBaseURL+"/mark_read?id="+markRead, "", nil)
+				if err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+				defer resp.Body.Close()
+				io.Copy(os.Stdout, resp.Body)
+				return
+			}
+
+			resp, err := http.Get(apiBaseURL + "/unread")
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			var entries []struct {
+				ChatID   string `json:"chat_id"`
+				ChatType string `json:"chat_type"`
+				Name     string `json:"name"`
+				Unread   int    `json:"unread"`
+				Mentions int    `json:"mentions"`
+			}
+			json.NewDecoder(resp.Body).Decode(&entries)
+			if len(entries) == 0 {
+				fmt.Println("没有未读消息")
+				return
+			}
+			fmt.Println("--- 未读消息 ---")
+			for _, e := range entries {
+				fmt.Printf("类型: %-7s | ID: %-12s | 未读: %-5d | @我: %-3d | 名称: %s\n", e.ChatType, e.ChatID, e.Unread, e.Mentions, e.Name)
+			}
+		},
+	}
+	unreadCmd.Flags().StringVar(&markRead, "mark", "", "将指定 ID 的聊天标记为已读")
+
+	var exportChat, exportSince, exportUntil, exportFormat, exportOutput string
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "导出聊天记录 (jsonl / markdown / html / text)",
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			query.Set("chat", exportChat)
+			query.Set("since", exportSince)
+			query.Set("until", exportUntil)
+			query.Set("format", exportFormat)
+			resp, err := http.Get(apiBaseURL + "/export?" + query.Encode())
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				fmt.Print("Error: ")
+				io.Copy(os.Stdout, resp.Body)
+				return
+			}
+
+			if exportOutput == "-" {
+				io.Copy(os.Stdout, resp.Body)
+				warnMissingImages(resp)
+				return
+			}
+			if exportOutput == "" {
+				_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+				exportOutput = params["filename"]
+			}
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer f.Close()
+			n, err := io.Copy(f, resp.Body)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			fmt.Printf("已导出 %d 字节到 %s\n", n, exportOutput)
+			warnMissingImages(resp)
+		},
+	}
+	exportCmd.Flags().StringVar(&exportChat, "chat", "", "要导出的群号或 QQ 号")
+	exportCmd.Flags().StringVar(&exportSince, "since", "", "起始日期，如 2024-01-01")
+	exportCmd.Flags().StringVar(&exportUntil, "until", "", "结束日期（包含当天），如 2024-12-31")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "jsonl", "导出格式: jsonl, markdown, html, text")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "输出文件，默认为 <ID>.<扩展名>，- 表示标准输出")
+	exportCmd.MarkFlagRequired("chat")
+
+	var searchChat, searchSender string
+	var searchLimit int
+	var searchCmd = &cobra.Command{
+		Use:   "search [关键词]",
+		Short: "搜索聊天记录",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			query.Set("q", strings.Join(args, " "))
+			query.Set("chat", searchChat)
+			query.Set("sender", searchSender)
+			query.Set("limit", fmt.Sprint(searchLimit))
+			resp, err := http.Get(apiBaseURL + "/search?" + query.Encode())
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			var messages []adapter.Message
+			if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			for i := len(messages) - 1; i >= 0; i-- {
+				msg := messages[i]
+				fmt.Printf("%s | %-12s | %s: %s\n", msg.Time.Format("2006-01-02 15:04"), msg.ChatID, msg.SenderName, msg.Content)
+			}
+			fmt.Printf("--- 共 %d 条 ---\n", len(messages))
+		},
+	}
+	searchCmd.Flags().StringVar(&searchChat, "chat", "", "只搜索指定的群号或 QQ 号")
+	searchCmd.Flags().StringVar(&searchSender, "sender", "", "只搜索指定发送者的消息")
+	searchCmd.Flags().IntVar(&searchLimit, "limit", 50, "最多返回的条数")
+
+	var statsSince, statsUntil string
+	var statsTop int
+	var statsCmd = &cobra.Command{
+		Use:   "stats [群号或QQ号]",
+		Short: "统计聊天的活跃成员、活跃时段和热词",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			query.Set("chat", args[0])
+			query.Set("since", statsSince)
+			query.Set("until", statsUntil)
+			query.Set("top", fmt.Sprint(statsTop))
+			resp, err := http.Get(apiBaseURL + "/stats?" + query.Encode())
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				fmt.Println("Error:", strings.TrimSpace(string(body)))
+				return
+			}
+			var report stats.Report
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			width, _, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil {
+				width = 80
+			}
+			fmt.Print(stats.Render(&report, width))
+		},
+	}
+	statsCmd.Flags().StringVar(&statsSince, "since", "", "起始日期，如 2024-01-01")
+	statsCmd.Flags().StringVar(&statsUntil, "until", "", "结束日期（包含当天），如 2024-12-31")
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "活跃成员和热词各显示多少个")
+
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "数据库维护",
+	}
+
+	var dbStatsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "显示数据库大小以及每个聊天占用的空间",
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := http.Get(apiBaseURL + "/db/stats")
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			var stats struct {
+				Path      string `json:"path"`
+				FileSize  int64  `json:"file_size"`
+				WALSize   int64  `json:"wal_size"`
+				PageSize  int64  `json:"page_size"`
+				FreePages int64  `json:"free_pages"`
+				Messages  int64  `json:"messages"`
+				Chats     []struct {
+					ChatID   string    `json:"chat_id"`
+					ChatType string    `json:"chat_type"`
+					Name     string    `json:"name"`
+					Messages int64     `json:"messages"`
+					Bytes    int64     `json:"bytes"`
+					Oldest   time.Time `json:"oldest"`
+				} `json:"chats"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			fmt.Printf("数据库: %s\n", stats.Path)
+			fmt.Printf("文件大小: %s | WAL: %s | 可回收: %s | 消息总数: %d\n",
+				formatBytes(stats.FileSize), formatBytes(stats.WALSize), formatBytes(stats.FreePages*stats.PageSize), stats.Messages)
+			fmt.Println("--- 各聊天占用 ---")
+			for _, c := range stats.Chats {
+				fmt.Printf("类型: %-7s | ID: %-12s | 消息: %-7d | 大小: %-9s | 最早: %s | 名称: %s\n",
+					c.ChatType, c.ChatID, c.Messages, formatBytes(c.Bytes), c.Oldest.Format("2006-01-02"), c.Name)
+			}
+		},
+	}
+	var dbWriterCmd = &cobra.Command{
+		Use:   "writer",
+		Short: "显示消息写入队列的状态",
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := http.Get(apiBaseURL + "/db/writer")
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			var stats struct {
+				Queued    int       `json:"queued"`
+				Written   int64     `json:"written"`
+				Batches   int64     `json:"batches"`
+				Failed    int64     `json:"failed"`
+				LastError string    `json:"last_error"`
+				ErrorTime time.Time `json:"error_time"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			fmt.Printf("排队: %d | 已写入: %d（%d 个事务） | 失败: %d\n", stats.Queued, stats.Written, stats.Batches, stats.Failed)
+			if stats.LastError != "" {
+				fmt.Printf("最近错误: %s %s\n", stats.ErrorTime.Format("2006-01-02 15:04:05"), stats.LastError)
+			}
+		},
+	}
+	var dbBackupCmd = &cobra.Command{
+		Use:   "backup [文件名]",
+		Short: "在守护进程运行时安全地备份数据库到配置的备份目录",
+		Long:  "在守护进程运行时安全地备份数据库。备份写到 config.yml 中 backup.dir 指定的目录，不给文件名时使用带时间戳的文件名。",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			params := url.Values{}
+			if len(args) == 1 {
+				params.Set("name", args[0])
+			}
+			resp, err := http.Post(apiBaseURL+"/db/backup?"+params.Encode(), "text/plain", nil)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			fmt.Print(string(body))
+		},
+	}
+	dbCmd.AddCommand(dbStatsCmd, dbWriterCmd, dbBackupCmd)
+
+	rootCmd.AddCommand(listCmd, useCmd, sendCmd, unreadCmd, exportCmd, searchCmd, statsCmd, dbCmd)
 	rootCmd.Execute()
 }
+
+// formatBytes 把字节数格式化为便于阅读的 KiB/MiB/GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// warnMissingImages 提示 HTML 导出中没能内联、以文字占位的图片；需要在读完正文后调用
+func warnMissingImages(resp *http.Response) {
+	if n, _ := strconv.Atoi(resp.Trailer.Get("X-Missing-Images")); n > 0 {
+		fmt.Fprintf(os.Stderr, "注意: %d 张图片无法获取，已在文件中以文字代替\n", n)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// openBackend 按配置打开存储后端。只有 SQLite 后端支持导入、保留策略、媒体缓存和加密，
+// 因此同时返回 *storage.Store；使用其他后端时它为 nil。
+func openBackend(cfg *config.Config, incognito bool) (storage.Backend, *storage.Store, error) {
+	backend := cfg.Storage.Backend
+	if incognito {
+		backend = "memory"
+	}
+
+	switch backend {
+	case "", "sqlite":
+		// 一直持有到进程退出，恢复备份等离线操作据此判断守护进程在运行
+		lock, err := storage.LockDatabase(cfg.DatabasePath)
+		if errors.Is(err, storage.ErrInUse) {
+			return nil, nil, fmt.Errorf("%s is in use, is another daemon running?", cfg.DatabasePath)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := openStore(cfg)
+		if err != nil {
+			lock.Close()
+			return nil, nil, err
+		}
+		daemonLock = lock
+		return store, store, nil
+	case "memory":
+		log.Println("Using in-memory storage, nothing will be written to disk.")
+		return storage.NewMemoryStore(), nil, nil
+	case "postgres":
+		if cfg.Storage.PostgresDSN == "" {
+			return nil, nil, errors.New("storage.postgresDsn is required for the postgres backend")
+		}
+		pg, err := storage.NewPostgresStore(cfg.Storage.PostgresDSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pg, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown storage backend %q (want sqlite, memory or postgres)", backend)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// runBackups 按配置定期把数据库备份到 cfg.Dir，并轮换旧备份
+func runBackups(store *storage.Store, cfg config.BackupConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		start := time.Now()
+		path, err := store.BackupTo(cfg.Dir, cfg.Keep, start)
+		if err != nil {
+			log.Printf("Backup: failed: %v", err)
+			continue
+		}
+		log.Printf("Backup: wrote %s in %v", path, time.Since(start))
+	}
+}
+
+// runRestore 实现 `onebot-tui-daemon restore <备份文件>` 子命令
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: onebot-tui-daemon restore <备份文件>")
+		fmt.Fprintln(fs.Output(), "用备份替换当前数据库，原数据库会改名保留。运行前请先停止守护进程。")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	backupPath := fs.Arg(0)
+
+	cfg, err := config.LoadConfig("config.yml")
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// 守护进程还在运行时替换数据库会损坏数据
+	lock, err := lockDatabase(cfg, "restoring")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	info, err := storage.CheckBackup(backupPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("备份包含 %d 条消息（结构版本 %d", info.Messages, info.SchemaVersion)
+	if info.Encrypted {
+		fmt.Print("，已加密")
+	}
+	fmt.Println("）")
+
+	saved, err := storage.Restore(backupPath, cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	if saved != "" {
+		fmt.Printf("原数据库已保存为 %s\n", saved)
+	}
+	fmt.Printf("已从 %s 恢复到 %s\n", backupPath, cfg.DatabasePath)
+	return nil
+}
+
+// daemonLock 是守护进程运行期间一直持有的数据库锁，见 lockDatabase
+var daemonLock *storage.DBLock
+
+// lockDatabase 为离线操作锁定数据库，守护进程正在使用它时返回错误；action 用于错误信息
+func lockDatabase(cfg *config.Config, action string) (*storage.DBLock, error) {
+	lock, err := storage.LockDatabase(cfg.DatabasePath)
+	if errors.Is(err, storage.ErrInUse) {
+		return nil, fmt.Errorf("the daemon is running, stop it before %s", action)
+	}
+	return lock, err
+}
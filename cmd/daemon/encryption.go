@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+
+	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// openStore 打开数据库；数据库已加密时用密钥文件或终端输入的口令解锁它
+func openStore(cfg *config.Config) (*storage.Store, error) {
+	store, err := storage.NewStore(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := store.Encrypted()
+	if err != nil || !encrypted {
+		return store, err
+	}
+
+	if cfg.Encryption.KeyFile != "" {
+		secret, err := readKeyFile(cfg.Encryption.KeyFile)
+		if err == nil {
+			err = store.Unlock(secret)
+		}
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		secret, err := promptPassphrase("数据库已加密，请输入口令: ")
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		err = store.Unlock(secret)
+		if err == nil {
+			return store, nil
+		}
+		if !errors.Is(err, storage.ErrWrongKey) {
+			store.Close()
+			return nil, err
+		}
+		fmt.Println("口令错误。")
+	}
+	store.Close()
+	return nil, storage.ErrWrongKey
+}
+
+// runRekey 实现 `onebot-tui-daemon rekey` 子命令：为未加密的数据库启用加密、
+// 更换口令或密钥文件，或者用 --decrypt 关闭加密
+func runRekey(args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	newKeyFile := fs.String("new-keyfile", "", "从文件读取新的密钥，而不是输入口令")
+	decrypt := fs.Bool("decrypt", false, "解密所有消息并关闭加密")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: onebot-tui-daemon rekey [选项]")
+		fmt.Fprintln(fs.Output(), "为数据库启用加密、更换口令，或关闭加密。运行前请先停止守护进程。")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig("config.yml")
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// 守护进程打开着数据库时，它写入的新消息不会被重新加密
+	lock, err := lockDatabase(cfg, "rekeying")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var secret []byte
+	switch {
+	case *decrypt:
+		secret = nil
+	case *newKeyFile != "":
+		if secret, err = readKeyFile(*newKeyFile); err != nil {
+			return err
+		}
+	default:
+		if secret, err = promptPassphrase("新口令: "); err != nil {
+			return err
+		}
+		confirm, err := promptPassphrase("再次输入新口令: ")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(secret, confirm) {
+			return errors.New("两次输入的口令不一致")
+		}
+		if len(secret) == 0 {
+			return errors.New("口令不能为空，如需关闭加密请使用 --decrypt")
+		}
+	}
+
+	fmt.Println("正在重新加密所有消息，请稍候...")
+	if err := store.Rekey(secret); err != nil {
+		return err
+	}
+	if *decrypt {
+		fmt.Println("已关闭加密，消息以明文保存。")
+	} else {
+		fmt.Println("完成。")
+		if *newKeyFile != "" && cfg.Encryption.KeyFile != *newKeyFile {
+			fmt.Printf("提示: 请把 config.yml 中的 encryption.keyFile 设置为 %s\n", *newKeyFile)
+		}
+	}
+	return nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("key file is empty")
+	}
+	return data, nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	defer fmt.Println()
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
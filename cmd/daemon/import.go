@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// runImport 实现 `onebot-tui-daemon import <file>` 子命令
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "auto", "文件格式: auto, jsonl, qq-txt, qq-mht, gocq-log, napcat-log")
+	chatID := fs.String("chat", "", "导入到的群号或 QQ 号（QQ 导出的 txt/mht 文件必填）")
+	chatType := fs.String("type", "group", "聊天类型: group 或 private")
+	senderMap := fs.String("sender-map", "", "YAML 文件，将昵称映射为 QQ 号")
+	dryRun := fs.Bool("dry-run", false, "只解析和查重，不写入数据库")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: onebot-tui-daemon import [选项] <文件>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	cfg, err := config.LoadConfig("config.yml")
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	opts := storage.ImportOptions{
+		Format:   storage.ImportFormat(*format),
+		ChatID:   *chatID,
+		ChatType: *chatType,
+		DryRun:   *dryRun,
+		Progress: func(st storage.ImportStats) {
+			fmt.Printf("\r已解析 %d 条，导入 %d 条，重复 %d 条，跳过 %d 条", st.Parsed, st.Imported, st.Duplicates, st.Skipped)
+		},
+	}
+	if *senderMap != "" {
+		data, err := os.ReadFile(*senderMap)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &opts.SenderMap); err != nil {
+			return fmt.Errorf("failed to parse sender map: %w", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if opts.Format == storage.ImportAuto {
+		head, _ := br.Peek(4096)
+		opts.Format = storage.DetectImportFormat(path, head)
+		fmt.Printf("检测到文件格式: %s\n", opts.Format)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to init db: %w", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Import(br, opts)
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("试运行完成，未写入数据库：将导入 %d 条消息（重复 %d 条，跳过 %d 条）\n", stats.Imported, stats.Duplicates, stats.Skipped)
+	} else {
+		fmt.Printf("导入完成：共导入 %d 条消息（重复 %d 条，跳过 %d 条）\n", stats.Imported, stats.Duplicates, stats.Skipped)
+	}
+	return nil
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +18,8 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/ziyi233/onebot-tui/adapter"
 	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/media"
+	"github.com/ziyi233/onebot-tui/stats"
 	"github.com/ziyi233/onebot-tui/storage"
 	"github.com/ziyi233/onebot-tui/tui"
 	"gopkg.in/yaml.v3"
@@ -42,9 +47,27 @@ func (s *AppState) GetChatName(chatID string) string {
 	return s.ChatNames[chatID]
 }
 
+// SetActiveChat changes the chat that /send_message and the TUI talk to.
+func (s *AppState) SetActiveChat(chatID string) {
+	s.Lock()
+	s.ActiveChatID = chatID
+	s.Unlock()
+	log.Printf("Switched active chat to %s (%s)", chatID, s.GetChatName(chatID))
+}
+
 // corsMiddleware 是一个中间件，用于为所有响应添加 CORS 头
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sameOriginOnly(r.URL.Path) {
+			// 浏览器跨域请求都会带上 Origin，控制器等本地程序不会
+			if r.Header.Get("Origin") != "" {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// 允许任何来源的请求，对于本地开发是安全的
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
@@ -59,6 +82,16 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// sameOriginOnly 报告 path 是否是不允许网页跨域调用的接口：它们返回解密后的聊天记录，
+// 或者会修改数据库
+func sameOriginOnly(path string) bool {
+	switch path {
+	case "/unread", "/mark_read", "/export", "/search", "/stats":
+		return true
+	}
+	return strings.HasPrefix(path, "/db/")
+}
+
 func main() {
 	f, err := os.OpenFile("daemon.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -67,6 +100,28 @@ func main() {
 	defer f.Close()
 	log.SetOutput(f)
 
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "import":
+			run = runImport
+		case "rekey":
+			run = runRekey
+		case "restore":
+			run = runRestore
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	incognito := flag.Bool("incognito", false, "只在内存中保存消息，退出后不留痕迹")
+	flag.Parse()
+
 	var cfg *config.Config
 	if _, err := os.Stat("config.yml"); os.IsNotExist(err) {
 		// If config file does not exist, run the interactive wizard
@@ -82,11 +137,51 @@ func main() {
 		}
 	}
 
-	store, err := storage.NewStore(cfg.DatabasePath)
+	backend, store, err := openBackend(cfg, *incognito)
 	if err != nil {
 		log.Fatalf("Failed to init db: %v", err)
 	}
-	defer store.Close()
+	defer backend.Close()
+
+	var cache *media.Cache
+	if cfg.Media.Enabled && store == nil {
+		log.Println("Media cache is only supported with the sqlite backend, disabling it.")
+	} else if cfg.Media.Enabled {
+		cache, err = media.New(media.Options{
+			Dir:          cfg.Media.Dir,
+			MaxFileSize:  cfg.Media.MaxFileSize,
+			MaxTotalSize: cfg.Media.MaxTotalSize,
+			Types:        cfg.Media.Types,
+			Chats:        cfg.Media.Chats,
+		}, store)
+		if err != nil {
+			log.Fatalf("Failed to init media cache: %v", err)
+		}
+		cache.Start(2)
+		defer cache.Stop()
+	}
+
+	// Incoming messages are queued and written in batches so a slow disk never stalls the WebSocket read loop
+	var p *tea.Program
+	stored := make(chan adapter.Message, 256)
+	writer := storage.NewWriter(backend, storage.WriterOptions{
+		BatchSize: cfg.Storage.BatchSize,
+		Interval:  cfg.Storage.FlushInterval,
+		OnWritten: func(msg adapter.Message) {
+			if cache != nil {
+				cache.Enqueue(msg) // Download referenced media in the background
+			}
+			// Tell the TUI the stored ID; drop it rather than stall the writer if the TUI falls behind
+			select {
+			case stored <- msg:
+			default:
+			}
+		},
+		OnError: func(err error, failed int) {
+			p.Send(tui.StorageErrorMsg{Err: err, Failed: failed})
+		},
+	})
+	defer writer.Close() // Flush pending messages before the backend is closed
 
 	bot := adapter.NewNapCatAdapter()
 	if err := bot.Connect(cfg.WebSocketURL, cfg.AccessToken); err != nil {
@@ -100,24 +195,72 @@ func main() {
 		ChatNames: make(map[string]string),
 	}
 
-	msgChan := make(chan adapter.Message)
+	msgChan := make(chan adapter.Message, 256)
+	tuiOpts := tui.Options{
+		Images: tui.ImageOptions{
+			Protocol:    cfg.TUI.Images.Protocol,
+			MaxFileSize: cfg.TUI.Images.MaxFileSize,
+			MaxWidth:    cfg.TUI.Images.MaxWidth,
+			MaxHeight:   cfg.TUI.Images.MaxHeight,
+		},
+		Boss:        tui.BossOptions{Key: cfg.TUI.Boss.Key, Screen: cfg.TUI.Boss.Screen},
+		Stealth:     cfg.TUI.Stealth,
+		Keys:        cfg.TUI.Keys,
+		Timestamps:  cfg.TUI.Timestamps,
+		GroupWithin: cfg.TUI.GroupWithin,
+		Theme: tui.ThemeOptions{
+			Name:      cfg.TUI.Theme.Name,
+			Dir:       cfg.TUI.Theme.Dir,
+			Colors:    cfg.TUI.Theme.Colors,
+			Nicknames: cfg.TUI.Theme.Nicknames,
+		},
+	}
+	if cache != nil {
+		// 已经缓存的图片不用再下载
+		tuiOpts.Images.LocalFile = cache.LocalFile
+	}
+	tuiModel := tui.New(appState, bot, writer, tuiOpts)
+	p = tea.NewProgram(tuiModel, tea.WithAltScreen())
+
+	bot.OnNotice(func(n adapter.Notice) {
+		if err := backend.AddNotice(&n); err != nil {
+			log.Printf("Failed to store notice: %v", err)
+		}
+		p.Send(n)
+	})
 	go bot.Listen(msgChan)
-	tuiModel := tui.New(appState, bot, store, msgChan)
-	p := tea.NewProgram(tuiModel, tea.WithAltScreen())
 
 	// Populate caches in the background
 	go populateCaches(bot, appState, p) // Non-blocking
 
-	// Goroutine to listen for new messages from the bot and forward them to the TUI
+	// Goroutine to listen for new messages from the bot and forward them to the TUI.
+	// It is the only reader of msgChan, so every message is both stored and shown.
 	go func() {
 		for msg := range msgChan {
-			store.AddMessage(&msg) // Persist message to DB
-			p.Send(msg)            // Send message to TUI for display
+			if err := writer.Write(msg); err != nil { // Persist message to DB
+				log.Printf("Failed to queue message: %v", err)
+			}
+			p.Send(msg) // Send message to TUI for display
+		}
+	}()
+
+	// Goroutine to forward the IDs of stored messages to the TUI
+	go func() {
+		for msg := range stored {
+			p.Send(tui.MessageStoredMsg{Message: msg})
 		}
 	}()
 
+	// Prune old messages and compact the database in the background
+	if store != nil {
+		go runMaintenance(store, cache, cfg.Retention)
+		if cfg.Backup.Dir != "" {
+			go runBackups(store, cfg.Backup)
+		}
+	}
+
 	// Start the HTTP control server in a separate goroutine
-	go startControlServer(appState, bot, p)
+	go startControlServer(appState, bot, writer, store, cache, cfg.Backup.Dir, p)
 
 	log.Println("TUI is running. Press Ctrl+C to exit.")
 	if _, err := p.Run(); err != nil {
@@ -156,7 +299,18 @@ func populateCaches(bot adapter.BotAdapter, state *AppState, tuiProgram *tea.Pro
 	log.Printf("Caches populated successfully with %d friends and %d groups.", len(friends), len(groups))
 
 	// Notify the TUI that caches are ready
-	(*tuiProgram).Send(tui.CachesPopulatedMsg{})
+	(*tuiProgram).Send(tui.CachesPopulatedMsg{Chats: append(groups, friends...)})
+}
+
+// fillLatest sets the LatestMsg preview of every chat that has stored messages.
+func fillLatest(chats []adapter.ChatInfo, summaries []storage.ChatSummary) {
+	previews := make(map[string]string, len(summaries))
+	for _, c := range summaries {
+		previews[c.ChatID] = c.Preview()
+	}
+	for i := range chats {
+		chats[i].LatestMsg = previews[chats[i].ID]
+	}
 }
 
 func createConfigWizard() (*config.Config, error) {
@@ -193,7 +347,9 @@ func createConfigWizard() (*config.Config, error) {
 	return cfg, nil
 }
 
-func startControlServer(state *AppState, bot adapter.BotAdapter, p *tea.Program) {
+// startControlServer 启动 HTTP 控制接口；store 仅在使用 SQLite 后端时不为 nil，
+// backupDir 是 /db/backup 写入备份的目录
+func startControlServer(state *AppState, bot adapter.BotAdapter, backend *storage.Writer, store *storage.Store, cache *media.Cache, backupDir string, p *tea.Program) {
 	// 创建一个新的 http.ServeMux (路由)
 	mux := http.NewServeMux()
 
@@ -208,15 +364,12 @@ func startControlServer(state *AppState, bot adapter.BotAdapter, p *tea.Program)
 			name = id
 		}
 
-		state.Lock()
-		state.ActiveChatID = id
-		state.Unlock()
+		state.SetActiveChat(id)
 
 		// Send a message to the TUI to notify it of the change
 		p.Send(tui.ActiveChatChangedMsg{ID: id, Name: name})
 
 		fmt.Fprintf(w, "Active chat set to %s (%s)\n", id, name)
-		log.Printf("Switched active chat to %s (%s)", id, name)
 	})
 
 	mux.HandleFunc("/send_message", func(w http.ResponseWriter, r *http.Request) {
@@ -241,7 +394,10 @@ func startControlServer(state *AppState, bot adapter.BotAdapter, p *tea.Program)
 			return
 		}
 
-		bot.SendMessage(activeID, chatType, string(body))
+		if _, err := bot.SendMessage(activeID, chatType, string(body)); err != nil {
+			http.Error(w, "Failed to send message: "+err.Error(), http.StatusBadGateway)
+			return
+		}
 		fmt.Fprintf(w, "Message sent to %s (%s)\n", activeID, chatType)
 	})
 
@@ -266,14 +422,259 @@ func startControlServer(state *AppState, bot adapter.BotAdapter, p *tea.Program)
 		}
 		state.Unlock()
 
-		w.Header().Set("Content-Type", "application/json")
 		allChats := append(groups, friends...)
+		p.Send(tui.CachesPopulatedMsg{Chats: allChats})
+
+		// 最后一条消息的预览也是聊天记录，不给跨域的网页
+		if r.Header.Get("Origin") == "" {
+			if summaries, err := backend.ChatSummaries(); err == nil {
+				fillLatest(allChats, summaries)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(allChats)
 	})
 
+	mux.HandleFunc("/unread", func(w http.ResponseWriter, r *http.Request) {
+		infos, err := backend.GetUnread()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type unreadEntry struct {
+			storage.UnreadInfo
+			Name string `json:"name"`
+		}
+		entries := make([]unreadEntry, 0, len(infos))
+		for _, info := range infos {
+			entries = append(entries, unreadEntry{UnreadInfo: info, Name: state.GetChatName(info.ChatID)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+
+	mux.HandleFunc("/mark_read", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "missing chat id", http.StatusBadRequest)
+			return
+		}
+		if err := backend.MarkRead(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		p.Send(tui.ChatReadMsg{ID: id})
+		fmt.Fprintf(w, "Chat %s marked as read\n", id)
+	})
+
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		chatID := q.Get("chat")
+		if chatID == "" {
+			http.Error(w, "missing chat id", http.StatusBadRequest)
+			return
+		}
+		format, err := storage.ParseExportFormat(q.Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since, err := parseTimeArg(q.Get("since"), false)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		until, err := parseTimeArg(q.Get("until"), true)
+		if err != nil {
+			http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := storage.ExportOptions{
+			ChatID:   chatID,
+			ChatName: state.GetChatName(chatID),
+			Since:    since,
+			Until:    until,
+			Format:   format,
+		}
+		if format == storage.ExportHTML {
+			var local func(string) (string, bool)
+			if cache != nil {
+				local = cache.LocalFile
+			}
+			opts.InlineImage = func(url string) (string, error) { return media.InlineImage(url, local) }
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", chatID+"."+format.Ext()))
+		// 没能内联的图片数在正文之后才知道，放在 trailer 中
+		w.Header().Set("Trailer", "X-Missing-Images")
+		// 头部已经写出，出错时只能记录日志
+		res, err := storage.Export(backend, w, opts)
+		if err != nil {
+			log.Printf("Export of chat %s failed: %v", chatID, err)
+		}
+		w.Header().Set("X-Missing-Images", strconv.Itoa(res.MissingImages))
+	})
+
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		since, err := parseTimeArg(q.Get("since"), false)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		until, err := parseTimeArg(q.Get("until"), true)
+		if err != nil {
+			http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+
+		messages, err := backend.SearchMessages(storage.SearchQuery{
+			Text:     q.Get("q"),
+			ChatID:   q.Get("chat"),
+			SenderID: q.Get("sender"),
+			Since:    since,
+			Until:    until,
+			Limit:    limit,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		chatID := q.Get("chat")
+		if chatID == "" {
+			http.Error(w, "missing chat id", http.StatusBadRequest)
+			return
+		}
+		since, err := parseTimeArg(q.Get("since"), false)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		until, err := parseTimeArg(q.Get("until"), true)
+		if err != nil {
+			http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		top, _ := strconv.Atoi(q.Get("top"))
+
+		report, err := stats.Compute(backend, stats.Options{ChatID: chatID, Since: since, Until: until, Top: top})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+
+	mux.HandleFunc("/db/writer", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.Stats())
+	})
+
+	mux.HandleFunc("/db/backup", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "backups are only available with the sqlite backend", http.StatusNotImplemented)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if backupDir == "" {
+			http.Error(w, "set backup.dir in config.yml to enable backups", http.StatusBadRequest)
+			return
+		}
+		// 只接受文件名，备份只能写到配置的目录中
+		name := r.URL.Query().Get("name")
+		if name != "" && (name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsAny(name, `/\`)) {
+			http.Error(w, "name must be a file name without a directory", http.StatusBadRequest)
+			return
+		}
+		backend.Flush()
+		var path string
+		var err error
+		if name == "" {
+			path, err = store.BackupTo(backupDir, 0, time.Now())
+		} else {
+			path = filepath.Join(backupDir, name)
+			err = store.Backup(path)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Database backed up to %s\n", path)
+	})
+
+	mux.HandleFunc("/db/stats", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "database stats are only available with the sqlite backend", http.StatusNotImplemented)
+			return
+		}
+		backend.Flush()
+		stats, err := store.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type chatEntry struct {
+			storage.ChatSize
+			Name string `json:"name"`
+		}
+		chats := make([]chatEntry, 0, len(stats.Chats))
+		for _, c := range stats.Chats {
+			chats = append(chats, chatEntry{ChatSize: c, Name: state.GetChatName(c.ChatID)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			storage.DBStats
+			Chats []chatEntry `json:"chats"`
+		}{stats, chats})
+	})
+
+	if cache != nil {
+		mux.Handle("/media/", cache)
+	}
+
 	log.Println("Control server listening on :9090")
 	// 将我们的 mux 包装在 CORS 中间件里
 	if err := http.ListenAndServe("127.0.0.1:9090", corsMiddleware(mux)); err != nil {
 		log.Fatalf("Control server failed: %v", err)
 	}
 }
+
+// parseTimeArg 解析 "2006-01-02"、"2006-01-02 15:04" 或 RFC3339 格式的时间参数。
+// 只给出日期且 endOfDay 为真时，返回次日零点，使结束日期包含当天。
+func parseTimeArg(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
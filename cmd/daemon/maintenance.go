@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/config"
+	"github.com/ziyi233/onebot-tui/media"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// runMaintenance 按配置周期性地清理过期消息和媒体缓存、合并 WAL 并执行 VACUUM
+func runMaintenance(store *storage.Store, cache *media.Cache, cfg config.RetentionConfig) {
+	pruneInterval := cfg.PruneInterval
+	if pruneInterval <= 0 {
+		pruneInterval = time.Hour
+	}
+	global := storage.RetentionPolicy(cfg.RetentionPolicy)
+	perChat := make(map[string]storage.RetentionPolicy, len(cfg.Chats))
+	for id, p := range cfg.Chats {
+		perChat[id] = storage.RetentionPolicy(p)
+	}
+
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+	var vacuumC <-chan time.Time
+	if cfg.VacuumInterval > 0 {
+		vacuumTicker := time.NewTicker(cfg.VacuumInterval)
+		defer vacuumTicker.Stop()
+		vacuumC = vacuumTicker.C
+	}
+
+	prune := func() {
+		result, err := store.Prune(global, perChat, time.Now())
+		if err != nil {
+			log.Printf("Retention: pruning failed: %v", err)
+			return
+		}
+		if result.Deleted > 0 || result.Stripped > 0 {
+			log.Printf("Retention: deleted %d messages, stripped media from %d messages", result.Deleted, result.Stripped)
+		}
+		if cache != nil {
+			if freed, err := cache.Sweep(); err != nil {
+				log.Printf("Retention: media sweep failed: %v", err)
+			} else if freed > 0 {
+				log.Printf("Retention: freed %d bytes of cached media", freed)
+			}
+		}
+		if err := store.Compact(false); err != nil {
+			log.Printf("Retention: wal checkpoint failed: %v", err)
+		}
+	}
+
+	prune()
+	for {
+		select {
+		case <-pruneTicker.C:
+			prune()
+		case <-vacuumC:
+			start := time.Now()
+			if err := store.Compact(true); err != nil {
+				log.Printf("Retention: vacuum failed: %v", err)
+			} else {
+				log.Printf("Retention: vacuum finished in %v", time.Since(start))
+			}
+		}
+	}
+}
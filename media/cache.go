@@ -0,0 +1,449 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// Options 控制媒体缓存的行为
+type Options struct {
+	Dir          string   // 缓存目录
+	MaxFileSize  int64    // 单个文件的大小上限（字节）
+	MaxTotalSize int64    // 缓存目录的总大小上限（字节），0 表示不限制
+	Types        []string // 要缓存的片段类型
+	Chats        []string // 启用缓存的聊天 ID，"*" 表示全部
+}
+
+// inlineLimit 是导出 HTML 时内联为 data URI 的最大文件大小
+const inlineLimit = 4 << 20
+
+// Cache 是以内容哈希寻址的本地媒体缓存：消息中引用的图片、视频和文件被下载到
+// <Dir>/<hash 前两位>/<hash>，并在 attachments 表中与消息关联。
+type Cache struct {
+	opts      Options
+	store     *storage.Store
+	client    *http.Client
+	types     map[string]bool
+	chats     map[string]bool
+	allChats  bool
+	queue     chan job
+	totalSize atomic.Int64
+	wg        sync.WaitGroup
+
+	// mu 在文件放入缓存目录到记录写入 attachments 表之间持有，Sweep 也持有它，
+	// 不会把还没来得及入库的文件当作无人引用而删除
+	mu sync.Mutex
+}
+
+type job struct {
+	messageID int64
+	seg       adapter.Segment
+}
+
+// New 创建媒体缓存并统计已有文件的总大小，调用 Start 后才会开始下载
+func New(opts Options, store *storage.Store) (*Cache, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media dir: %w", err)
+	}
+	c := &Cache{
+		opts:   opts,
+		store:  store,
+		client: &http.Client{Timeout: 2 * time.Minute},
+		types:  make(map[string]bool),
+		chats:  make(map[string]bool),
+		queue:  make(chan job, 256),
+	}
+	for _, t := range opts.Types {
+		c.types[t] = true
+	}
+	for _, id := range opts.Chats {
+		if id == "*" {
+			c.allChats = true
+		}
+		c.chats[id] = true
+	}
+
+	var total int64
+	filepath.WalkDir(opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	c.totalSize.Store(total)
+	return c, nil
+}
+
+// Start 启动 n 个后台下载协程
+func (c *Cache) Start(n int) {
+	for i := 0; i < n; i++ {
+		c.wg.Add(1)
+		go c.worker()
+	}
+}
+
+// Stop 停止接收新任务并等待队列中的下载完成
+func (c *Cache) Stop() {
+	close(c.queue)
+	c.wg.Wait()
+}
+
+// Enqueue 把已入库消息中需要缓存的媒体片段加入下载队列；队列已满时丢弃并记录日志
+func (c *Cache) Enqueue(msg adapter.Message) {
+	if msg.ID == 0 || !(c.allChats || c.chats[msg.ChatID]) {
+		return
+	}
+	for _, seg := range adapter.ParseCQ(msg.Content) {
+		if !c.types[seg.Type] || remoteURL(seg) == "" {
+			continue
+		}
+		select {
+		case c.queue <- job{messageID: msg.ID, seg: seg}:
+		default:
+			log.Printf("Media cache: queue full, dropping %s of message %d", seg.Type, msg.ID)
+		}
+	}
+}
+
+// Path 返回缓存文件的路径
+func (c *Cache) Path(hash string) string {
+	return filepath.Join(c.opts.Dir, hash[:2], hash)
+}
+
+// Lookup 返回远程地址对应的已缓存媒体记录
+func (c *Cache) Lookup(url string) (storage.Attachment, bool) {
+	a, err := c.store.AttachmentByURL(url)
+	if err != nil {
+		return a, false
+	}
+	if _, err := os.Stat(c.Path(a.Hash)); err != nil {
+		return a, false
+	}
+	return a, true
+}
+
+// LocalFile 返回远程地址对应的已缓存文件路径
+func (c *Cache) LocalFile(url string) (string, bool) {
+	a, ok := c.Lookup(url)
+	if !ok {
+		return "", false
+	}
+	return c.Path(a.Hash), true
+}
+
+// inlineClient 下载导出时需要内联但没有缓存的图片
+var inlineClient = &http.Client{Timeout: 30 * time.Second}
+
+// InlineImage 把图片转换为 data URI，供导出自包含的 HTML。local 不为 nil 时先使用
+// 本地缓存的文件，没有缓存时下载原地址；超过 inlineLimit 或不是图片时返回错误
+func InlineImage(url string, local func(url string) (string, bool)) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path, ok := localFile(local, url); ok {
+		data, err = readInline(path)
+	} else {
+		data, err = downloadInline(url)
+	}
+	if err != nil {
+		return "", err
+	}
+	mime := http.DetectContentType(data)
+	if !strings.HasPrefix(mime, "image/") {
+		return "", fmt.Errorf("not an image (%s)", mime)
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func localFile(local func(string) (string, bool), url string) (string, bool) {
+	if local == nil {
+		return "", false
+	}
+	return local(url)
+}
+
+func readInline(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f)
+}
+
+func downloadInline(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported image address %q", url)
+	}
+	resp, err := inlineClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, inlineLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > inlineLimit {
+		return nil, fmt.Errorf("image larger than %d MiB", inlineLimit>>20)
+	}
+	return data, nil
+}
+
+// ServeHTTP 通过 /media/<hash> 提供缓存文件
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/media/")
+	if !validHash(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	a, err := c.store.AttachmentByHash(hash)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(c.Path(hash))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	// 文件内容来自消息的发送者，不能让浏览器把它当作页面执行。类型按文件内容判断，
+	// 较早的记录中保存的是远程服务器声明的类型
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctype := servableType(http.DetectContentType(head[:n]))
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if ctype == octetStream {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, a.FileName, a.Created, f)
+}
+
+// Sweep 删除不再被任何媒体记录引用的缓存文件，返回释放的字节数
+func (c *Cache) Sweep() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	live, err := c.store.CachedHashes()
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	err = filepath.WalkDir(c.opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !validHash(d.Name()) || live[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			freed += info.Size()
+		}
+		return nil
+	})
+	c.totalSize.Add(-freed)
+	return freed, err
+}
+
+func (c *Cache) worker() {
+	defer c.wg.Done()
+	for j := range c.queue {
+		if err := c.fetch(j); err != nil {
+			log.Printf("Media cache: failed to cache %s of message %d: %v", j.seg.Type, j.messageID, err)
+		}
+	}
+}
+
+// fetch 下载一个媒体片段并记录到 attachments 表；同一地址已经缓存过时直接复用
+func (c *Cache) fetch(j job) error {
+	url := remoteURL(j.seg)
+	a := storage.Attachment{
+		MessageID: j.messageID,
+		Type:      j.seg.Type,
+		URL:       url,
+		FileName:  fileName(j.seg),
+	}
+
+	c.mu.Lock()
+	cached, ok := c.Lookup(url)
+	if ok {
+		a.Hash, a.Size, a.MIME = cached.Hash, cached.Size, cached.MIME
+		err := c.store.AddAttachment(&a)
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+	if c.opts.MaxTotalSize > 0 && c.totalSize.Load() >= c.opts.MaxTotalSize {
+		// 缓存已满时只记录元数据
+		return c.store.AddAttachment(&a)
+	}
+
+	tmp, hash, size, mime, err := c.download(url)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	a.Hash, a.Size, a.MIME = hash, size, mime
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.place(tmp, hash, size); err != nil {
+		return err
+	}
+	return c.store.AddAttachment(&a)
+}
+
+var errTooLarge = errors.New("file exceeds size limit")
+
+// download 把 url 下载到缓存目录中的临时文件并返回它的路径，调用方负责删除临时文件
+func (c *Cache) download(url string) (tmpPath, hash string, size int64, mime string, err error) {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return "", "", 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if c.opts.MaxFileSize > 0 && resp.ContentLength > c.opts.MaxFileSize {
+		return "", "", 0, "", errTooLarge
+	}
+
+	tmp, err := os.CreateTemp(c.opts.Dir, "download-*")
+	if err != nil {
+		return "", "", 0, "", err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	defer tmp.Close()
+
+	var body io.Reader = resp.Body
+	if c.opts.MaxFileSize > 0 {
+		body = io.LimitReader(resp.Body, c.opts.MaxFileSize+1)
+	}
+	h := sha256.New()
+	sniff := &sniffWriter{}
+	size, err = io.Copy(io.MultiWriter(tmp, h, sniff), body)
+	if err != nil {
+		return "", "", 0, "", err
+	}
+	if c.opts.MaxFileSize > 0 && size > c.opts.MaxFileSize {
+		return "", "", 0, "", errTooLarge
+	}
+	if err = tmp.Close(); err != nil {
+		return "", "", 0, "", err
+	}
+
+	hash = hex.EncodeToString(h.Sum(nil))
+	// 远程服务器声明的类型不可信，只按内容判断
+	mime = servableType(http.DetectContentType(sniff.buf))
+	return tmp.Name(), hash, size, mime, nil
+}
+
+// place 把下载好的临时文件移到 hash 对应的位置，调用方需持有 c.mu
+func (c *Cache) place(tmp, hash string, size int64) error {
+	dst := c.Path(hash)
+	if _, err := os.Stat(dst); err == nil {
+		// 内容相同的文件已经存在
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	c.totalSize.Add(size)
+	return nil
+}
+
+const octetStream = "application/octet-stream"
+
+// servableType 把 MIME 类型限制为可以安全地从控制端口提供的图片、音频和视频，
+// 其余的一律当作 application/octet-stream 下载
+func servableType(mime string) string {
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mime, prefix) && mime != "image/svg+xml" {
+			return mime
+		}
+	}
+	return octetStream
+}
+
+// sniffWriter 保留写入数据的前 512 字节，用于推断 MIME 类型
+type sniffWriter struct {
+	buf []byte
+}
+
+func (w *sniffWriter) Write(p []byte) (int, error) {
+	if n := 512 - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// remoteURL 返回媒体片段的下载地址，没有可下载地址时返回空字符串
+func remoteURL(seg adapter.Segment) string {
+	for _, key := range []string{"url", "file"} {
+		if v := seg.Data[key]; strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			return v
+		}
+	}
+	return ""
+}
+
+func fileName(seg adapter.Segment) string {
+	if name := seg.Data["name"]; name != "" {
+		return name
+	}
+	if file := seg.Data["file"]; !strings.Contains(file, "://") {
+		return file
+	}
+	return ""
+}
+
+func validHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
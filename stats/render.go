@@ -0,0 +1,139 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// 热力图从少到多使用的字符
+var heatLevels = []rune(" ░▒▓█")
+
+// 每日消息量最多显示的天数
+const maxDays = 14
+
+// Render 把统计结果渲染成纯文本的条形图，width 是可用的终端宽度
+func Render(r *Report, width int) string {
+	if width < 40 {
+		width = 40
+	}
+	var b strings.Builder
+	if r.Messages == 0 {
+		b.WriteString("No messages in this range.\n")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "%d messages from %s to %s\n", r.Messages, r.First.Local().Format("2006-01-02"), r.Last.Local().Format("2006-01-02"))
+	fmt.Fprintf(&b, "Media: %d messages (%.1f%%)", r.MediaMessages, r.MediaShare()*100)
+	if len(r.Media) > 0 {
+		types := make([]string, 0, len(r.Media))
+		for t := range r.Media {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool { return r.Media[types[i]] > r.Media[types[j]] })
+		parts := make([]string, 0, len(types))
+		for _, t := range types {
+			parts = append(parts, fmt.Sprintf("%s %d", t, r.Media[t]))
+		}
+		b.WriteString("  [" + strings.Join(parts, ", ") + "]")
+	}
+	b.WriteString("\n")
+
+	if len(r.Senders) > 0 {
+		b.WriteString("\nTop senders\n")
+		labels := make([]string, len(r.Senders))
+		values := make([]int, len(r.Senders))
+		for i, s := range r.Senders {
+			labels[i] = s.Name
+			if labels[i] == "" {
+				labels[i] = s.SenderID
+			}
+			values[i] = s.Messages
+		}
+		writeBars(&b, labels, values, width)
+	}
+
+	b.WriteString("\nActivity by hour\n")
+	writeHeatmap(&b, r.Hours)
+
+	if len(r.Days) > 0 {
+		days := r.Days
+		if len(days) > maxDays {
+			days = days[len(days)-maxDays:]
+		}
+		fmt.Fprintf(&b, "\nDaily volume (last %d days)\n", len(days))
+		labels := make([]string, len(days))
+		values := make([]int, len(days))
+		for i, d := range days {
+			labels[i] = d.Date[5:] // MM-DD
+			values[i] = d.Messages
+		}
+		writeBars(&b, labels, values, width)
+	}
+
+	if len(r.Words) > 0 {
+		b.WriteString("\nTop words\n")
+		labels := make([]string, len(r.Words))
+		values := make([]int, len(r.Words))
+		for i, w := range r.Words {
+			labels[i] = w.Word
+			values[i] = w.Count
+		}
+		writeBars(&b, labels, values, width)
+	}
+	return b.String()
+}
+
+// writeBars 输出一组水平条形图，标签按显示宽度对齐以兼容中文昵称
+func writeBars(b *strings.Builder, labels []string, values []int, width int) {
+	labelWidth := 0
+	for _, l := range labels {
+		labelWidth = max(labelWidth, runewidth.StringWidth(l))
+	}
+	labelWidth = min(labelWidth, 16)
+	maxValue, valueWidth := 0, 0
+	for _, v := range values {
+		maxValue = max(maxValue, v)
+		valueWidth = max(valueWidth, len(fmt.Sprint(v)))
+	}
+	barWidth := width - labelWidth - valueWidth - 4
+	for i, l := range labels {
+		n := 0
+		if maxValue > 0 {
+			n = values[i] * barWidth / maxValue
+		}
+		if n == 0 && values[i] > 0 {
+			n = 1
+		}
+		l = runewidth.FillRight(runewidth.Truncate(l, labelWidth, "…"), labelWidth)
+		fmt.Fprintf(b, "%s │%s %*d\n", l, strings.Repeat("█", n), valueWidth, values[i])
+	}
+}
+
+// writeHeatmap 把 24 小时的消息量画成一行热力图，每个小时占两列
+func writeHeatmap(b *strings.Builder, hours [24]int) {
+	peak := 0
+	for _, n := range hours {
+		peak = max(peak, n)
+	}
+	for h := 0; h < 24; h += 3 {
+		fmt.Fprintf(b, "%-6d", h)
+	}
+	b.WriteString("\n")
+	for _, n := range hours {
+		level := 0
+		if n > 0 {
+			level = 1 + n*(len(heatLevels)-2)/peak
+		}
+		b.WriteString(strings.Repeat(string(heatLevels[level]), 2))
+	}
+	peakHour := 0
+	for h, n := range hours {
+		if n > hours[peakHour] {
+			peakHour = h
+		}
+	}
+	fmt.Fprintf(b, "  peak %02d:00 (%d)\n", peakHour, peak)
+}
@@ -0,0 +1,173 @@
+// Package stats 统计聊天记录：谁发言最多、什么时候最活跃、大家都在聊什么。
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// Options 控制统计的范围
+type Options struct {
+	ChatID string
+	Since  time.Time // 零值表示不限制
+	Until  time.Time
+	Top    int // 发送者和热词各保留多少条，默认 10
+}
+
+// Report 是一个聊天的统计结果
+type Report struct {
+	ChatID   string    `json:"chat_id"`
+	Messages int       `json:"messages"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+
+	Senders []SenderCount `json:"senders"` // 按消息数从多到少排列
+	Hours   [24]int       `json:"hours"`   // 按本地时间的小时统计的消息数
+	Days    []DayCount    `json:"days"`    // 按日期排列，没有消息的日期也会列出
+	Words   []WordCount   `json:"words"`
+
+	MediaMessages int            `json:"media_messages"` // 含有图片、语音等媒体的消息数
+	Media         map[string]int `json:"media"`          // CQ 码类型 -> 出现次数
+}
+
+// SenderCount 是一个发送者的消息数
+type SenderCount struct {
+	SenderID string `json:"sender_id"`
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+}
+
+// DayCount 是一天的消息数，Date 的格式为 2006-01-02
+type DayCount struct {
+	Date     string `json:"date"`
+	Messages int    `json:"messages"`
+}
+
+// WordCount 是一个词出现的次数
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// MediaShare 返回含有媒体的消息所占的比例
+func (r *Report) MediaShare() float64 {
+	if r.Messages == 0 {
+		return 0
+	}
+	return float64(r.MediaMessages) / float64(r.Messages)
+}
+
+// 计入 MediaMessages 的 CQ 码类型，at、face、reply 等只是文字消息的一部分
+var mediaTypes = map[string]bool{
+	"image": true, "record": true, "video": true, "file": true,
+	"forward": true, "json": true, "xml": true, "music": true,
+	"share": true, "mface": true, "location": true,
+}
+
+// Compute 遍历聊天记录并生成统计结果
+func Compute(b storage.Backend, opts Options) (*Report, error) {
+	top := opts.Top
+	if top <= 0 {
+		top = 10
+	}
+
+	r := &Report{ChatID: opts.ChatID, Media: make(map[string]int)}
+	senders := make(map[string]*SenderCount)
+	days := make(map[string]int)
+	words := make(map[string]int)
+
+	err := b.ForEachMessage(opts.ChatID, opts.Since, opts.Until, func(msg adapter.Message) error {
+		if r.Messages == 0 {
+			r.First = msg.Time
+		}
+		r.Messages++
+		r.Last = msg.Time
+
+		s := senders[msg.SenderID]
+		if s == nil {
+			s = &SenderCount{SenderID: msg.SenderID}
+			senders[msg.SenderID] = s
+		}
+		s.Messages++
+		if msg.SenderName != "" {
+			s.Name = msg.SenderName // 使用最近的昵称
+		}
+
+		local := msg.Time.Local()
+		r.Hours[local.Hour()]++
+		days[local.Format("2006-01-02")]++
+
+		hasMedia := false
+		for _, seg := range adapter.ParseCQ(msg.Content) {
+			if seg.Type == "text" {
+				for _, w := range Tokenize(seg.Text()) {
+					words[w]++
+				}
+				continue
+			}
+			r.Media[seg.Type]++
+			if mediaTypes[seg.Type] {
+				hasMedia = true
+			}
+		}
+		if hasMedia {
+			r.MediaMessages++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range senders {
+		r.Senders = append(r.Senders, *s)
+	}
+	sort.Slice(r.Senders, func(i, j int) bool {
+		if r.Senders[i].Messages != r.Senders[j].Messages {
+			return r.Senders[i].Messages > r.Senders[j].Messages
+		}
+		return r.Senders[i].SenderID < r.Senders[j].SenderID
+	})
+	if len(r.Senders) > top {
+		r.Senders = r.Senders[:top]
+	}
+
+	r.Days = fillDays(days, r.First, r.Last)
+
+	for w, n := range words {
+		if n > 1 {
+			r.Words = append(r.Words, WordCount{Word: w, Count: n})
+		}
+	}
+	sort.Slice(r.Words, func(i, j int) bool {
+		if r.Words[i].Count != r.Words[j].Count {
+			return r.Words[i].Count > r.Words[j].Count
+		}
+		return r.Words[i].Word < r.Words[j].Word
+	})
+	if len(r.Words) > top {
+		r.Words = r.Words[:top]
+	}
+	return r, nil
+}
+
+// fillDays 把按日期统计的消息数展开成连续的日期序列
+func fillDays(days map[string]int, first, last time.Time) []DayCount {
+	if len(days) == 0 {
+		return nil
+	}
+	var result []DayCount
+	start := first.Local()
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+	end := last.Local().Format("2006-01-02")
+	for d := start; ; d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		result = append(result, DayCount{Date: date, Messages: days[date]})
+		if date >= end {
+			return result
+		}
+	}
+}
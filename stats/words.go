@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 中文没有空格分词，这里不依赖词典，而是把连续的汉字切成相邻的二字组（bigram）。
+// 常见的二字词都能被统计出来，代价是会混入一些跨词的组合，不过它们很少能排进前列。
+
+// 含有这些字的二字组多是语气词组合，不计入热词
+var stopRunes = map[rune]bool{
+	'的': true, '了': true, '吗': true, '吧': true, '啊': true, '呢': true,
+	'哦': true, '嗯': true, '哈': true, '么': true, '呀': true, '啦': true,
+}
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "is": true, "are": true, "to": true, "of": true,
+	"in": true, "it": true, "you": true, "that": true, "this": true, "for": true,
+	"on": true, "be": true, "was": true, "with": true, "not": true, "but": true,
+	"http": true, "https": true, "www": true, "com": true, "cn": true,
+}
+
+// Tokenize 把一段文字切成用于统计热词的词：连续的汉字切成二字组，
+// 字母和数字组成的词转成小写，纯数字、单个字母和常见虚词会被忽略。
+func Tokenize(text string) []string {
+	var tokens []string
+	var han []rune
+	var latin strings.Builder
+
+	flushHan := func() {
+		if len(han) == 1 {
+			han = han[:0]
+			return
+		}
+		for i := 0; i+1 < len(han); i++ {
+			if stopRunes[han[i]] || stopRunes[han[i+1]] {
+				continue
+			}
+			tokens = append(tokens, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	flushLatin := func() {
+		w := strings.ToLower(latin.String())
+		latin.Reset()
+		if len([]rune(w)) < 2 || stopWords[w] || isDigits(w) {
+			return
+		}
+		tokens = append(tokens, w)
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushLatin()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			latin.WriteRune(r)
+		default:
+			flushHan()
+			flushLatin()
+		}
+	}
+	flushHan()
+	flushLatin()
+	return tokens
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package stats
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		// 连续的汉字切成相邻的二字组
+		{"今天天气不错", []string{"今天", "天天", "天气", "气不", "不错"}},
+		{"好的吧", nil},
+		{"吃饭了吗", []string{"吃饭"}},
+		{"我 你", nil},
+		{"Hello, WORLD the 123 a go2", []string{"hello", "world", "go2"}},
+		{"看https://example.com链接", []string{"example", "链接"}},
+		{"Go语言", []string{"go", "语言"}},
+		{"１２３ ＡＢ", []string{"ａｂ"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
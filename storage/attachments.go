@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Attachment 是消息中引用的一个媒体文件，Hash 非空表示已经下载到本地媒体缓存
+type Attachment struct {
+	ID        int64     `json:"id"`
+	MessageID int64     `json:"message_id"`
+	Type      string    `json:"type"` // "image"、"video"、"record" 或 "file"
+	URL       string    `json:"url"`
+	FileName  string    `json:"file_name"`
+	Hash      string    `json:"hash"` // 内容的 SHA-256，也是缓存文件名
+	Size      int64     `json:"size"`
+	MIME      string    `json:"mime"`
+	Created   time.Time `json:"created"`
+}
+
+// ErrNotFound 表示查询的记录不存在
+var ErrNotFound = errors.New("not found")
+
+const attachmentColumns = `id, message_id, type, COALESCE(url, ''), COALESCE(file_name, ''), COALESCE(hash, ''), size, COALESCE(mime, ''), created`
+
+// scanAttachment 读出一条媒体记录，并解密加密时一起加密的地址和文件名
+func (s *Store) scanAttachment(row interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.MessageID, &a.Type, &a.URL, &a.FileName, &a.Hash, &a.Size, &a.MIME, &a.Created)
+	a.Created = a.Created.Local()
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrNotFound
+	}
+	if err != nil {
+		return a, err
+	}
+	if a.URL, err = s.openText(a.URL); err != nil {
+		return a, err
+	}
+	a.FileName, err = s.openText(a.FileName)
+	return a, err
+}
+
+// AddAttachment 记录一个媒体文件并回填它的 ID
+func (s *Store) AddAttachment(a *Attachment) error {
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	if a.Created.IsZero() {
+		a.Created = time.Now()
+	}
+	res, err := s.db.Exec(`INSERT INTO attachments(message_id, type, url, url_key, file_name, hash, size, mime, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.MessageID, a.Type, s.sealNonEmpty(a.URL), urlKeyWith(s.cipher, a.URL), s.sealNonEmpty(a.FileName), a.Hash, a.Size, a.MIME, dbTime(a.Created))
+	if err != nil {
+		return err
+	}
+	a.ID, err = res.LastInsertId()
+	return err
+}
+
+// sealNonEmpty 加密非空的地址或文件名；空值保持为空，查询时不用解密
+func (s *Store) sealNonEmpty(value string) string {
+	if value == "" {
+		return ""
+	}
+	return s.sealText(value)
+}
+
+// urlKeyWith 计算按地址查找媒体记录用的 url_key 列。加密时地址本身是随机化的密文，
+// 只能用 HMAC 查找；未加密时就是地址本身。
+func urlKeyWith(c *rowCipher, url string) string {
+	if c == nil || url == "" {
+		return url
+	}
+	return "hmac:" + hex.EncodeToString(c.mac("url\x00" + url)[:16])
+}
+
+// sealPlainURLs 加密启用加密之前写入、仍是明文的媒体地址，解锁时调用
+func (s *Store) sealPlainURLs() error {
+	rows, err := s.db.Query(`SELECT id, url FROM attachments WHERE url != '' AND url NOT LIKE ?`, encryptedPrefix+"%")
+	if err != nil {
+		return err
+	}
+	urls := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return err
+		}
+		urls[id] = url
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(urls) == 0 {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, url := range urls {
+		if _, err := tx.Exec(`UPDATE attachments SET url = ?, url_key = ? WHERE id = ?`, s.cipher.seal(url), urlKeyWith(s.cipher, url), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetAttachments 返回一条消息的所有媒体文件
+func (s *Store) GetAttachments(messageID int64) ([]Attachment, error) {
+	rows, err := s.db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id = ? ORDER BY id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Attachment
+	for rows.Next() {
+		a, err := s.scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// AttachmentByHash 返回任意一个指向该缓存文件的媒体记录，用于获取 MIME 类型和文件名
+func (s *Store) AttachmentByHash(hash string) (Attachment, error) {
+	return s.scanAttachment(s.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE hash = ? LIMIT 1`, hash))
+}
+
+// AttachmentByURL 返回该远程地址已经缓存过的媒体记录
+func (s *Store) AttachmentByURL(url string) (Attachment, error) {
+	return s.scanAttachment(s.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE url_key = ? AND hash != '' ORDER BY id DESC LIMIT 1`, urlKeyWith(s.cipher, url)))
+}
+
+// CachedHashes 返回仍被引用的缓存文件哈希，媒体缓存据此清理无主文件
+func (s *Store) CachedHashes() (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT hash FROM attachments WHERE hash != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes[h] = true
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// Backend 是消息存储后端需要实现的方法。TUI 和守护进程只依赖这个接口；
+// 导入、保留策略、媒体缓存和加密等维护功能目前只有 SQLite 的 Store 支持。
+type Backend interface {
+	// AddMessage 保存一条消息并回填它的 ID
+	AddMessage(msg *adapter.Message) error
+	// GetMessages 按时间顺序返回聊天最近的 limit 条消息
+	GetMessages(chatID string, limit int) ([]adapter.Message, error)
+	// ForEachMessage 按时间顺序遍历聊天在 [since, until) 内的消息，零值表示不限制
+	ForEachMessage(chatID string, since, until time.Time, fn func(adapter.Message) error) error
+	// SearchMessages 按时间倒序返回匹配查询条件的消息
+	SearchMessages(q SearchQuery) ([]adapter.Message, error)
+	// FindMessage 按 OneBot 的 message_id 查找聊天中的消息，没有保存时返回 nil
+	FindMessage(chatID, messageID string) (*adapter.Message, error)
+
+	// ChatSummaries 返回每个有消息的聊天的最近活动，按最后一条消息的时间倒序排列
+	ChatSummaries() ([]ChatSummary, error)
+	// SenderNames 返回聊天中每个发送者最近使用的昵称
+	SenderNames(chatID string) (map[string]string, error)
+	// MarkRead 把聊天的已读标记移动到它当前最新的一条消息
+	MarkRead(chatID string) error
+	// LastReadID 返回聊天的已读标记，从未标记过时返回 0
+	LastReadID(chatID string) (int64, error)
+	// GetUnread 返回所有存在未读消息的聊天，按未读数从多到少排列
+	GetUnread() ([]UnreadInfo, error)
+
+	// SaveDraft 保存聊天输入框中未发送的内容，空字符串表示删除草稿
+	SaveDraft(chatID, text string) error
+	// GetDraft 返回聊天的草稿，没有时返回空字符串
+	GetDraft(chatID string) (string, error)
+	// AddInputHistory 记录一条发送过的输入，每个聊天只保留最近的若干条
+	AddInputHistory(chatID, text string) error
+	// GetInputHistory 按时间顺序返回聊天最近的 limit 条输入
+	GetInputHistory(chatID string, limit int) ([]string, error)
+
+	// AddNotice 保存一条通知事件并回填它的 ID
+	AddNotice(n *adapter.Notice) error
+	// GetNotices 按时间顺序返回聊天最近的 limit 条通知
+	GetNotices(chatID string, limit int) ([]adapter.Notice, error)
+
+	Close()
+}
+
+// SearchQuery 描述一次消息搜索，空字段表示不限制
+type SearchQuery struct {
+	Text     string // 消息内容包含的文字，不区分大小写
+	ChatID   string
+	SenderID string
+	Since    time.Time
+	Until    time.Time
+	Limit    int // 不大于 0 时使用 defaultSearchLimit
+}
+
+const defaultSearchLimit = 100
+
+func (q SearchQuery) limit() int {
+	if q.Limit <= 0 {
+		return defaultSearchLimit
+	}
+	return q.Limit
+}
+
+// ChatSummary 是一个聊天的最近活动，用于聊天列表的排序和预览
+type ChatSummary struct {
+	ChatID   string          `json:"chat_id"`
+	ChatType string          `json:"chat_type"`
+	Messages int64           `json:"messages"`
+	Latest   adapter.Message `json:"latest"`
+}
+
+var _ Backend = (*Store)(nil)
@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// testBackends 是要通过同一组契约测试的后端，PostgreSQL 只在设置了 postgresDSNEnv 时运行
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) Backend
+}{
+	{"sqlite", func(t *testing.T) Backend {
+		s, err := NewStore(filepath.Join(t.TempDir(), "onebot.db"))
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	}},
+	{"memory", func(t *testing.T) Backend {
+		return NewMemoryStore()
+	}},
+	{"writer", func(t *testing.T) Backend {
+		w := NewWriter(NewMemoryStore(), WriterOptions{})
+		t.Cleanup(w.Close)
+		return w
+	}},
+	{"postgres", func(t *testing.T) Backend {
+		return openTestPostgres(t)
+	}},
+}
+
+func TestBackendContract(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, b Backend)
+	}{
+		{"Messages", testMessages},
+		{"MixedOffsets", testMixedOffsets},
+		{"FindMessage", testFindMessage},
+		{"SearchMessages", testSearchMessages},
+		{"ChatSummaries", testChatSummaries},
+		{"SenderNames", testSenderNames},
+		{"Unread", testUnread},
+		{"Drafts", testDrafts},
+		{"InputHistory", testInputHistory},
+		{"Notices", testNotices},
+	}
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.test(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+// testTime 是测试消息的起始时间，取整到秒，各后端都能原样保存
+var testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// addTestMessage 保存一条 offset 秒时发送的消息
+func addTestMessage(t *testing.T, b Backend, chatID, senderID, content string, offset int) adapter.Message {
+	t.Helper()
+	msg := adapter.Message{
+		ChatID:     chatID,
+		ChatType:   "group",
+		SenderID:   senderID,
+		SenderName: "user" + senderID,
+		Content:    content,
+		Time:       testTime.Add(time.Duration(offset) * time.Second),
+		MessageID:  fmt.Sprintf("m%d", offset),
+	}
+	if err := b.AddMessage(&msg); err != nil {
+		t.Fatalf("AddMessage: %v", err)
+	}
+	if msg.ID == 0 {
+		t.Fatal("AddMessage did not set the ID")
+	}
+	return msg
+}
+
+func contents(messages []adapter.Message) []string {
+	var list []string
+	for _, msg := range messages {
+		list = append(list, msg.Content)
+	}
+	return list
+}
+
+func testMessages(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	// 故意乱序写入，读取时应按时间排列
+	addTestMessage(t, b, chatID, "1", "first", 0)
+	addTestMessage(t, b, chatID, "1", "third", 2)
+	addTestMessage(t, b, chatID, "2", "second", 1)
+	addTestMessage(t, b, testChatID(t, "other"), "1", "elsewhere", 3)
+
+	got, err := b.GetMessages(chatID, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if want := []string{"first", "second", "third"}; !slices.Equal(contents(got), want) {
+		t.Errorf("GetMessages = %q, want %q", contents(got), want)
+	}
+	if !got[0].Time.Equal(testTime) || got[0].SenderName != "user1" || got[0].MessageID != "m0" {
+		t.Errorf("GetMessages()[0] = %+v", got[0])
+	}
+
+	got, err = b.GetMessages(chatID, 2)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if want := []string{"second", "third"}; !slices.Equal(contents(got), want) {
+		t.Errorf("GetMessages with limit 2 = %q, want %q", contents(got), want)
+	}
+
+	var ranged []adapter.Message
+	err = b.ForEachMessage(chatID, testTime.Add(time.Second), testTime.Add(2*time.Second), func(msg adapter.Message) error {
+		ranged = append(ranged, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachMessage: %v", err)
+	}
+	if want := []string{"second"}; !slices.Equal(contents(ranged), want) {
+		t.Errorf("ForEachMessage in [1s, 2s) = %q, want %q", contents(ranged), want)
+	}
+}
+
+// testMixedOffsets 用不同时区写入消息，排序和按时间过滤都应按实际时刻进行
+func testMixedOffsets(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	east, west := time.FixedZone("CST", 8*3600), time.FixedZone("EST", -5*3600)
+	for _, m := range []struct {
+		content string
+		time    time.Time
+	}{
+		// 文本上 "2024-05-01 19:00" 排在 "2024-05-01 12:30" 后面，实际时刻却更早
+		{"second", testTime.Add(time.Hour).In(east)},
+		{"first", testTime.In(west)},
+		{"third", testTime.Add(2 * time.Hour)},
+	} {
+		msg := adapter.Message{ChatID: chatID, ChatType: "group", SenderID: "1", Content: m.content, Time: m.time}
+		if err := b.AddMessage(&msg); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+	}
+
+	got, err := b.GetMessages(chatID, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if want := []string{"first", "second", "third"}; !slices.Equal(contents(got), want) {
+		t.Errorf("GetMessages = %q, want %q", contents(got), want)
+	}
+
+	tests := []struct {
+		since, until time.Time
+		want         []string
+	}{
+		{testTime.Add(30 * time.Minute).In(east), time.Time{}, []string{"second", "third"}},
+		{time.Time{}, testTime.Add(90 * time.Minute).In(west), []string{"first", "second"}},
+		{testTime.Add(time.Hour).In(west), testTime.Add(2 * time.Hour).In(east), []string{"second"}},
+	}
+	for _, tt := range tests {
+		var ranged []adapter.Message
+		err := b.ForEachMessage(chatID, tt.since, tt.until, func(msg adapter.Message) error {
+			ranged = append(ranged, msg)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ForEachMessage: %v", err)
+		}
+		if !slices.Equal(contents(ranged), tt.want) {
+			t.Errorf("ForEachMessage(%v, %v) = %q, want %q", tt.since, tt.until, contents(ranged), tt.want)
+		}
+		found, err := b.SearchMessages(SearchQuery{ChatID: chatID, Since: tt.since, Until: tt.until})
+		if err != nil {
+			t.Fatalf("SearchMessages: %v", err)
+		}
+		slices.Reverse(found)
+		if !slices.Equal(contents(found), tt.want) {
+			t.Errorf("SearchMessages(%v, %v) = %q, want %q", tt.since, tt.until, contents(found), tt.want)
+		}
+	}
+}
+
+func testFindMessage(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	saved := addTestMessage(t, b, chatID, "1", "hello", 5)
+
+	found, err := b.FindMessage(chatID, "m5")
+	if err != nil {
+		t.Fatalf("FindMessage: %v", err)
+	}
+	if found == nil || found.ID != saved.ID || found.Content != "hello" {
+		t.Errorf("FindMessage(m5) = %+v, want message %d", found, saved.ID)
+	}
+	for _, id := range []string{"", "m6"} {
+		if found, err := b.FindMessage(chatID, id); err != nil || found != nil {
+			t.Errorf("FindMessage(%q) = %+v, %v; want nil", id, found, err)
+		}
+	}
+}
+
+func testSearchMessages(t *testing.T, b Backend) {
+	chatID, other := testChatID(t, "chat"), testChatID(t, "other")
+	addTestMessage(t, b, chatID, "1", "Hello world", 0)
+	addTestMessage(t, b, chatID, "2", "hello again", 1)
+	addTestMessage(t, b, chatID, "1", "goodbye", 2)
+	addTestMessage(t, b, other, "1", "hello there", 3)
+
+	tests := []struct {
+		q    SearchQuery
+		want []string
+	}{
+		{SearchQuery{Text: "HELLO", ChatID: chatID}, []string{"hello again", "Hello world"}},
+		{SearchQuery{Text: "hello", ChatID: chatID, SenderID: "1"}, []string{"Hello world"}},
+		{SearchQuery{Text: "hello", ChatID: chatID, Limit: 1}, []string{"hello again"}},
+		{SearchQuery{ChatID: chatID, Since: testTime.Add(time.Second)}, []string{"goodbye", "hello again"}},
+		{SearchQuery{ChatID: chatID, Until: testTime.Add(time.Second)}, []string{"Hello world"}},
+		{SearchQuery{Text: "%", ChatID: chatID}, nil},
+	}
+	for _, tt := range tests {
+		got, err := b.SearchMessages(tt.q)
+		if err != nil {
+			t.Fatalf("SearchMessages(%+v): %v", tt.q, err)
+		}
+		if !slices.Equal(contents(got), tt.want) {
+			t.Errorf("SearchMessages(%+v) = %q, want %q", tt.q, contents(got), tt.want)
+		}
+	}
+}
+
+func testChatSummaries(t *testing.T, b Backend) {
+	older, newer := testChatID(t, "older"), testChatID(t, "newer")
+	addTestMessage(t, b, older, "1", "a", 0)
+	addTestMessage(t, b, older, "2", "b", 3)
+	addTestMessage(t, b, newer, "1", "c", 5)
+
+	all, err := b.ChatSummaries()
+	if err != nil {
+		t.Fatalf("ChatSummaries: %v", err)
+	}
+	// 共享的 PostgreSQL 中可能还有别的聊天
+	var got []ChatSummary
+	for _, c := range all {
+		if c.ChatID == older || c.ChatID == newer {
+			got = append(got, c)
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("ChatSummaries returned %d of the test chats, want 2", len(got))
+	}
+	if got[0].ChatID != newer || got[1].ChatID != older {
+		t.Errorf("ChatSummaries order = %s, %s; want the newer chat first", got[0].ChatID, got[1].ChatID)
+	}
+	c := got[1]
+	if c.ChatType != "group" || c.Messages != 2 {
+		t.Errorf("summary = %s, %d messages; want group, 2", c.ChatType, c.Messages)
+	}
+	latest := c.Latest
+	if latest.ChatID != older || latest.Content != "b" || latest.SenderID != "2" || latest.SenderName != "user2" ||
+		latest.MessageID != "m3" || !latest.Time.Equal(testTime.Add(3*time.Second)) {
+		t.Errorf("summary latest = %+v", latest)
+	}
+}
+
+func testSenderNames(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	msg := addTestMessage(t, b, chatID, "1", "hi", 0)
+	msg.ID, msg.SenderName, msg.Time = 0, "renamed", msg.Time.Add(time.Second)
+	if err := b.AddMessage(&msg); err != nil {
+		t.Fatalf("AddMessage: %v", err)
+	}
+	addTestMessage(t, b, chatID, "2", "hey", 2)
+
+	names, err := b.SenderNames(chatID)
+	if err != nil {
+		t.Fatalf("SenderNames: %v", err)
+	}
+	if want := map[string]string{"1": "renamed", "2": "user2"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("SenderNames = %v, want %v", names, want)
+	}
+}
+
+func testUnread(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	unread := func() (UnreadInfo, bool) {
+		t.Helper()
+		infos, err := b.GetUnread()
+		if err != nil {
+			t.Fatalf("GetUnread: %v", err)
+		}
+		for _, info := range infos {
+			if info.ChatID == chatID {
+				return info, true
+			}
+		}
+		return UnreadInfo{}, false
+	}
+
+	if id, err := b.LastReadID(chatID); err != nil || id != 0 {
+		t.Errorf("LastReadID before MarkRead = %d, %v; want 0", id, err)
+	}
+	first := addTestMessage(t, b, chatID, "1", "hi", 0)
+	mention := adapter.Message{ChatID: chatID, ChatType: "group", SenderID: "2", Content: "@me", Time: testTime.Add(time.Second), Mentioned: true}
+	if err := b.AddMessage(&mention); err != nil {
+		t.Fatalf("AddMessage: %v", err)
+	}
+	want := UnreadInfo{ChatID: chatID, ChatType: "group", Unread: 2, Mentions: 1, FirstUnread: first.ID}
+	if info, _ := unread(); info != want {
+		t.Errorf("GetUnread = %+v, want %+v", info, want)
+	}
+
+	if err := b.MarkRead(chatID); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if id, err := b.LastReadID(chatID); err != nil || id != mention.ID {
+		t.Errorf("LastReadID = %d, %v; want %d", id, err, mention.ID)
+	}
+	if info, ok := unread(); ok {
+		t.Errorf("GetUnread after MarkRead = %+v, want no entry", info)
+	}
+
+	next := addTestMessage(t, b, chatID, "1", "more", 2)
+	want = UnreadInfo{ChatID: chatID, ChatType: "group", Unread: 1, FirstUnread: next.ID}
+	if info, _ := unread(); info != want {
+		t.Errorf("GetUnread after a new message = %+v, want %+v", info, want)
+	}
+}
+
+func testDrafts(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	for _, text := range []string{"draft", "changed", ""} {
+		if err := b.SaveDraft(chatID, text); err != nil {
+			t.Fatalf("SaveDraft(%q): %v", text, err)
+		}
+		if got, err := b.GetDraft(chatID); err != nil || got != text {
+			t.Errorf("GetDraft after SaveDraft(%q) = %q, %v", text, got, err)
+		}
+	}
+}
+
+func testInputHistory(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	if got, err := b.GetInputHistory(chatID, 10); err != nil || len(got) != 0 {
+		t.Errorf("GetInputHistory of a new chat = %q, %v", got, err)
+	}
+	for i := range inputHistoryLimit + 5 {
+		if err := b.AddInputHistory(chatID, fmt.Sprint(i)); err != nil {
+			t.Fatalf("AddInputHistory: %v", err)
+		}
+	}
+	got, err := b.GetInputHistory(chatID, 3)
+	if err != nil {
+		t.Fatalf("GetInputHistory: %v", err)
+	}
+	last := inputHistoryLimit + 4
+	if want := []string{fmt.Sprint(last - 2), fmt.Sprint(last - 1), fmt.Sprint(last)}; !slices.Equal(got, want) {
+		t.Errorf("GetInputHistory(3) = %q, want %q", got, want)
+	}
+	got, err = b.GetInputHistory(chatID, 2*inputHistoryLimit)
+	if err != nil {
+		t.Fatalf("GetInputHistory: %v", err)
+	}
+	if len(got) != inputHistoryLimit || got[0] != "5" {
+		t.Errorf("GetInputHistory kept %d entries starting at %q, want %d starting at %q", len(got), got[0], inputHistoryLimit, "5")
+	}
+}
+
+func testNotices(t *testing.T, b Backend) {
+	chatID := testChatID(t, "chat")
+	var ids []int64
+	for i, text := range []string{"joined", "poked", "left"} {
+		n := adapter.Notice{ChatID: chatID, ChatType: "group", Type: "notify", SubType: "poke", UserID: "1", OperatorID: "2",
+			Text: text, Time: testTime.Add(time.Duration(i) * time.Second)}
+		if err := b.AddNotice(&n); err != nil {
+			t.Fatalf("AddNotice: %v", err)
+		}
+		if n.ID == 0 {
+			t.Fatal("AddNotice did not set the ID")
+		}
+		ids = append(ids, n.ID)
+	}
+
+	got, err := b.GetNotices(chatID, 2)
+	if err != nil {
+		t.Fatalf("GetNotices: %v", err)
+	}
+	if len(got) != 2 || got[0].Text != "poked" || got[1].Text != "left" {
+		t.Fatalf("GetNotices(2) = %+v, want the last two in order", got)
+	}
+	n := got[0]
+	if n.ID != ids[1] || n.ChatType != "group" || n.Type != "notify" || n.SubType != "poke" ||
+		n.UserID != "1" || n.OperatorID != "2" || !n.Time.Equal(testTime.Add(time.Second)) {
+		t.Errorf("GetNotices()[0] = %+v", n)
+	}
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemaVersion 是当前代码创建的数据库结构版本，保存在 PRAGMA user_version 中。
+// 修改表结构时需要递增它；恢复备份时会拒绝比它更新的文件。
+const schemaVersion = 4
+
+// 自动备份文件名的前缀和时间格式，按文件名排序即按时间排序
+const (
+	backupPrefix     = "onebot-"
+	backupTimeFormat = "20060102-150405"
+)
+
+// BackupInfo 描述一个备份文件的内容
+type BackupInfo struct {
+	SchemaVersion int   `json:"schema_version"` // 0 表示引入版本号之前的数据库
+	Messages      int64 `json:"messages"`
+	Encrypted     bool  `json:"encrypted"`
+}
+
+// Backup 用 VACUUM INTO 生成数据库的一致性副本，可以在守护进程写入时安全执行。
+// 加密的数据库备份后仍然是加密的。path 已存在时返回错误。
+func (s *Store) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	// 先写到临时文件，避免中断时留下不完整的备份
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := s.db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// BackupTo 在目录 dir 中创建一个带时间戳的备份，并只保留最新的 keep 个（keep 不大于 0 时不删除）
+func (s *Store) BackupTo(dir string, keep int, now time.Time) (string, error) {
+	path := filepath.Join(dir, backupPrefix+now.Format(backupTimeFormat)+".db")
+	if err := s.Backup(path); err != nil {
+		return "", err
+	}
+	if keep <= 0 {
+		return path, nil
+	}
+	return path, rotateBackups(dir, keep)
+}
+
+// rotateBackups 删除目录中多余的旧备份
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, ".db") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// CheckBackup 以只读方式打开备份文件，检查它的完整性和结构版本
+func CheckBackup(path string) (BackupInfo, error) {
+	var info BackupInfo
+	if _, err := os.Stat(path); err != nil {
+		return info, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return info, err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA quick_check`).Scan(&result); err != nil {
+		return info, fmt.Errorf("not a valid database: %w", err)
+	}
+	if result != "ok" {
+		return info, fmt.Errorf("integrity check failed: %s", result)
+	}
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&info.SchemaVersion); err != nil {
+		return info, err
+	}
+	if info.SchemaVersion > schemaVersion {
+		return info, fmt.Errorf("backup has schema version %d, but this build only supports up to %d", info.SchemaVersion, schemaVersion)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&info.Messages); err != nil {
+		return info, fmt.Errorf("not an onebot-tui database: %w", err)
+	}
+	if info.SchemaVersion > 0 {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'kdf_salt'`).Scan(&n); err != nil {
+			return info, err
+		}
+		info.Encrypted = n > 0
+	}
+	return info, nil
+}
+
+// Restore 用备份替换 dbPath 处的数据库。原数据库（连同 WAL 文件）会被改名保留，
+// 返回它的新路径；失败时原数据库保持不变。调用前必须停止所有使用该数据库的进程，
+// 见 LockDatabase。
+func Restore(backupPath, dbPath string) (string, error) {
+	if _, err := CheckBackup(backupPath); err != nil {
+		return "", err
+	}
+
+	tmp := dbPath + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// 中途失败时把已经改名的文件改回去，保证原数据库仍然可用
+	var moved [][2]string
+	fail := func(err error) (string, error) {
+		for i := len(moved) - 1; i >= 0; i-- {
+			os.Rename(moved[i][1], moved[i][0])
+		}
+		os.Remove(tmp)
+		return "", err
+	}
+
+	var saved string
+	if _, err := os.Stat(dbPath); err == nil {
+		saved = dbPath + ".before-restore-" + time.Now().Format(backupTimeFormat)
+		// WAL 中可能还有没合并进主文件的数据，随原数据库一起保留
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(dbPath+suffix, saved+suffix)
+			if errors.Is(err, os.ErrNotExist) && suffix != "" {
+				continue
+			}
+			if err != nil {
+				return fail(err)
+			}
+			moved = append(moved, [2]string{dbPath + suffix, saved + suffix})
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return fail(err)
+	}
+	return saved, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
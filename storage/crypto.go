@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// 加密后的字段以此前缀开头，没有前缀的值视为旧的明文数据
+const encryptedPrefix = "enc:v1:"
+
+// scrypt 参数：在普通笔记本上约需 100ms
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	saltSize     = 16
+	keyCheckText = "onebot-tui key check"
+)
+
+var (
+	// ErrLocked 表示数据库已加密但还没有用口令解锁
+	ErrLocked = errors.New("database is encrypted and locked")
+	// ErrWrongKey 表示口令或密钥文件与数据库不匹配
+	ErrWrongKey = errors.New("wrong passphrase or key file")
+)
+
+// rowCipher 用 AES-256-GCM 加密消息的 content 和 sender_name 列，
+// 并用独立的 HMAC 密钥计算去重键，避免通过明文哈希推测消息内容。
+type rowCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// deriveCipher 用 scrypt 从口令（或密钥文件内容）派生加密密钥和 HMAC 密钥
+func deriveCipher(secret, salt []byte) (*rowCipher, error) {
+	key, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &rowCipher{aead: aead, macKey: key[32:]}, nil
+}
+
+func (c *rowCipher) seal(plaintext string) string {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand 不会失败
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func (c *rowCipher) open(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(value[len(encryptedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
+
+func (c *rowCipher) mac(data string) []byte {
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Encrypted 返回数据库是否启用了加密
+func (s *Store) Encrypted() (bool, error) {
+	salt, err := s.getMeta("kdf_salt")
+	return salt != "", err
+}
+
+// Unlock 用口令或密钥文件内容解锁已加密的数据库，之后的读写会自动加解密
+func (s *Store) Unlock(secret []byte) error {
+	salt, err := s.getMeta("kdf_salt")
+	if err != nil {
+		return err
+	}
+	if salt == "" {
+		return errors.New("database is not encrypted")
+	}
+	check, err := s.getMeta("key_check")
+	if err != nil {
+		return err
+	}
+	c, err := cipherFromMeta(secret, salt)
+	if err != nil {
+		return err
+	}
+	want, err := hex.DecodeString(check)
+	if err != nil || !hmac.Equal(c.mac(keyCheckText), want) {
+		return ErrWrongKey
+	}
+	s.cipher = c
+	s.encrypted = true
+	return s.sealPlainURLs()
+}
+
+// Rekey 用新的口令重新加密所有消息、草稿、输入历史、通知和附件的地址、文件名。数据库未加密时
+// 相当于启用加密；newSecret 为 nil 时把所有消息解密回明文并关闭加密。已加密的数据库必须先
+// Unlock。完成后整理数据库并清空 WAL，旧的密文或明文不会留在空闲页中。
+func (s *Store) Rekey(newSecret []byte) error {
+	encrypted, err := s.Encrypted()
+	if err != nil {
+		return err
+	}
+	if encrypted && s.cipher == nil {
+		return ErrLocked
+	}
+
+	var next *rowCipher
+	var salt []byte
+	if newSecret != nil {
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		if next, err = deriveCipher(newSecret, salt); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recryptRows(tx, s.cipher, next); err != nil {
+		return err
+	}
+	if err := recryptTexts(tx, s.cipher, next); err != nil {
+		return err
+	}
+	if next != nil {
+		err = setMeta(tx, map[string]string{
+			"kdf_salt":  hex.EncodeToString(salt),
+			"key_check": hex.EncodeToString(next.mac(keyCheckText)),
+		})
+	} else {
+		_, err = tx.Exec(`DELETE FROM meta WHERE key IN ('kdf_salt', 'key_check')`)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.cipher = next
+	s.encrypted = next != nil
+	return s.scrub()
+}
+
+// scrub 重建数据库文件并截断 WAL，清除改写前留在空闲页和 WAL 中的旧数据
+func (s *Store) scrub() error {
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("vacuum after rekey: %w", err)
+	}
+	var busy, logFrames, checkpointed int
+	if err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return fmt.Errorf("checkpoint after rekey: %w", err)
+	}
+	if busy != 0 {
+		return errors.New("checkpoint after rekey: database is in use, old data may remain in the WAL")
+	}
+	return nil
+}
+
+// recryptRows 分批把消息从旧密钥转换到新密钥，并重新计算去重键；nil 表示明文
+func recryptRows(tx *sql.Tx, from, to *rowCipher) error {
+	type row struct {
+		msg        adapter.Message
+		senderName string
+		content    string
+	}
+	var lastID int64
+	for {
+		rows, err := tx.Query(`SELECT id, chat_id, sender_id, sender_name, content, timestamp FROM messages WHERE id > ? ORDER BY id LIMIT 1000`, lastID)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			var senderName, content sql.NullString
+			if err := rows.Scan(&r.msg.ID, &r.msg.ChatID, &r.msg.SenderID, &senderName, &content, &r.msg.Time); err != nil {
+				rows.Close()
+				return err
+			}
+			if r.msg.SenderName, err = openWith(from, senderName.String); err == nil {
+				r.msg.Content, err = openWith(from, content.String)
+			}
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("message %d: %w", r.msg.ID, err)
+			}
+			r.senderName = sealWith(to, r.msg.SenderName)
+			r.content = sealWith(to, r.msg.Content)
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, r := range batch {
+			_, err := tx.Exec(`UPDATE messages SET sender_name = ?, content = ?, dedup_key = ? WHERE id = ?`,
+				r.senderName, r.content, dedupKeyWith(to, &r.msg), r.msg.ID)
+			if err != nil {
+				return err
+			}
+		}
+		lastID = batch[len(batch)-1].msg.ID
+	}
+}
+
+func cipherFromMeta(secret []byte, saltHex string) (*rowCipher, error) {
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt kdf salt: %w", err)
+	}
+	return deriveCipher(secret, salt)
+}
+
+func sealWith(c *rowCipher, value string) string {
+	if c == nil {
+		return value
+	}
+	return c.seal(value)
+}
+
+func openWith(c *rowCipher, value string) (string, error) {
+	if c == nil {
+		if strings.HasPrefix(value, encryptedPrefix) {
+			return "", ErrLocked
+		}
+		return value, nil
+	}
+	return c.open(value)
+}
+
+// sealText 和 openText 按 Store 当前的加密状态处理 content、sender_name 列
+func (s *Store) sealText(value string) string { return sealWith(s.cipher, value) }
+
+func (s *Store) openText(value string) (string, error) { return openWith(s.cipher, value) }
+
+// openMessage 解密从数据库读出的消息
+func (s *Store) openMessage(msg *adapter.Message) error {
+	var err error
+	if msg.SenderName, err = s.openText(msg.SenderName); err != nil {
+		return err
+	}
+	msg.Content, err = s.openText(msg.Content)
+	return err
+}
+
+// dedupKeyWith 在加密时用 HMAC 代替普通哈希计算去重键
+func dedupKeyWith(c *rowCipher, msg *adapter.Message) string {
+	if c == nil {
+		return dedupKey(msg)
+	}
+	sum := c.mac(fmt.Sprintf("%s\x00%s\x00%d\x00%s", msg.ChatID, msg.SenderID, msg.Time.Unix(), msg.Content))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *Store) getMeta(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+func setMeta(tx *sql.Tx, values map[string]string) error {
+	for k, v := range values {
+		if _, err := tx.Exec(`INSERT INTO meta(key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
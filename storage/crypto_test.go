@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRowCipher(t *testing.T) {
+	c, err := deriveCipher([]byte("secret"), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("deriveCipher: %v", err)
+	}
+	other, err := deriveCipher([]byte("other"), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("deriveCipher: %v", err)
+	}
+
+	for _, plain := range []string{"", "hello", "你好 [CQ:at,qq=10001]", strings.Repeat("长", 4096)} {
+		sealed := c.seal(plain)
+		if !strings.HasPrefix(sealed, encryptedPrefix) || (plain != "" && strings.Contains(sealed, plain)) {
+			t.Errorf("seal(%.20q) = %.40q, want ciphertext", plain, sealed)
+		}
+		// 每次加密使用新的随机数，相同明文的密文不同
+		if again := c.seal(plain); again == sealed {
+			t.Errorf("seal(%.20q) returned the same ciphertext twice", plain)
+		}
+		if got, err := c.open(sealed); err != nil || got != plain {
+			t.Errorf("open(seal(%.20q)) = %.20q, %v", plain, got, err)
+		}
+		if _, err := other.open(sealed); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("open with another key: err = %v, want ErrWrongKey", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		c       *rowCipher
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plaintext with key", c, "legacy", "legacy", ""},
+		{"plaintext without key", nil, "legacy", "legacy", ""},
+		{"ciphertext without key", nil, c.seal("hidden"), "", ErrLocked.Error()},
+		{"truncated", c, encryptedPrefix + "AAAA", "", "ciphertext too short"},
+		{"bad base64", c, encryptedPrefix + "!!", "", "illegal base64 data"},
+	}
+	for _, tt := range tests {
+		got, err := openWith(tt.c, tt.value)
+		switch {
+		case tt.wantErr == "" && (err != nil || got != tt.want):
+			t.Errorf("%s: openWith = %q, %v, want %q", tt.name, got, err, tt.want)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: openWith err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if sealWith(nil, "plain") != "plain" {
+		t.Error("sealWith(nil) changed the value")
+	}
+	if string(c.mac("a")) == string(other.mac("a")) {
+		t.Error("mac does not depend on the key")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "onebot.db")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer func() { s.Close() }()
+	addTestMessage(t, s, "1", "1", "hello", 0)
+	if err := s.SaveDraft("1", "draft text"); err != nil {
+		t.Fatalf("SaveDraft: %v", err)
+	}
+
+	// reopen 重新打开数据库，模拟守护进程重启后还没有解锁的状态
+	reopen := func() {
+		t.Helper()
+		s.Close()
+		if s, err = NewStore(path); err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+	}
+	// rawContent 返回数据库中保存的原始内容
+	rawContent := func() string {
+		t.Helper()
+		var content string
+		if err := s.db.QueryRow(`SELECT content FROM messages`).Scan(&content); err != nil {
+			t.Fatalf("reading raw content: %v", err)
+		}
+		return content
+	}
+	// check 确认消息和草稿能读出明文，且再次导入同一条消息会被当作重复
+	check := func(step string) {
+		t.Helper()
+		got, err := s.GetMessages("1", 10)
+		if err != nil || !slices.Equal(contents(got), []string{"hello"}) || got[0].SenderName != "user1" {
+			t.Errorf("%s: GetMessages = %+v, %v", step, got, err)
+		}
+		if draft, err := s.GetDraft("1"); err != nil || draft != "draft text" {
+			t.Errorf("%s: GetDraft = %q, %v", step, draft, err)
+		}
+		exported := `{"chat_id":"1","sender_id":"1","sender_name":"user1","content":"hello","time":"2024-05-01T12:00:00Z"}`
+		stats, err := s.Import(strings.NewReader(exported), ImportOptions{Format: ImportJSONL, DryRun: true})
+		if err != nil || stats.Duplicates != 1 {
+			t.Errorf("%s: importing the stored message = %+v, %v, want a duplicate", step, stats, err)
+		}
+	}
+
+	if err := s.Rekey([]byte("first")); err != nil {
+		t.Fatalf("Rekey to first: %v", err)
+	}
+	if !strings.HasPrefix(rawContent(), encryptedPrefix) {
+		t.Errorf("content after Rekey = %q, want ciphertext", rawContent())
+	}
+	check("encrypted")
+
+	reopen()
+	if encrypted, err := s.Encrypted(); err != nil || !encrypted {
+		t.Errorf("Encrypted = %v, %v, want true", encrypted, err)
+	}
+	if _, err := s.GetMessages("1", 10); !errors.Is(err, ErrLocked) {
+		t.Errorf("GetMessages before Unlock: err = %v, want ErrLocked", err)
+	}
+	if err := s.Rekey([]byte("second")); !errors.Is(err, ErrLocked) {
+		t.Errorf("Rekey before Unlock: err = %v, want ErrLocked", err)
+	}
+	if err := s.Unlock([]byte("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Unlock with a wrong key: err = %v, want ErrWrongKey", err)
+	}
+	if err := s.Unlock([]byte("first")); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	check("unlocked")
+
+	before := rawContent()
+	if err := s.Rekey([]byte("second")); err != nil {
+		t.Fatalf("Rekey to second: %v", err)
+	}
+	if after := rawContent(); after == before || !strings.HasPrefix(after, encryptedPrefix) {
+		t.Errorf("content after changing the key = %q, want new ciphertext", after)
+	}
+	reopen()
+	if err := s.Unlock([]byte("first")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Unlock with the old key: err = %v, want ErrWrongKey", err)
+	}
+	if err := s.Unlock([]byte("second")); err != nil {
+		t.Fatalf("Unlock with the new key: %v", err)
+	}
+	check("rekeyed")
+
+	if err := s.Rekey(nil); err != nil {
+		t.Fatalf("Rekey to plaintext: %v", err)
+	}
+	reopen()
+	if encrypted, err := s.Encrypted(); err != nil || encrypted {
+		t.Errorf("Encrypted after decrypting = %v, %v, want false", encrypted, err)
+	}
+	if raw := rawContent(); raw != "hello" {
+		t.Errorf("content after decrypting = %q, want plaintext", raw)
+	}
+	check("decrypted")
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 每个聊天最多保留的输入历史条数
+const inputHistoryLimit = 200
+
+// SaveDraft 保存聊天输入框中未发送的内容，空字符串表示删除草稿
+func (s *Store) SaveDraft(chatID, text string) error {
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	if text == "" {
+		_, err := s.db.Exec(`DELETE FROM drafts WHERE chat_id = ?`, chatID)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO drafts(chat_id, text, updated) VALUES (?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET text = excluded.text, updated = excluded.updated`,
+		chatID, s.sealText(text), dbTime(time.Now()))
+	return err
+}
+
+// GetDraft 返回聊天的草稿，没有时返回空字符串
+func (s *Store) GetDraft(chatID string) (string, error) {
+	var text string
+	err := s.db.QueryRow(`SELECT text FROM drafts WHERE chat_id = ?`, chatID).Scan(&text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.openText(text)
+}
+
+// AddInputHistory 记录一条发送过的输入，每个聊天只保留最近 inputHistoryLimit 条
+func (s *Store) AddInputHistory(chatID, text string) error {
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO input_history(chat_id, text, timestamp) VALUES (?, ?, ?)`, chatID, s.sealText(text), dbTime(time.Now())); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM input_history WHERE chat_id = ? AND id NOT IN (
+		SELECT id FROM input_history WHERE chat_id = ? ORDER BY id DESC LIMIT ?)`, chatID, chatID, inputHistoryLimit)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetInputHistory 按时间顺序返回聊天最近的 limit 条输入
+func (s *Store) GetInputHistory(chatID string, limit int) ([]string, error) {
+	rows, err := s.db.Query(`SELECT text FROM input_history WHERE chat_id = ? ORDER BY id DESC LIMIT ?`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []string
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		if text, err = s.openText(text); err != nil {
+			return nil, err
+		}
+		history = append(history, text)
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, rows.Err()
+}
+
+// recryptTexts 把 drafts、input_history 中的文本，notices 的 text 和 attachments 的
+// url、file_name 从旧密钥转换到新密钥，并重新计算 url_key。这几张表都不大，每张一次处理完。
+func recryptTexts(tx *sql.Tx, from, to *rowCipher) error {
+	for _, col := range [][2]string{{"drafts", "text"}, {"input_history", "text"}, {"notices", "text"}, {"attachments", "url"}, {"attachments", "file_name"}} {
+		table, column := col[0], col[1]
+		rows, err := tx.Query(`SELECT rowid, ` + column + ` FROM ` + table + ` WHERE ` + column + ` != ''`)
+		if err != nil {
+			return err
+		}
+		texts := make(map[int64]string)
+		keys := make(map[int64]string) // url_key 依赖密钥，和地址一起改写
+		for rows.Next() {
+			var id int64
+			var text string
+			if err := rows.Scan(&id, &text); err != nil {
+				rows.Close()
+				return err
+			}
+			plain, err := openWith(from, text)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("%s row %d: %w", table, id, err)
+			}
+			texts[id] = sealWith(to, plain)
+			if column == "url" {
+				keys[id] = urlKeyWith(to, plain)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, text := range texts {
+			if _, err := tx.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE rowid = ?`, text, id); err != nil {
+				return err
+			}
+		}
+		for id, key := range keys {
+			if _, err := tx.Exec(`UPDATE attachments SET url_key = ? WHERE rowid = ?`, key, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
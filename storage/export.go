@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// ExportFormat 是聊天记录导出的文件格式
+type ExportFormat string
+
+const (
+	ExportJSONL    ExportFormat = "jsonl"
+	ExportMarkdown ExportFormat = "markdown"
+	ExportHTML     ExportFormat = "html"
+	ExportText     ExportFormat = "text"
+)
+
+// ParseExportFormat 解析命令行或 HTTP 参数中的格式名称，支持常见的别名
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "jsonl", "json":
+		return ExportJSONL, nil
+	case "markdown", "md":
+		return ExportMarkdown, nil
+	case "html", "htm":
+		return ExportHTML, nil
+	case "text", "txt":
+		return ExportText, nil
+	}
+	return "", fmt.Errorf("unknown export format %q (want jsonl, markdown, html or text)", name)
+}
+
+// Ext 返回该格式对应的文件扩展名
+func (f ExportFormat) Ext() string {
+	switch f {
+	case ExportMarkdown:
+		return "md"
+	case ExportHTML:
+		return "html"
+	case ExportText:
+		return "txt"
+	}
+	return "jsonl"
+}
+
+// ContentType 返回该格式对应的 MIME 类型
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportMarkdown:
+		return "text/markdown; charset=utf-8"
+	case ExportHTML:
+		return "text/html; charset=utf-8"
+	case ExportText:
+		return "text/plain; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// ExportOptions 描述一次导出的范围和格式
+type ExportOptions struct {
+	ChatID   string
+	ChatName string    // 写入文件标题，为空时使用 ChatID
+	Since    time.Time // 零值表示不限制
+	Until    time.Time // 零值表示不限制
+	Format   ExportFormat
+	// InlineImage 把图片转换为 data URI，使 HTML 不依赖外部文件；失败时以文字占位，
+	// 为 nil 时保留远程地址
+	InlineImage func(url string) (string, error)
+}
+
+// ExportResult 汇总一次导出
+type ExportResult struct {
+	Messages      int
+	MissingImages int // HTML 中没能内联、以文字占位的图片数
+}
+
+// ExportedMessage 是 JSON Lines 导出中每一行的结构，导入时也使用它
+type ExportedMessage struct {
+	ID         int64     `json:"id"`
+	ChatID     string    `json:"chat_id"`
+	ChatType   string    `json:"chat_type"`
+	SenderID   string    `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"`
+	Time       time.Time `json:"time"`
+	Mentioned  bool      `json:"mentioned,omitempty"`
+}
+
+// Export 把一个聊天在时间范围内的消息逐条写入 w，不会把整段历史读入内存
+func Export(b Backend, w io.Writer, opts ExportOptions) (ExportResult, error) {
+	var res ExportResult
+	if opts.ChatName == "" {
+		opts.ChatName = opts.ChatID
+	}
+	names, err := b.SenderNames(opts.ChatID)
+	if err != nil {
+		return res, err
+	}
+
+	bw := bufio.NewWriter(w)
+	var (
+		exp exporter
+		he  *htmlExporter
+	)
+	switch opts.Format {
+	case ExportJSONL, "":
+		exp = &jsonlExporter{enc: json.NewEncoder(bw)}
+	case ExportMarkdown:
+		exp = &markdownExporter{w: bw, names: names}
+	case ExportHTML:
+		he = &htmlExporter{w: bw, names: names, inlineImage: opts.InlineImage}
+		exp = he
+	case ExportText:
+		exp = &textExporter{w: bw, names: names}
+	default:
+		return res, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	if err := exp.begin(opts); err != nil {
+		return res, err
+	}
+	err = b.ForEachMessage(opts.ChatID, opts.Since, opts.Until, func(msg adapter.Message) error {
+		res.Messages++
+		return exp.write(msg)
+	})
+	if he != nil {
+		res.MissingImages = he.missing
+	}
+	if err != nil {
+		return res, err
+	}
+	if err := exp.end(); err != nil {
+		return res, err
+	}
+	return res, bw.Flush()
+}
+
+// ForEachMessage 按时间顺序遍历一个聊天在时间范围内的消息
+func (s *Store) ForEachMessage(chatID string, since, until time.Time, fn func(adapter.Message) error) error {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE chat_id = ?`
+	args := []interface{}{chatID}
+	if !since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, dbTime(since))
+	}
+	if !until.IsZero() {
+		query += ` AND timestamp < ?`
+		args = append(args, dbTime(until))
+	}
+	query += ` ORDER BY timestamp, id`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg adapter.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return err
+		}
+		if err := s.openMessage(&msg); err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SenderNames 返回聊天中每个发送者最近使用的昵称，用于解析 @ 等 CQ 码
+func (s *Store) SenderNames(chatID string) (map[string]string, error) {
+	rows, err := s.db.Query(`
+	SELECT sender_id, sender_name FROM messages
+	WHERE id IN (SELECT MAX(id) FROM messages WHERE chat_id = ? GROUP BY sender_id)`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if names[id], err = s.openText(name); err != nil {
+			return nil, err
+		}
+	}
+	return names, rows.Err()
+}
+
+type exporter interface {
+	begin(opts ExportOptions) error
+	write(msg adapter.Message) error
+	end() error
+}
+
+type jsonlExporter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExporter) begin(ExportOptions) error { return nil }
+func (e *jsonlExporter) end() error                { return nil }
+
+func (e *jsonlExporter) write(msg adapter.Message) error {
+	return e.enc.Encode(ExportedMessage{
+		ID:         msg.ID,
+		ChatID:     msg.ChatID,
+		ChatType:   msg.ChatType,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Content:    msg.Content,
+		Time:       msg.Time,
+		Mentioned:  msg.Mentioned,
+	})
+}
+
+type textExporter struct {
+	w     *bufio.Writer
+	names map[string]string
+}
+
+func (e *textExporter) begin(opts ExportOptions) error {
+	_, err := fmt.Fprintf(e.w, "消息记录: %s (%s)\n%s\n\n", opts.ChatName, opts.ChatID, exportRange(opts))
+	return err
+}
+
+func (e *textExporter) end() error { return nil }
+
+func (e *textExporter) write(msg adapter.Message) error {
+	_, err := fmt.Fprintf(e.w, "%s %s(%s)\n%s\n\n",
+		msg.Time.Format("2006-01-02 15:04:05"), msg.SenderName, msg.SenderID, renderPlain(msg.Content, e.names))
+	return err
+}
+
+type markdownExporter struct {
+	w       *bufio.Writer
+	names   map[string]string
+	lastDay string
+}
+
+func (e *markdownExporter) begin(opts ExportOptions) error {
+	_, err := fmt.Fprintf(e.w, "# %s\n\n> 聊天 ID: `%s`  \n> %s\n", opts.ChatName, opts.ChatID, exportRange(opts))
+	return err
+}
+
+func (e *markdownExporter) end() error { return nil }
+
+func (e *markdownExporter) write(msg adapter.Message) error {
+	if day := msg.Time.Format("2006-01-02"); day != e.lastDay {
+		e.lastDay = day
+		if _, err := fmt.Fprintf(e.w, "\n## %s\n\n", day); err != nil {
+			return err
+		}
+	}
+	body := renderPlain(msg.Content, e.names)
+	// 续行缩进两个空格，让多行消息留在同一个列表项里
+	body = strings.ReplaceAll(body, "\n", "  \n  ")
+	_, err := fmt.Fprintf(e.w, "- **%s** `%s`  \n  %s\n", msg.SenderName, msg.Time.Format("15:04:05"), body)
+	return err
+}
+
+type htmlExporter struct {
+	w           *bufio.Writer
+	names       map[string]string
+	inlineImage func(string) (string, error)
+	lastDay     string
+	missing     int // 没能内联的图片数
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 860px; margin: 2em auto; color: #222; background: #fafafa; }
+h1 { font-size: 1.4em; } .range { color: #888; }
+.day { text-align: center; color: #888; margin: 1.5em 0 .5em; }
+.msg { background: #fff; border-radius: 6px; padding: .5em .8em; margin: .4em 0; box-shadow: 0 1px 2px rgba(0,0,0,.08); }
+.sender { font-weight: bold; } .time { color: #999; font-size: .85em; margin-left: .5em; }
+.content { white-space: pre-wrap; word-break: break-word; margin-top: .2em; }
+.content img { max-width: 100%%; max-height: 360px; display: block; margin: .3em 0; }
+.chip { display: inline-block; background: #eef; border-radius: 4px; padding: 0 .4em; color: #446; font-size: .9em; }
+.at { color: #1a73e8; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+<p class="range">聊天 ID: %s · %s</p>
+`
+
+func (e *htmlExporter) begin(opts ExportOptions) error {
+	title := html.EscapeString(opts.ChatName)
+	_, err := fmt.Fprintf(e.w, htmlHeader, title, title, html.EscapeString(opts.ChatID), html.EscapeString(exportRange(opts)))
+	return err
+}
+
+func (e *htmlExporter) end() error {
+	_, err := e.w.WriteString("</body>\n</html>\n")
+	return err
+}
+
+func (e *htmlExporter) write(msg adapter.Message) error {
+	if day := msg.Time.Format("2006-01-02"); day != e.lastDay {
+		e.lastDay = day
+		if _, err := fmt.Fprintf(e.w, "<div class=\"day\">%s</div>\n", day); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(e.w, "<div class=\"msg\"><span class=\"sender\" title=\"%s\">%s</span><span class=\"time\">%s</span><div class=\"content\">%s</div></div>\n",
+		html.EscapeString(msg.SenderID), html.EscapeString(msg.SenderName), msg.Time.Format("15:04:05"), e.render(msg.Content))
+	return err
+}
+
+func exportRange(opts ExportOptions) string {
+	from, to := "最早", "现在"
+	if !opts.Since.IsZero() {
+		from = opts.Since.Format("2006-01-02 15:04")
+	}
+	if !opts.Until.IsZero() {
+		to = opts.Until.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("导出范围: %s ~ %s", from, to)
+}
+
+// segmentLabel 返回非文本片段的简短文字描述
+func segmentLabel(seg adapter.Segment, names map[string]string) string {
+	switch seg.Type {
+	case "at":
+		qq := seg.Data["qq"]
+		if qq == "all" {
+			return "@全体成员"
+		}
+		if name := names[qq]; name != "" {
+			return "@" + name
+		}
+		return "@" + qq
+	case "image":
+		return "[图片]"
+	case "face":
+		return "[表情]"
+	case "record":
+		return "[语音]"
+	case "video":
+		return "[视频]"
+	case "file":
+		if name := seg.Data["name"]; name != "" {
+			return "[文件: " + name + "]"
+		}
+		return "[文件]"
+	case "reply":
+		return "[回复]"
+	case "forward":
+		return "[转发]"
+	case "json", "xml":
+		return "[卡片]"
+	}
+	return "[" + seg.Type + "]"
+}
+
+// renderPlain 把消息中的 CQ 码渲染为纯文本
+func renderPlain(content string, names map[string]string) string {
+	var b strings.Builder
+	for _, seg := range adapter.ParseCQ(content) {
+		if seg.Type == "text" {
+			b.WriteString(seg.Text())
+		} else {
+			b.WriteString(segmentLabel(seg, names))
+		}
+	}
+	return b.String()
+}
+
+// render 把消息中的 CQ 码渲染为 HTML 片段，图片尽量内联为 data URI
+func (e *htmlExporter) render(content string) string {
+	var b strings.Builder
+	for _, seg := range adapter.ParseCQ(content) {
+		switch seg.Type {
+		case "text":
+			b.WriteString(html.EscapeString(seg.Text()))
+		case "image":
+			url := seg.Data["url"]
+			if url != "" && e.inlineImage != nil {
+				if data, err := e.inlineImage(url); err == nil {
+					url = data
+				} else {
+					e.missing++
+					fmt.Fprintf(&b, `<span class="chip" title="%s">[图片未能保存: %s]</span>`, html.EscapeString(url), html.EscapeString(err.Error()))
+					continue
+				}
+			}
+			if url != "" {
+				fmt.Fprintf(&b, `<img src="%s" alt="[图片]" loading="lazy">`, html.EscapeString(url))
+			} else {
+				// 地址已被保留策略清除
+				if e.inlineImage != nil {
+					e.missing++
+				}
+				b.WriteString(`<span class="chip">[图片]</span>`)
+			}
+		case "at":
+			fmt.Fprintf(&b, `<span class="at">%s</span>`, html.EscapeString(segmentLabel(seg, e.names)))
+		default:
+			fmt.Fprintf(&b, `<span class="chip">%s</span>`, html.EscapeString(segmentLabel(seg, e.names)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,475 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// ImportFormat 是可导入的聊天记录格式
+type ImportFormat string
+
+const (
+	ImportAuto      ImportFormat = "auto"
+	ImportJSONL     ImportFormat = "jsonl"      // 本程序导出的 JSON Lines
+	ImportQQText    ImportFormat = "qq-txt"     // QQ 导出的文本格式消息记录
+	ImportQQMHT     ImportFormat = "qq-mht"     // QQ 导出的 MHT 网页格式消息记录
+	ImportGoCQLog   ImportFormat = "gocq-log"   // go-cqhttp 的运行日志
+	ImportNapCatLog ImportFormat = "napcat-log" // NapCat 的运行日志
+)
+
+// ImportOptions 控制一次导入
+type ImportOptions struct {
+	Format    ImportFormat
+	ChatID    string            // QQ 导出文件中没有聊天 ID，必须由调用方提供
+	ChatType  string            // "group" 或 "private"，缺省为 "group"
+	SenderMap map[string]string // 昵称 -> QQ 号，用于补全导出文件中缺失的发送者 ID
+	DryRun    bool              // 只解析和查重，不写入数据库
+	Progress  func(ImportStats) // 每处理一批消息回调一次，可为 nil
+}
+
+// ImportStats 汇总导入过程中的计数
+type ImportStats struct {
+	Parsed     int // 成功解析的消息数
+	Imported   int // 写入数据库（或 DryRun 时将会写入）的消息数
+	Duplicates int // 数据库中已存在或在文件中重复出现而被跳过的消息数
+	Skipped    int // 无法解析而被跳过的行或记录数
+}
+
+const importBatchSize = 500
+
+// DetectImportFormat 根据文件名和开头的内容猜测导入格式
+func DetectImportFormat(name string, head []byte) ImportFormat {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		return ImportJSONL
+	case ".mht", ".mhtml":
+		return ImportQQMHT
+	}
+	text := string(head)
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(text), "{"):
+		return ImportJSONL
+	case strings.Contains(text, "MIME-Version"):
+		return ImportQQMHT
+	case strings.Contains(text, "消息记录") || strings.Contains(text, "消息对象:"):
+		return ImportQQText
+	case strings.Contains(text, "收到群") || strings.Contains(text, "收到好友"):
+		return ImportGoCQLog
+	case strings.Contains(text, "接收 <-"):
+		return ImportNapCatLog
+	}
+	return ImportQQText
+}
+
+// Import 解析 r 中的聊天记录并写入 messages 表，已存在的消息会被跳过
+func (s *Store) Import(r io.Reader, opts ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+	if err := s.checkUnlocked(); err != nil {
+		return stats, err
+	}
+	if opts.ChatType == "" {
+		opts.ChatType = "group"
+	}
+
+	var parse func(io.Reader, *ImportOptions, *ImportStats, func(adapter.Message) error) error
+	switch opts.Format {
+	case ImportJSONL:
+		parse = parseJSONL
+	case ImportQQText:
+		parse = parseQQText
+	case ImportQQMHT:
+		parse = parseQQMHT
+	case ImportGoCQLog:
+		parse = parseGoCQLog
+	case ImportNapCatLog:
+		parse = parseNapCatLog
+	default:
+		return stats, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+	if (opts.Format == ImportQQText || opts.Format == ImportQQMHT) && opts.ChatID == "" {
+		return stats, errors.New("QQ exports do not contain the chat ID, please specify it")
+	}
+
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	commit := func() error {
+		if tx == nil {
+			return nil
+		}
+		err := tx.Commit()
+		tx = nil
+		return err
+	}
+
+	// 文件中已经出现过的消息，DryRun 时它们不在数据库中，查库发现不了
+	seen := make(map[string]struct{})
+	err := parse(r, &opts, &stats, func(msg adapter.Message) error {
+		stats.Parsed++
+		if msg.SenderID == "" {
+			msg.SenderID = opts.SenderMap[msg.SenderName]
+		}
+		key := dedupKeyWith(s.cipher, &msg)
+		if _, ok := seen[key]; ok {
+			stats.Duplicates++
+			return importProgress(&stats, &opts, commit)
+		}
+		seen[key] = struct{}{}
+
+		query := s.db.QueryRow
+		if tx != nil {
+			query = tx.QueryRow
+		}
+		var exists int
+		err := query(`SELECT COUNT(*) FROM messages WHERE dedup_key = ?`, key).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			stats.Duplicates++
+		} else if !opts.DryRun {
+			if tx == nil {
+				if tx, err = s.db.Begin(); err != nil {
+					return err
+				}
+			}
+			_, err = tx.Exec(`INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp, mentioned, dedup_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				msg.ChatID, msg.ChatType, msg.SenderID, s.sealText(msg.SenderName), s.sealText(msg.Content), dbTime(msg.Time), msg.Mentioned, key)
+			if err != nil {
+				return err
+			}
+			stats.Imported++
+		} else {
+			stats.Imported++
+		}
+		return importProgress(&stats, &opts, commit)
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := commit(); err != nil {
+		return stats, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(stats)
+	}
+	return stats, nil
+}
+
+// importProgress 每处理 importBatchSize 条消息提交一次事务并报告进度
+func importProgress(stats *ImportStats, opts *ImportOptions, commit func() error) error {
+	if stats.Parsed%importBatchSize != 0 {
+		return nil
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	if opts.Progress != nil {
+		opts.Progress(*stats)
+	}
+	return nil
+}
+
+// newLineScanner 返回一个能处理超长行的按行扫描器
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return sc
+}
+
+// trimLine 去掉行首的 UTF-8 BOM 和行尾的回车
+func trimLine(line string) string {
+	return strings.TrimRight(strings.TrimPrefix(line, "\uFEFF"), "\r")
+}
+
+func parseJSONL(r io.Reader, opts *ImportOptions, stats *ImportStats, emit func(adapter.Message) error) error {
+	sc := newLineScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(trimLine(sc.Text()))
+		if line == "" {
+			continue
+		}
+		var em ExportedMessage
+		if err := json.Unmarshal([]byte(line), &em); err != nil || em.Time.IsZero() {
+			stats.Skipped++
+			continue
+		}
+		msg := adapter.Message{
+			ChatID:     em.ChatID,
+			ChatType:   em.ChatType,
+			SenderID:   em.SenderID,
+			SenderName: em.SenderName,
+			Content:    em.Content,
+			Time:       em.Time,
+			Mentioned:  em.Mentioned,
+		}
+		if opts.ChatID != "" {
+			msg.ChatID = opts.ChatID
+		}
+		if msg.ChatID == "" {
+			stats.Skipped++
+			continue
+		}
+		if msg.ChatType == "" {
+			msg.ChatType = opts.ChatType
+		}
+		if err := emit(msg); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// QQ 文本导出中每条消息的头部，如 "2019-01-01 12:00:00 昵称(123456)" 或 "2019-01-01 12:00:00 昵称<a@qq.com>"
+var qqTextHeaderRegex = regexp.MustCompile(`^(\d{4}-\d{1,2}-\d{1,2} \d{1,2}:\d{2}:\d{2}) (.*?)(?:\((\d+)\)|<([^<>]+)>)?$`)
+
+func parseQQText(r io.Reader, opts *ImportOptions, stats *ImportStats, emit func(adapter.Message) error) error {
+	var (
+		cur     *adapter.Message
+		lines   []string
+		started bool
+	)
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		cur.Content = adapter.EscapeCQText(strings.TrimRight(strings.Join(lines, "\n"), "\n"))
+		msg := *cur
+		cur, lines = nil, nil
+		return emit(msg)
+	}
+
+	sc := newLineScanner(r)
+	for sc.Scan() {
+		line := trimLine(sc.Text())
+		if m := qqTextHeaderRegex.FindStringSubmatch(line); m != nil {
+			t, err := time.ParseInLocation("2006-1-2 15:04:05", m[1], time.Local)
+			if err == nil {
+				if err := flush(); err != nil {
+					return err
+				}
+				started = true
+				cur = &adapter.Message{
+					ChatID:     opts.ChatID,
+					ChatType:   opts.ChatType,
+					SenderID:   m[3],
+					SenderName: strings.TrimSpace(m[2]),
+					Time:       t,
+				}
+				continue
+			}
+		}
+		if cur != nil {
+			lines = append(lines, line)
+		} else if started && strings.TrimSpace(line) != "" {
+			stats.Skipped++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+var (
+	mhtRowRegex     = regexp.MustCompile(`(?is)<tr>(.*?)</tr>`)
+	mhtDateRegex    = regexp.MustCompile(`日期[:：]\s*(\d{4}-\d{1,2}-\d{1,2})`)
+	mhtSenderRegex  = regexp.MustCompile(`(?is)<div[^>]*float:\s*left[^>]*>(.*?)</div>\s*(\d{1,2}:\d{2}:\d{2})\s*</div>(.*)$`)
+	mhtImageRegex   = regexp.MustCompile(`(?i)<img[^>]*>`)
+	mhtBreakRegex   = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mhtTagRegex     = regexp.MustCompile(`<[^>]+>`)
+	mhtQQFromSender = regexp.MustCompile(`^(.*?)\((\d+)\)$`)
+)
+
+func parseQQMHT(r io.Reader, opts *ImportOptions, stats *ImportStats, emit func(adapter.Message) error) error {
+	doc, err := extractMHTHTML(r)
+	if err != nil {
+		return err
+	}
+
+	var day time.Time
+	for _, row := range mhtRowRegex.FindAllStringSubmatch(doc, -1) {
+		cell := row[1]
+		if m := mhtDateRegex.FindStringSubmatch(mhtTagRegex.ReplaceAllString(cell, "")); m != nil && !strings.Contains(cell, "float") {
+			if d, err := time.ParseInLocation("2006-1-2", m[1], time.Local); err == nil {
+				day = d
+			}
+			continue
+		}
+		m := mhtSenderRegex.FindStringSubmatch(cell)
+		if m == nil || day.IsZero() {
+			stats.Skipped++
+			continue
+		}
+		clock, err := time.Parse("15:04:05", m[2])
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+
+		sender := html.UnescapeString(mhtTagRegex.ReplaceAllString(m[1], ""))
+		var senderID string
+		if qm := mhtQQFromSender.FindStringSubmatch(sender); qm != nil {
+			sender, senderID = qm[1], qm[2]
+		}
+		body := mhtImageRegex.ReplaceAllString(m[3], "[图片]")
+		body = mhtBreakRegex.ReplaceAllString(body, "\n")
+		body = html.UnescapeString(mhtTagRegex.ReplaceAllString(body, ""))
+
+		err = emit(adapter.Message{
+			ChatID:     opts.ChatID,
+			ChatType:   opts.ChatType,
+			SenderID:   senderID,
+			SenderName: strings.TrimSpace(sender),
+			Content:    adapter.EscapeCQText(strings.TrimSpace(body)),
+			Time:       day.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute + time.Duration(clock.Second())*time.Second),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractMHTHTML 从 MHT 文件中取出 HTML 正文，并处理 quoted-printable 编码
+func extractMHTHTML(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read MHT header: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		// 不是 MIME 封装，直接当作 HTML 处理
+		data, err := io.ReadAll(br)
+		return string(data), err
+	}
+
+	mr := multipart.NewReader(br, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return "", errors.New("no HTML part found in MHT file")
+		}
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		// multipart.Reader 会自动解码 quoted-printable，这里只需处理 base64
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))), nil
+	}
+}
+
+var (
+	// [2021-06-01 12:00:00] [INFO]: 收到群 群名(123456) 内 昵称(654321) 的消息: 内容 (-12345)
+	gocqGroupRegex = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\] \[\w+\]: 收到群 .*?\((\d+)\) 内 (.*?)\((\d+)\) 的消息: (.*) \(-?\d+\)$`)
+	// [2021-06-01 12:00:00] [INFO]: 收到好友 昵称(654321) 的消息: 内容 (-12345)
+	gocqPrivateRegex = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\] \[\w+\]: 收到好友 (.*?)\((\d+)\) 的消息: (.*) \(-?\d+\)$`)
+)
+
+func parseGoCQLog(r io.Reader, opts *ImportOptions, stats *ImportStats, emit func(adapter.Message) error) error {
+	sc := newLineScanner(r)
+	for sc.Scan() {
+		line := trimLine(sc.Text())
+		var msg adapter.Message
+		var ts string
+		if m := gocqGroupRegex.FindStringSubmatch(line); m != nil {
+			ts = m[1]
+			msg = adapter.Message{ChatID: m[2], ChatType: "group", SenderName: m[3], SenderID: m[4], Content: m[5]}
+		} else if m := gocqPrivateRegex.FindStringSubmatch(line); m != nil {
+			ts = m[1]
+			msg = adapter.Message{ChatID: m[3], ChatType: "private", SenderName: m[2], SenderID: m[3], Content: m[4]}
+		} else {
+			// 日志中绝大部分行都不是消息，不计入跳过数
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", ts, time.Local)
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+		msg.Time = t
+		if err := emit(msg); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+var (
+	// 07-19 02:55:50 [info] 机器人 | 接收 <- 群聊 [群名(123456)] [昵称(654321)] 内容
+	napcatGroupRegex = regexp.MustCompile(`^(?:\[)?((?:\d{4}-)?\d{2}-\d{2} \d{2}:\d{2}:\d{2})(?:\])? .*?接收 <- 群聊 \[.*?\((\d+)\)\] \[(.*?)\((\d+)\)\] (.*)$`)
+	// 07-19 02:55:50 [info] 机器人 | 接收 <- 私聊 [昵称(654321)] 内容
+	napcatPrivateRegex = regexp.MustCompile(`^(?:\[)?((?:\d{4}-)?\d{2}-\d{2} \d{2}:\d{2}:\d{2})(?:\])? .*?接收 <- 私聊 \[(.*?)\((\d+)\)\] (.*)$`)
+)
+
+func parseNapCatLog(r io.Reader, opts *ImportOptions, stats *ImportStats, emit func(adapter.Message) error) error {
+	sc := newLineScanner(r)
+	for sc.Scan() {
+		line := trimLine(sc.Text())
+		var msg adapter.Message
+		var ts string
+		if m := napcatGroupRegex.FindStringSubmatch(line); m != nil {
+			ts = m[1]
+			msg = adapter.Message{ChatID: m[2], ChatType: "group", SenderName: m[3], SenderID: m[4], Content: m[5]}
+		} else if m := napcatPrivateRegex.FindStringSubmatch(line); m != nil {
+			ts = m[1]
+			msg = adapter.Message{ChatID: m[3], ChatType: "private", SenderName: m[2], SenderID: m[3], Content: m[4]}
+		} else {
+			continue
+		}
+		t, err := parseNapCatTime(ts)
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+		msg.Time = t
+		if err := emit(msg); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// parseNapCatTime 解析 NapCat 日志时间；控制台日志不带年份时按今年处理，
+// 若得到的时间在未来则认为是去年的日志。
+func parseNapCatTime(ts string) (time.Time, error) {
+	if len(ts) > len("01-02 15:04:05") {
+		return time.ParseInLocation("2006-01-02 15:04:05", ts, time.Local)
+	}
+	now := time.Now()
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", fmt.Sprintf("%d-%s", now.Year(), ts), time.Local)
+	if err == nil && t.After(now) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, err
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// localTime 返回本地时区 2024-05-01 12:00 之后 offset 秒的时间，导入的文件都按本地时间解析
+func localTime(offset int) time.Time {
+	return time.Date(2024, 5, 1, 12, 0, offset, 0, time.Local)
+}
+
+const testMHT = "MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; boundary=\"B\"\r\n" +
+	"\r\n" +
+	"--B\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<table><tr><td>日期: 2024-05-01</td></tr>\r\n" +
+	"<tr><td><div style=color:#006EFE><div style=float:left;margin-right:6px;>alice(10001)</div>12:00:00</div><div>hello<br>world &amp; <img src=\"a.png\"></div></td></tr>\r\n" +
+	"<tr><td><div style=color:#42B475><div style=float:left;margin-right:6px;>bob</div>12:00:05</div><div>hi</div></td></tr>\r\n" +
+	"<tr><td>broken</td></tr>\r\n" +
+	"</table>\r\n" +
+	"--B--\r\n"
+
+func TestImportFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  ImportOptions
+		input string
+		want  []adapter.Message
+		stats ImportStats
+	}{
+		{
+			name: "jsonl",
+			opts: ImportOptions{Format: ImportJSONL},
+			input: `{"chat_id":"20001","chat_type":"group","sender_id":"10001","sender_name":"alice","content":"hello","time":"2024-05-01T04:00:00Z"}` + "\n" +
+				"not json\n" +
+				`{"sender_id":"10001","content":"no chat","time":"2024-05-01T04:00:01Z"}` + "\n" +
+				"\n" +
+				`{"chat_id":"20001","sender_id":"10002","sender_name":"bob","content":"hi","time":"2024-05-01T04:00:02Z","mentioned":true}` + "\n",
+			want: []adapter.Message{
+				{ChatID: "20001", ChatType: "group", SenderID: "10001", SenderName: "alice", Content: "hello", Time: time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC)},
+				{ChatID: "20001", ChatType: "group", SenderID: "10002", SenderName: "bob", Content: "hi", Time: time.Date(2024, 5, 1, 4, 0, 2, 0, time.UTC), Mentioned: true},
+			},
+			stats: ImportStats{Parsed: 2, Imported: 2, Skipped: 2},
+		},
+		{
+			name: "qq-txt",
+			opts: ImportOptions{Format: ImportQQText, ChatID: "20001", SenderMap: map[string]string{"bob": "10002"}},
+			input: "\uFEFF消息记录（此消息记录为文本格式，不支持重新导入）\r\n" +
+				"\r\n" +
+				"消息对象:测试群\r\n" +
+				"\r\n" +
+				"2024-05-01 12:00:00 alice(10001)\r\n" +
+				"hello [图片]\r\n" +
+				"\r\n" +
+				"2024-05-01 12:00:05 bob<bob@example.com>\r\n" +
+				"line one\r\n" +
+				"line two\r\n",
+			want: []adapter.Message{
+				{ChatID: "20001", ChatType: "group", SenderID: "10001", SenderName: "alice", Content: "hello &#91;图片&#93;", Time: localTime(0)},
+				{ChatID: "20001", ChatType: "group", SenderID: "10002", SenderName: "bob", Content: "line one\nline two", Time: localTime(5)},
+			},
+			stats: ImportStats{Parsed: 2, Imported: 2},
+		},
+		{
+			name:  "qq-mht",
+			opts:  ImportOptions{Format: ImportQQMHT, ChatID: "10002", ChatType: "private"},
+			input: testMHT,
+			want: []adapter.Message{
+				{ChatID: "10002", ChatType: "private", SenderID: "10001", SenderName: "alice", Content: "hello\nworld &amp; &#91;图片&#93;", Time: localTime(0)},
+				{ChatID: "10002", ChatType: "private", SenderName: "bob", Content: "hi", Time: localTime(5)},
+			},
+			stats: ImportStats{Parsed: 2, Imported: 2, Skipped: 1},
+		},
+		{
+			name: "gocq-log",
+			opts: ImportOptions{Format: ImportGoCQLog},
+			input: "[2024-05-01 12:00:00] [INFO]: 收到群 测试群(20001) 内 alice(10001) 的消息: hello (-123)\n" +
+				"[2024-05-01 12:00:01] [DEBUG]: 心跳\n" +
+				"[2024-05-01 12:00:02] [INFO]: 收到好友 bob(10002) 的消息: hi (456)\n",
+			want: []adapter.Message{
+				{ChatID: "20001", ChatType: "group", SenderID: "10001", SenderName: "alice", Content: "hello", Time: localTime(0)},
+				{ChatID: "10002", ChatType: "private", SenderID: "10002", SenderName: "bob", Content: "hi", Time: localTime(2)},
+			},
+			stats: ImportStats{Parsed: 2, Imported: 2},
+		},
+		{
+			name: "napcat-log",
+			opts: ImportOptions{Format: ImportNapCatLog},
+			input: "2024-05-01 12:00:00 [info] bot | 接收 <- 群聊 [测试群(20001)] [alice(10001)] hello\n" +
+				"2024-05-01 12:00:01 [info] bot | 发送 -> 群聊 [测试群(20001)] reply\n" +
+				"[2024-05-01 12:00:02] [info] bot | 接收 <- 私聊 [bob(10002)] hi\n",
+			want: []adapter.Message{
+				{ChatID: "20001", ChatType: "group", SenderID: "10001", SenderName: "alice", Content: "hello", Time: localTime(0)},
+				{ChatID: "10002", ChatType: "private", SenderID: "10002", SenderName: "bob", Content: "hi", Time: localTime(2)},
+			},
+			stats: ImportStats{Parsed: 2, Imported: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStore(filepath.Join(t.TempDir(), "onebot.db"))
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			defer s.Close()
+
+			stats, err := s.Import(strings.NewReader(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if stats != tt.stats {
+				t.Errorf("Import stats = %+v, want %+v", stats, tt.stats)
+			}
+			got := importedMessages(t, s, tt.want)
+			if len(got) != len(tt.want) {
+				t.Fatalf("imported %q, want %q", contents(got), contents(tt.want))
+			}
+			for i, want := range tt.want {
+				msg := got[i]
+				msg.ID = 0
+				if !msg.Time.Equal(want.Time) {
+					t.Errorf("message %d time = %v, want %v", i, msg.Time, want.Time)
+				}
+				msg.Time = want.Time
+				if msg != want {
+					t.Errorf("message %d = %+v, want %+v", i, msg, want)
+				}
+			}
+		})
+	}
+}
+
+// importedMessages 按 want 中聊天出现的顺序读出各聊天的消息
+func importedMessages(t *testing.T, s *Store, want []adapter.Message) []adapter.Message {
+	t.Helper()
+	var got []adapter.Message
+	done := make(map[string]bool)
+	for _, msg := range want {
+		if done[msg.ChatID] {
+			continue
+		}
+		done[msg.ChatID] = true
+		messages, err := s.GetMessages(msg.ChatID, 10)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		got = append(got, messages...)
+	}
+	return got
+}
+
+func TestImportDedup(t *testing.T) {
+	const line = "[2024-05-01 12:00:00] [INFO]: 收到群 测试群(20001) 内 alice(10001) 的消息: hello (-123)\n"
+	const other = "[2024-05-01 12:00:01] [INFO]: 收到群 测试群(20001) 内 alice(10001) 的消息: again (-124)\n"
+	tests := []struct {
+		name   string
+		dryRun bool
+		input  string
+		want   ImportStats
+	}{
+		// 文件内重复的消息只导入一次，DryRun 时数据库中没有它们也要能发现
+		{"dry run", true, line + other + line, ImportStats{Parsed: 3, Imported: 2, Duplicates: 1}},
+		{"in file", false, line + other + line, ImportStats{Parsed: 3, Imported: 2, Duplicates: 1}},
+		// 上一次导入的消息已经在数据库中
+		{"in database", false, other + line, ImportStats{Parsed: 2, Duplicates: 2}},
+	}
+	s, err := NewStore(filepath.Join(t.TempDir(), "onebot.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	for _, tt := range tests {
+		stats, err := s.Import(strings.NewReader(tt.input), ImportOptions{Format: ImportGoCQLog, DryRun: tt.dryRun})
+		if err != nil {
+			t.Fatalf("%s: Import: %v", tt.name, err)
+		}
+		if stats != tt.want {
+			t.Errorf("%s: Import stats = %+v, want %+v", tt.name, stats, tt.want)
+		}
+	}
+	got, err := s.GetMessages("20001", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("messages after imports = %q, want 2", contents(got))
+	}
+}
+
+func TestDetectImportFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want ImportFormat
+	}{
+		{"export.jsonl", "", ImportJSONL},
+		{"chat.MHT", "", ImportQQMHT},
+		{"chat.txt", `  {"chat_id":"1"}`, ImportJSONL},
+		{"chat", "From: <saved by QQ>\r\nMIME-Version: 1.0", ImportQQMHT},
+		{"chat.txt", "消息记录（此消息记录为文本格式，不支持重新导入）", ImportQQText},
+		{"go-cqhttp.log", "[2024-05-01 12:00:00] [INFO]: 收到群 测试群(20001) 内 alice(10001) 的消息: hello (-123)", ImportGoCQLog},
+		{"napcat.log", "05-01 12:00:00 [info] bot | 接收 <- 私聊 [bob(10002)] hi", ImportNapCatLog},
+		{"unknown.txt", "2024-05-01 12:00:00 alice(10001)", ImportQQText},
+	}
+	for _, tt := range tests {
+		if got := DetectImportFormat(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("DetectImportFormat(%q, %q) = %q, want %q", tt.name, tt.head, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrInUse 表示数据库已经被另一个进程（通常是守护进程）锁定
+var ErrInUse = errors.New("database is in use by another process")
+
+// DBLock 是数据库旁边 .lock 文件上的排他锁。进程退出时操作系统会自动释放它，
+// 所以异常退出也不会留下失效的锁。
+type DBLock struct {
+	f *os.File
+}
+
+// LockDatabase 锁定 dbPath 处的数据库，已被其他进程锁定时返回 ErrInUse。
+// 守护进程运行期间一直持有这个锁，恢复备份、更换密钥等离线操作需要先拿到它。
+func LockDatabase(dbPath string) (*DBLock, error) {
+	f, err := os.OpenFile(dbPath+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &DBLock{f: f}, nil
+}
+
+// Close 释放锁
+func (l *DBLock) Close() error {
+	return l.f.Close()
+}
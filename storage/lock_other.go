@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package storage
+
+import "os"
+
+// 没有文件锁的平台上不做检查
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// MemoryStore 是只保存在内存中的 Backend，用于测试和不留痕迹的"无痕"会话
+type MemoryStore struct {
+	mu       sync.RWMutex
+	nextID   int64
+	messages map[string][]adapter.Message // chatID -> 按写入顺序排列的消息
+	notices  map[string][]adapter.Notice
+	readIDs  map[string]int64
+	drafts   map[string]string
+	history  map[string][]string
+}
+
+// NewMemoryStore 创建一个空的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[string][]adapter.Message),
+		notices:  make(map[string][]adapter.Notice),
+		readIDs:  make(map[string]int64),
+		drafts:   make(map[string]string),
+		history:  make(map[string][]string),
+	}
+}
+
+var _ Backend = (*MemoryStore)(nil)
+
+func (m *MemoryStore) Close() {}
+
+func (m *MemoryStore) AddMessage(msg *adapter.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	msg.ID = m.nextID
+	m.messages[msg.ChatID] = append(m.messages[msg.ChatID], *msg)
+	return nil
+}
+
+// sorted 返回按时间排序的消息副本，调用方需持有读锁
+func (m *MemoryStore) sorted(chatID string) []adapter.Message {
+	list := append([]adapter.Message(nil), m.messages[chatID]...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+func (m *MemoryStore) GetMessages(chatID string, limit int) ([]adapter.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := m.sorted(chatID)
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) FindMessage(chatID, messageID string) (*adapter.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if messageID == "" {
+		return nil, nil
+	}
+	list := m.messages[chatID]
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].MessageID == messageID {
+			msg := list[i]
+			return &msg, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) ForEachMessage(chatID string, since, until time.Time, fn func(adapter.Message) error) error {
+	m.mu.RLock()
+	list := m.sorted(chatID)
+	m.mu.RUnlock()
+	for _, msg := range list {
+		if !inRange(msg.Time, since, until) {
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SearchMessages(q SearchQuery) ([]adapter.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	needle := strings.ToLower(q.Text)
+	var result []adapter.Message
+	for chatID, list := range m.messages {
+		if q.ChatID != "" && chatID != q.ChatID {
+			continue
+		}
+		for _, msg := range list {
+			if q.SenderID != "" && msg.SenderID != q.SenderID {
+				continue
+			}
+			if !inRange(msg.Time, q.Since, q.Until) || !strings.Contains(strings.ToLower(msg.Content), needle) {
+				continue
+			}
+			result = append(result, msg)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Time.After(result[j].Time) })
+	if len(result) > q.limit() {
+		result = result[:q.limit()]
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) ChatSummaries() ([]ChatSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	summaries := make([]ChatSummary, 0, len(m.messages))
+	for chatID, list := range m.messages {
+		if len(list) == 0 {
+			continue
+		}
+		latest := list[0]
+		for _, msg := range list[1:] {
+			if !msg.Time.Before(latest.Time) {
+				latest = msg
+			}
+		}
+		summaries = append(summaries, ChatSummary{
+			ChatID:   chatID,
+			ChatType: latest.ChatType,
+			Messages: int64(len(list)),
+			Latest:   latest,
+		})
+	}
+	sortSummaries(summaries)
+	return summaries, nil
+}
+
+func (m *MemoryStore) SenderNames(chatID string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make(map[string]string)
+	for _, msg := range m.messages[chatID] {
+		names[msg.SenderID] = msg.SenderName
+	}
+	return names, nil
+}
+
+func (m *MemoryStore) MarkRead(chatID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var maxID int64
+	for _, msg := range m.messages[chatID] {
+		maxID = max(maxID, msg.ID)
+	}
+	m.readIDs[chatID] = maxID
+	return nil
+}
+
+func (m *MemoryStore) LastReadID(chatID string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.readIDs[chatID], nil
+}
+
+func (m *MemoryStore) GetUnread() ([]UnreadInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var infos []UnreadInfo
+	for chatID, list := range m.messages {
+		info := UnreadInfo{ChatID: chatID}
+		for _, msg := range list {
+			if msg.ID <= m.readIDs[chatID] {
+				continue
+			}
+			if info.Unread == 0 {
+				info.FirstUnread = msg.ID
+				info.ChatType = msg.ChatType
+			}
+			info.Unread++
+			if msg.Mentioned {
+				info.Mentions++
+			}
+		}
+		if info.Unread > 0 {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Unread > infos[j].Unread })
+	return infos, nil
+}
+
+func (m *MemoryStore) SaveDraft(chatID, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if text == "" {
+		delete(m.drafts, chatID)
+	} else {
+		m.drafts[chatID] = text
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetDraft(chatID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.drafts[chatID], nil
+}
+
+func (m *MemoryStore) AddInputHistory(chatID, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := append(m.history[chatID], text)
+	if len(list) > inputHistoryLimit {
+		list = list[len(list)-inputHistoryLimit:]
+	}
+	m.history[chatID] = list
+	return nil
+}
+
+func (m *MemoryStore) GetInputHistory(chatID string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := m.history[chatID]
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return append([]string(nil), list...), nil
+}
+
+func (m *MemoryStore) AddNotice(n *adapter.Notice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	n.ID = m.nextID
+	m.notices[n.ChatID] = append(m.notices[n.ChatID], *n)
+	return nil
+}
+
+func (m *MemoryStore) GetNotices(chatID string, limit int) ([]adapter.Notice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := m.notices[chatID]
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return append([]adapter.Notice(nil), list...), nil
+}
+
+// inRange 判断 t 是否落在 [since, until) 内，零值表示不限制
+func inRange(t, since, until time.Time) bool {
+	return (since.IsZero() || !t.Before(since)) && (until.IsZero() || t.Before(until))
+}
@@ -0,0 +1,45 @@
+package storage
+
+import "github.com/ziyi233/onebot-tui/adapter"
+
+// AddNotice 保存一条通知事件并回填它的 ID，加密时 text 也被加密
+func (s *Store) AddNotice(n *adapter.Notice) error {
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`INSERT INTO notices(chat_id, chat_type, type, sub_type, user_id, operator_id, text, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.ChatID, n.ChatType, n.Type, n.SubType, n.UserID, n.OperatorID, s.sealText(n.Text), dbTime(n.Time))
+	if err != nil {
+		return err
+	}
+	n.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetNotices 按时间顺序返回聊天最近的 limit 条通知
+func (s *Store) GetNotices(chatID string, limit int) ([]adapter.Notice, error) {
+	rows, err := s.db.Query(`
+	SELECT id, chat_id, chat_type, type, COALESCE(sub_type, ''), COALESCE(user_id, ''), COALESCE(operator_id, ''), COALESCE(text, ''), timestamp
+	FROM notices WHERE chat_id = ? ORDER BY id DESC LIMIT ?`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notices []adapter.Notice
+	for rows.Next() {
+		var n adapter.Notice
+		if err := rows.Scan(&n.ID, &n.ChatID, &n.ChatType, &n.Type, &n.SubType, &n.UserID, &n.OperatorID, &n.Text, &n.Time); err != nil {
+			return nil, err
+		}
+		if n.Text, err = s.openText(n.Text); err != nil {
+			return nil, err
+		}
+		n.Time = n.Time.Local()
+		notices = append(notices, n)
+	}
+	for i, j := 0, len(notices)-1; i < j; i, j = i+1, j-1 {
+		notices[i], notices[j] = notices[j], notices[i]
+	}
+	return notices, rows.Err()
+}
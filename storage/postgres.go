@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	// pgx 的 database/sql 驱动，注册为 "pgx"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// PostgresStore 是基于 PostgreSQL 的 Backend，适合多人共享的归档服务器
+type PostgresStore struct {
+	db *sql.DB
+}
+
+var _ Backend = (*PostgresStore)(nil)
+
+// NewPostgresStore 连接到 dsn 指定的数据库并创建所需的表
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS messages (
+			id BIGSERIAL PRIMARY KEY,
+			chat_id TEXT NOT NULL,
+			chat_type TEXT NOT NULL,
+			sender_id TEXT NOT NULL DEFAULT '',
+			sender_name TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL DEFAULT '',
+			timestamp TIMESTAMPTZ NOT NULL,
+			mentioned BOOLEAN NOT NULL DEFAULT FALSE,
+			dedup_key TEXT
+		)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS message_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_chat_time ON messages(chat_id, timestamp, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_dedup_key ON messages(dedup_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_message_id ON messages(chat_id, message_id)`,
+		`CREATE TABLE IF NOT EXISTS read_markers (
+			chat_id TEXT PRIMARY KEY,
+			last_read_id BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS notices (
+			id BIGSERIAL PRIMARY KEY,
+			chat_id TEXT NOT NULL,
+			chat_type TEXT NOT NULL,
+			type TEXT NOT NULL,
+			sub_type TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL DEFAULT '',
+			operator_id TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL DEFAULT '',
+			timestamp TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notices_chat_id ON notices(chat_id, id)`,
+		`CREATE TABLE IF NOT EXISTS drafts (
+			chat_id TEXT PRIMARY KEY,
+			text TEXT NOT NULL,
+			updated TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE TABLE IF NOT EXISTS input_history (
+			id BIGSERIAL PRIMARY KEY,
+			chat_id TEXT NOT NULL,
+			text TEXT NOT NULL,
+			timestamp TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_input_history_chat_id ON input_history(chat_id, id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	log.Println("PostgreSQL storage initialized successfully.")
+	return &PostgresStore{db: db}, nil
+}
+
+func (p *PostgresStore) Close() {
+	p.db.Close()
+}
+
+const pgMessageColumns = `id, chat_id, chat_type, sender_id, sender_name, content, timestamp, mentioned, message_id`
+
+func scanPGMessages(rows *sql.Rows, fn func(adapter.Message) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		var msg adapter.Message
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.ChatType, &msg.SenderID, &msg.SenderName, &msg.Content, &msg.Time, &msg.Mentioned, &msg.MessageID); err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const pgInsertMessageSQL = `INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp, mentioned, message_id, dedup_key)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+
+func (p *PostgresStore) AddMessage(msg *adapter.Message) error {
+	return p.db.QueryRow(pgInsertMessageSQL,
+		msg.ChatID, msg.ChatType, msg.SenderID, msg.SenderName, msg.Content, msg.Time, msg.Mentioned, msg.MessageID, dedupKey(msg)).Scan(&msg.ID)
+}
+
+// AddMessages 在一个事务中保存一批消息
+func (p *PostgresStore) AddMessages(msgs []*adapter.Message) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pgInsertMessageSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, msg := range msgs {
+		err := stmt.QueryRow(msg.ChatID, msg.ChatType, msg.SenderID, msg.SenderName, msg.Content, msg.Time, msg.Mentioned, msg.MessageID, dedupKey(msg)).Scan(&msg.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		for _, msg := range msgs {
+			msg.ID = 0
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *PostgresStore) GetMessages(chatID string, limit int) ([]adapter.Message, error) {
+	rows, err := p.db.Query(`SELECT * FROM (
+		SELECT `+pgMessageColumns+` FROM messages WHERE chat_id = $1 ORDER BY timestamp DESC, id DESC LIMIT $2
+	) recent ORDER BY timestamp, id`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	var messages []adapter.Message
+	err = scanPGMessages(rows, func(msg adapter.Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
+
+func (p *PostgresStore) FindMessage(chatID, messageID string) (*adapter.Message, error) {
+	if messageID == "" {
+		return nil, nil
+	}
+	rows, err := p.db.Query(`SELECT `+pgMessageColumns+` FROM messages
+		WHERE chat_id = $1 AND message_id = $2 ORDER BY id DESC LIMIT 1`, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	var found *adapter.Message
+	err = scanPGMessages(rows, func(msg adapter.Message) error {
+		found = &msg
+		return nil
+	})
+	return found, err
+}
+
+func (p *PostgresStore) ForEachMessage(chatID string, since, until time.Time, fn func(adapter.Message) error) error {
+	rows, err := p.db.Query(`SELECT `+pgMessageColumns+` FROM messages
+		WHERE chat_id = $1 AND ($2::timestamptz IS NULL OR timestamp >= $2) AND ($3::timestamptz IS NULL OR timestamp < $3)
+		ORDER BY timestamp, id`, chatID, nullTime(since), nullTime(until))
+	if err != nil {
+		return err
+	}
+	return scanPGMessages(rows, fn)
+}
+
+func (p *PostgresStore) SearchMessages(q SearchQuery) ([]adapter.Message, error) {
+	rows, err := p.db.Query(`SELECT `+pgMessageColumns+` FROM messages
+		WHERE ($1 = '' OR chat_id = $1) AND ($2 = '' OR sender_id = $2)
+			AND ($3::timestamptz IS NULL OR timestamp >= $3) AND ($4::timestamptz IS NULL OR timestamp < $4)
+			AND content ILIKE '%' || $5 || '%'
+		ORDER BY timestamp DESC, id DESC LIMIT $6`,
+		q.ChatID, q.SenderID, nullTime(q.Since), nullTime(q.Until), escapeLike(q.Text), q.limit())
+	if err != nil {
+		return nil, err
+	}
+	var messages []adapter.Message
+	err = scanPGMessages(rows, func(msg adapter.Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
+
+func (p *PostgresStore) ChatSummaries() ([]ChatSummary, error) {
+	rows, err := p.db.Query(`
+	SELECT DISTINCT ON (chat_id) chat_id, chat_type, COUNT(*) OVER (PARTITION BY chat_id),
+		id, sender_id, sender_name, content, timestamp, mentioned, message_id
+	FROM messages ORDER BY chat_id, timestamp DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []ChatSummary
+	for rows.Next() {
+		var c ChatSummary
+		m := &c.Latest
+		if err := rows.Scan(&c.ChatID, &c.ChatType, &c.Messages, &m.ID, &m.SenderID, &m.SenderName, &m.Content, &m.Time, &m.Mentioned, &m.MessageID); err != nil {
+			return nil, err
+		}
+		m.ChatID, m.ChatType = c.ChatID, c.ChatType
+		summaries = append(summaries, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSummaries(summaries)
+	return summaries, nil
+}
+
+func (p *PostgresStore) SenderNames(chatID string) (map[string]string, error) {
+	rows, err := p.db.Query(`SELECT DISTINCT ON (sender_id) sender_id, sender_name FROM messages WHERE chat_id = $1 ORDER BY sender_id, id DESC`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+func (p *PostgresStore) MarkRead(chatID string) error {
+	_, err := p.db.Exec(`
+	INSERT INTO read_markers(chat_id, last_read_id)
+	SELECT $1, COALESCE(MAX(id), 0) FROM messages WHERE chat_id = $1
+	ON CONFLICT (chat_id) DO UPDATE SET last_read_id = excluded.last_read_id`, chatID)
+	return err
+}
+
+func (p *PostgresStore) LastReadID(chatID string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`SELECT COALESCE(MAX(last_read_id), 0) FROM read_markers WHERE chat_id = $1`, chatID).Scan(&id)
+	return id, err
+}
+
+func (p *PostgresStore) GetUnread() ([]UnreadInfo, error) {
+	rows, err := p.db.Query(`
+	SELECT m.chat_id, MAX(m.chat_type), COUNT(*), COUNT(*) FILTER (WHERE m.mentioned), MIN(m.id)
+	FROM messages m LEFT JOIN read_markers r ON r.chat_id = m.chat_id
+	WHERE m.id > COALESCE(r.last_read_id, 0)
+	GROUP BY m.chat_id
+	ORDER BY COUNT(*) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []UnreadInfo
+	for rows.Next() {
+		var info UnreadInfo
+		if err := rows.Scan(&info.ChatID, &info.ChatType, &info.Unread, &info.Mentions, &info.FirstUnread); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+func (p *PostgresStore) SaveDraft(chatID, text string) error {
+	if text == "" {
+		_, err := p.db.Exec(`DELETE FROM drafts WHERE chat_id = $1`, chatID)
+		return err
+	}
+	_, err := p.db.Exec(`INSERT INTO drafts(chat_id, text) VALUES ($1, $2)
+		ON CONFLICT (chat_id) DO UPDATE SET text = excluded.text, updated = now()`, chatID, text)
+	return err
+}
+
+func (p *PostgresStore) GetDraft(chatID string) (string, error) {
+	var text string
+	err := p.db.QueryRow(`SELECT text FROM drafts WHERE chat_id = $1`, chatID).Scan(&text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return text, err
+}
+
+func (p *PostgresStore) AddInputHistory(chatID, text string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO input_history(chat_id, text) VALUES ($1, $2)`, chatID, text); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM input_history WHERE chat_id = $1 AND id NOT IN (
+		SELECT id FROM input_history WHERE chat_id = $1 ORDER BY id DESC LIMIT $2)`, chatID, inputHistoryLimit)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresStore) GetInputHistory(chatID string, limit int) ([]string, error) {
+	rows, err := p.db.Query(`SELECT text FROM (
+		SELECT id, text FROM input_history WHERE chat_id = $1 ORDER BY id DESC LIMIT $2
+	) recent ORDER BY id`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []string
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		history = append(history, text)
+	}
+	return history, rows.Err()
+}
+
+func (p *PostgresStore) AddNotice(n *adapter.Notice) error {
+	return p.db.QueryRow(`INSERT INTO notices(chat_id, chat_type, type, sub_type, user_id, operator_id, text, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		n.ChatID, n.ChatType, n.Type, n.SubType, n.UserID, n.OperatorID, n.Text, n.Time).Scan(&n.ID)
+}
+
+func (p *PostgresStore) GetNotices(chatID string, limit int) ([]adapter.Notice, error) {
+	rows, err := p.db.Query(`SELECT * FROM (
+		SELECT id, chat_id, chat_type, type, sub_type, user_id, operator_id, text, timestamp
+		FROM notices WHERE chat_id = $1 ORDER BY id DESC LIMIT $2
+	) recent ORDER BY id`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var notices []adapter.Notice
+	for rows.Next() {
+		var n adapter.Notice
+		if err := rows.Scan(&n.ID, &n.ChatID, &n.ChatType, &n.Type, &n.SubType, &n.UserID, &n.OperatorID, &n.Text, &n.Time); err != nil {
+			return nil, err
+		}
+		notices = append(notices, n)
+	}
+	return notices, rows.Err()
+}
+
+// nullTime 把零值时间转换为 SQL NULL，便于在查询中表示"不限制"
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// postgresDSNEnv 指定测试用的 PostgreSQL 数据库，未设置时跳过 PostgreSQL 的测试
+const postgresDSNEnv = "ONEBOT_TUI_TEST_POSTGRES_DSN"
+
+// openTestPostgres 连接测试数据库。数据库可能是共享的，测试应使用 testChatID 生成的聊天 ID。
+func openTestPostgres(t *testing.T) *PostgresStore {
+	t.Helper()
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+	p, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatalf("NewPostgresStore: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// testChatID 返回本次运行独有的聊天 ID，避免和共享数据库中已有的数据混在一起
+func testChatID(t *testing.T, name string) string {
+	return fmt.Sprintf("test-%d-%s-%s", time.Now().UnixNano(), t.Name(), name)
+}
+
+func TestPostgresChatSummariesMessageID(t *testing.T) {
+	p := openTestPostgres(t)
+	chatID := testChatID(t, "group")
+	now := time.Now().Truncate(time.Second)
+	for i, id := range []string{"1001", "1002"} {
+		msg := &adapter.Message{ChatID: chatID, ChatType: "group", SenderID: "10", SenderName: "alice",
+			Content: "hello", Time: now.Add(time.Duration(i) * time.Second), MessageID: id}
+		if err := p.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+	}
+
+	summaries, err := p.ChatSummaries()
+	if err != nil {
+		t.Fatalf("ChatSummaries: %v", err)
+	}
+	for _, c := range summaries {
+		if c.ChatID != chatID {
+			continue
+		}
+		if c.Messages != 2 || c.Latest.MessageID != "1002" {
+			t.Errorf("summary = %d messages, latest message_id %q; want 2, %q", c.Messages, c.Latest.MessageID, "1002")
+		}
+		return
+	}
+	t.Errorf("no summary for %s", chatID)
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// RetentionPolicy 描述消息保留多久，各项为 0 表示不限制
+type RetentionPolicy struct {
+	KeepDays                   int // 只保留最近 N 天的消息
+	KeepMessages               int // 每个聊天只保留最近 N 条消息
+	MediaMetadataOnlyAfterDays int // 超过 N 天的媒体片段去掉下载地址，只保留元数据
+}
+
+func (p RetentionPolicy) isZero() bool {
+	return p.KeepDays <= 0 && p.KeepMessages <= 0 && p.MediaMetadataOnlyAfterDays <= 0
+}
+
+// PruneResult 汇总一次清理的结果
+type PruneResult struct {
+	Deleted  int64 // 删除的消息数
+	Stripped int64 // 去掉媒体地址的消息数
+}
+
+// mediaSegmentTypes 是带有远程下载地址的 CQ 码类型
+var mediaSegmentTypes = map[string]bool{"image": true, "record": true, "video": true, "file": true}
+
+// Prune 按保留策略删除过期消息。perChat 中的策略完整替代对应聊天的全局策略。
+func (s *Store) Prune(global RetentionPolicy, perChat map[string]RetentionPolicy, now time.Time) (PruneResult, error) {
+	var result PruneResult
+
+	chatIDs, err := s.chatIDs()
+	if err != nil {
+		return result, err
+	}
+	for _, chatID := range chatIDs {
+		policy, ok := perChat[chatID]
+		if !ok {
+			policy = global
+		}
+		if policy.isZero() {
+			continue
+		}
+		if err := s.pruneChat(chatID, policy, now, &result); err != nil {
+			return result, err
+		}
+	}
+
+	// 删除已经没有对应消息的媒体记录，缓存文件由媒体缓存自行清理
+	if _, err := s.db.Exec(`DELETE FROM attachments WHERE message_id NOT IN (SELECT id FROM messages)`); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (s *Store) pruneChat(chatID string, policy RetentionPolicy, now time.Time, result *PruneResult) error {
+	if policy.KeepDays > 0 {
+		res, err := s.db.Exec(`DELETE FROM messages WHERE chat_id = ? AND timestamp < ?`,
+			chatID, dbTime(now.AddDate(0, 0, -policy.KeepDays)))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		result.Deleted += n
+	}
+
+	if policy.KeepMessages > 0 {
+		res, err := s.db.Exec(`
+		DELETE FROM messages WHERE chat_id = ? AND id < (
+			SELECT MIN(id) FROM (SELECT id FROM messages WHERE chat_id = ? ORDER BY id DESC LIMIT ?)
+		)`, chatID, chatID, policy.KeepMessages)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		result.Deleted += n
+	}
+
+	if policy.MediaMetadataOnlyAfterDays > 0 {
+		before := dbTime(now.AddDate(0, 0, -policy.MediaMetadataOnlyAfterDays))
+		n, err := s.stripMediaURLs(chatID, before)
+		if err != nil {
+			return err
+		}
+		result.Stripped += n
+		// 媒体记录保留文件名、大小等元数据，只断开与缓存文件的关联
+		_, err = s.db.Exec(`
+		UPDATE attachments SET hash = '' WHERE hash != '' AND message_id IN (
+			SELECT id FROM messages WHERE chat_id = ? AND timestamp < ?
+		)`, chatID, before)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripMediaURLs 去掉 before 之前的消息中媒体片段的 url 参数，保留文件名、大小等元数据
+func (s *Store) stripMediaURLs(chatID string, before time.Time) (int64, error) {
+	if err := s.checkUnlocked(); err != nil {
+		return 0, err
+	}
+	// 加密后无法在 SQL 中按内容过滤，只能逐条解密检查
+	query := `SELECT id, content FROM messages WHERE chat_id = ? AND timestamp < ?`
+	if !s.encrypted {
+		query += ` AND content LIKE '%url=%'`
+	}
+	rows, err := s.db.Query(query, chatID, before)
+	if err != nil {
+		return 0, err
+	}
+	updates := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if content, err = s.openText(content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if stripped, changed := stripMediaContent(content); changed {
+			updates[id] = stripped
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(updates) == 0 {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for id, content := range updates {
+		if _, err := tx.Exec(`UPDATE messages SET content = ? WHERE id = ?`, s.sealText(content), id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return int64(len(updates)), tx.Commit()
+}
+
+func stripMediaContent(content string) (string, bool) {
+	changed := false
+	var b strings.Builder
+	for _, seg := range adapter.ParseCQ(content) {
+		if mediaSegmentTypes[seg.Type] {
+			if _, ok := seg.Data["url"]; ok {
+				delete(seg.Data, "url")
+				changed = true
+			}
+		}
+		b.WriteString(adapter.FormatCQ(seg))
+	}
+	return b.String(), changed
+}
+
+func (s *Store) chatIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT chat_id FROM messages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Compact 把 WAL 合并回主文件并截断它；vacuum 为真时再执行 VACUUM 回收空闲页
+func (s *Store) Compact(vacuum bool) error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return err
+	}
+	if vacuum {
+		if _, err := s.db.Exec(`VACUUM`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChatSize 是单个聊天在数据库中占用的空间估计
+type ChatSize struct {
+	ChatID   string    `json:"chat_id"`
+	ChatType string    `json:"chat_type"`
+	Messages int64     `json:"messages"`
+	Bytes    int64     `json:"bytes"` // 消息内容和昵称的字节数，不含索引等开销
+	Oldest   time.Time `json:"oldest"`
+	Newest   time.Time `json:"newest"`
+}
+
+// DBStats 是数据库文件整体和按聊天的统计信息
+type DBStats struct {
+	Path      string     `json:"path"`
+	FileSize  int64      `json:"file_size"`
+	WALSize   int64      `json:"wal_size"`
+	PageSize  int64      `json:"page_size"`
+	PageCount int64      `json:"page_count"`
+	FreePages int64      `json:"free_pages"`
+	Messages  int64      `json:"messages"`
+	Chats     []ChatSize `json:"chats"`
+}
+
+// Stats 统计数据库文件大小以及每个聊天的消息数和占用空间，按占用从大到小排列
+func (s *Store) Stats() (DBStats, error) {
+	st := DBStats{Path: s.path}
+	if fi, err := os.Stat(s.path); err == nil {
+		st.FileSize = fi.Size()
+	}
+	if fi, err := os.Stat(s.path + "-wal"); err == nil {
+		st.WALSize = fi.Size()
+	}
+	for pragma, dst := range map[string]*int64{
+		"page_size":      &st.PageSize,
+		"page_count":     &st.PageCount,
+		"freelist_count": &st.FreePages,
+	} {
+		if err := s.db.QueryRow(`PRAGMA ` + pragma).Scan(dst); err != nil {
+			return st, err
+		}
+	}
+
+	rows, err := s.db.Query(`
+	SELECT chat_id, MAX(chat_type), COUNT(*),
+		COALESCE(SUM(LENGTH(CAST(content AS BLOB))), 0) + COALESCE(SUM(LENGTH(CAST(sender_name AS BLOB))), 0),
+		MIN(timestamp), MAX(timestamp)
+	FROM messages GROUP BY chat_id ORDER BY 4 DESC`)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ChatSize
+		var oldest, newest string
+		if err := rows.Scan(&c.ChatID, &c.ChatType, &c.Messages, &c.Bytes, &oldest, &newest); err != nil {
+			return st, err
+		}
+		c.Oldest = parseStoredTime(oldest)
+		c.Newest = parseStoredTime(newest)
+		st.Messages += c.Messages
+		st.Chats = append(st.Chats, c)
+	}
+	return st, rows.Err()
+}
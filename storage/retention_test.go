@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+func TestPruneMixedOffsets(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "onebot.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	now := testTime.AddDate(0, 0, 10)
+	east, west := time.FixedZone("CST", 8*3600), time.FixedZone("EST", -5*3600)
+	tests := []struct {
+		content string
+		time    time.Time
+		kept    bool
+	}{
+		// 本地时间在界限之后，实际时刻在界限之前
+		{"old [CQ:image,file=a.png,url=http://example.com/a.png]", now.AddDate(0, 0, -7).Add(-time.Hour).In(east), false},
+		// 本地时间在界限之前，实际时刻在界限之后
+		{"new [CQ:image,file=b.png,url=http://example.com/b.png]", now.AddDate(0, 0, -7).Add(time.Hour).In(west), true},
+		{"today", now.In(east), true},
+	}
+	for _, tt := range tests {
+		msg := adapter.Message{ChatID: "1", ChatType: "group", SenderID: "1", Content: tt.content, Time: tt.time}
+		if err := s.AddMessage(&msg); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+	}
+
+	res, err := s.Prune(RetentionPolicy{KeepDays: 7, MediaMetadataOnlyAfterDays: 5}, nil, now.In(west))
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if res.Deleted != 1 || res.Stripped != 1 {
+		t.Errorf("Prune = %+v, want 1 deleted and 1 stripped", res)
+	}
+	got, err := s.GetMessages("1", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if want := []string{"new [CQ:image,file=b.png]", "today"}; !slices.Equal(contents(got), want) {
+		t.Errorf("messages after Prune = %q, want %q", contents(got), want)
+	}
+}
+
+func TestMigrateNormalizesTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "onebot.db")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	// 模拟旧版本按写入时的时区保存的时间文本
+	for _, stmt := range []string{
+		`INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp) VALUES ('1', 'group', '1', 'alice', 'second', '2024-05-01 20:30:00 +0800 CST')`,
+		`INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp) VALUES ('1', 'group', '1', 'alice', 'first', '2024-05-01 12:00:00 +0000 UTC')`,
+		`INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp) VALUES ('1', 'group', '1', 'alice', 'third', '2024-05-01 08:00:00.5 -0500 EST m=+1.000000001')`,
+		`PRAGMA user_version = 3`,
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	s.Close()
+
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("reopening NewStore: %v", err)
+	}
+	defer s.Close()
+	var texts []string
+	rows, err := s.db.Query(`SELECT CAST(timestamp AS TEXT) FROM messages ORDER BY timestamp`)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		texts = append(texts, text)
+	}
+	want := []string{"2024-05-01 12:00:00 +0000 UTC", "2024-05-01 12:30:00 +0000 UTC", "2024-05-01 13:00:00.5 +0000 UTC"}
+	if !slices.Equal(texts, want) {
+		t.Errorf("stored times = %q, want %q", texts, want)
+	}
+
+	var ranged []adapter.Message
+	err = s.ForEachMessage("1", testTime.Add(time.Minute), time.Time{}, func(msg adapter.Message) error {
+		ranged = append(ranged, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachMessage: %v", err)
+	}
+	if want := []string{"second", "third"}; !slices.Equal(contents(ranged), want) {
+		t.Errorf("ForEachMessage after migration = %q, want %q", contents(ranged), want)
+	}
+}
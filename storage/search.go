@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// SearchMessages 搜索消息内容。数据库加密时无法在 SQL 中匹配内容，改为逐条解密后过滤。
+func (s *Store) SearchMessages(q SearchQuery) ([]adapter.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE 1 = 1`
+	var args []interface{}
+	if q.ChatID != "" {
+		query += ` AND chat_id = ?`
+		args = append(args, q.ChatID)
+	}
+	if q.SenderID != "" {
+		query += ` AND sender_id = ?`
+		args = append(args, q.SenderID)
+	}
+	if !q.Since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, dbTime(q.Since))
+	}
+	if !q.Until.IsZero() {
+		query += ` AND timestamp < ?`
+		args = append(args, dbTime(q.Until))
+	}
+	if q.Text != "" && !s.encrypted {
+		query += ` AND content LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(q.Text)+"%")
+	}
+	query += ` ORDER BY timestamp DESC, id DESC`
+	if q.Text == "" || !s.encrypted {
+		query += ` LIMIT ?`
+		args = append(args, q.limit())
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	needle := strings.ToLower(q.Text)
+	var messages []adapter.Message
+	for rows.Next() && len(messages) < q.limit() {
+		var msg adapter.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		if err := s.openMessage(&msg); err != nil {
+			return nil, err
+		}
+		if s.encrypted && !strings.Contains(strings.ToLower(msg.Content), needle) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ChatSummaries 返回每个聊天的消息数和按时间最新的一条消息
+func (s *Store) ChatSummaries() ([]ChatSummary, error) {
+	rows, err := s.db.Query(`
+	SELECT chat_id, chat_type, n, id, sender_id, sender_name, content, timestamp, mentioned, message_id FROM (
+		SELECT *, COUNT(*) OVER (PARTITION BY chat_id) AS n,
+			ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY timestamp DESC, id DESC) AS rn
+		FROM messages
+	) WHERE rn = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []ChatSummary
+	for rows.Next() {
+		var c ChatSummary
+		var ts string
+		m := &c.Latest
+		if err := rows.Scan(&c.ChatID, &c.ChatType, &c.Messages, &m.ID, &m.SenderID, &m.SenderName, &m.Content, &ts, &m.Mentioned, &m.MessageID); err != nil {
+			return nil, err
+		}
+		if err := s.openMessage(m); err != nil {
+			return nil, err
+		}
+		m.ChatID, m.ChatType, m.Time = c.ChatID, c.ChatType, parseStoredTime(ts)
+		summaries = append(summaries, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSummaries(summaries)
+	return summaries, nil
+}
+
+// sortSummaries 按最后一条消息的时间倒序排列
+func sortSummaries(summaries []ChatSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Latest.Time.After(summaries[j].Latest.Time)
+	})
+}
+
+// Preview 返回最后一条消息的单行预览，群聊中带上发送者，如 "张三: [图片] 好看"
+func (c ChatSummary) Preview() string {
+	text := strings.Join(strings.Fields(renderPlain(c.Latest.Content, nil)), " ")
+	if c.ChatType == "group" && c.Latest.SenderName != "" {
+		text = c.Latest.SenderName + ": " + text
+	}
+	return text
+}
@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	// 方案二：引入纯 Go 的驱动，它会将自己注册为 "sqlite"
 	_ "modernc.org/sqlite"
@@ -12,7 +17,22 @@ import (
 
 // Store 结构体保持不变
 type Store struct {
-	db *sql.DB
+	db        *sql.DB
+	path      string
+	encrypted bool       // 数据库是否启用了逐行加密
+	cipher    *rowCipher // 解锁后才不为 nil
+	insert    *sql.Stmt  // 预编译的消息插入语句
+}
+
+const insertMessageSQL = `INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp, mentioned, message_id, dedup_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// messageColumns 是读取消息时选择的列，顺序与 scanMessage 一致
+const messageColumns = `id, chat_id, chat_type, sender_id, sender_name, content, timestamp, mentioned, message_id`
+
+func scanMessage(rows *sql.Rows, msg *adapter.Message) error {
+	err := rows.Scan(&msg.ID, &msg.ChatID, &msg.ChatType, &msg.SenderID, &msg.SenderName, &msg.Content, &msg.Time, &msg.Mentioned, &msg.MessageID)
+	msg.Time = msg.Time.Local()
+	return err
 }
 
 // NewStore 创建并初始化一个新的 Store
@@ -40,31 +60,343 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, err
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
+	insert, err := db.Prepare(insertMessageSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db, path: dbPath, insert: insert}
+	if s.encrypted, err = s.Encrypted(); err != nil {
+		return nil, err
+	}
+
 	log.Println("Database initialized successfully with pure Go 'sqlite' driver.")
-	return &Store{db: db}, nil
+	return s, nil
+}
+
+// migrate 为旧版本创建的数据库补齐新增的列、表和索引
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "messages", "mentioned", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "messages", "dedup_key", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "messages", "message_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 首次引入已读标记时，把已有的历史消息都视为已读
+	var hasMarkers int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'read_markers'`).Scan(&hasMarkers); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		`CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_dedup_key ON messages(dedup_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_message_id ON messages(chat_id, message_id)`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"message_id" INTEGER NOT NULL,
+			"type" TEXT NOT NULL,
+			"url" TEXT,
+			"url_key" TEXT,
+			"file_name" TEXT,
+			"hash" TEXT,
+			"size" INTEGER NOT NULL DEFAULT 0,
+			"mime" TEXT,
+			"created" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_hash ON attachments(hash)`,
+		`CREATE TABLE IF NOT EXISTS notices (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"chat_id" TEXT NOT NULL,
+			"chat_type" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"sub_type" TEXT,
+			"user_id" TEXT,
+			"operator_id" TEXT,
+			"text" TEXT,
+			"timestamp" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notices_chat_id ON notices(chat_id, id)`,
+		`CREATE TABLE IF NOT EXISTS meta (
+			"key" TEXT NOT NULL PRIMARY KEY,
+			"value" TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS read_markers (
+			"chat_id" TEXT NOT NULL PRIMARY KEY,
+			"last_read_id" INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS drafts (
+			"chat_id" TEXT NOT NULL PRIMARY KEY,
+			"text" TEXT NOT NULL,
+			"updated" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS input_history (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"chat_id" TEXT NOT NULL,
+			"text" TEXT NOT NULL,
+			"timestamp" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_input_history_chat_id ON input_history(chat_id, id)`,
+	}
+	if hasMarkers == 0 {
+		stmts = append(stmts, `INSERT OR IGNORE INTO read_markers(chat_id, last_read_id) SELECT chat_id, MAX(id) FROM messages GROUP BY chat_id`)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := migrateURLKeys(db); err != nil {
+		return err
+	}
+	if err := backfillDedupKeys(db); err != nil {
+		return err
+	}
+	if version < 4 {
+		if err := normalizeTimes(db); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion))
+	return err
+}
+
+// backfillDedupKeys 为引入去重键之前写入的消息补上去重键
+func backfillDedupKeys(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, chat_id, sender_id, content, timestamp FROM messages WHERE dedup_key IS NULL`)
+	if err != nil {
+		return err
+	}
+	keys := make(map[int64]string)
+	for rows.Next() {
+		var msg adapter.Message
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Content, &msg.Time); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[msg.ID] = dedupKey(&msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(keys) == 0 {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := tx.Exec(`UPDATE messages SET dedup_key = ? WHERE id = ?`, key, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// migrateURLKeys 为旧的媒体记录补上 url_key 列。之前地址都没有加密，url_key 就是地址本身；
+// 已加密数据库中的这些地址在解锁时再加密，见 Store.sealPlainURLs。
+func migrateURLKeys(db *sql.DB) error {
+	if err := ensureColumn(db, "attachments", "url_key", "TEXT"); err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		`UPDATE attachments SET url_key = url WHERE url_key IS NULL`,
+		`DROP INDEX IF EXISTS idx_attachments_url`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_url_key ON attachments(url_key)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTimes 把版本 4 之前按写入时的时区保存的时间统一改写为 UTC，见 dbTime
+func normalizeTimes(db *sql.DB) error {
+	for _, col := range [][2]string{
+		{"messages", "timestamp"},
+		{"notices", "timestamp"},
+		{"input_history", "timestamp"},
+		{"drafts", "updated"},
+		{"attachments", "created"},
+	} {
+		if err := normalizeColumn(db, col[0], col[1]); err != nil {
+			return fmt.Errorf("normalize %s.%s: %w", col[0], col[1], err)
+		}
+	}
+	return nil
+}
+
+// normalizeColumn 分批改写一张表中的时间列，避免一次把整张表读进内存
+func normalizeColumn(db *sql.DB, table, column string) error {
+	type row struct {
+		id int64
+		t  time.Time
+	}
+	var lastID int64
+	for {
+		rows, err := db.Query(`SELECT rowid, "`+column+`" FROM `+table+` WHERE rowid > ? AND "`+column+`" IS NOT NULL ORDER BY rowid LIMIT 1000`, lastID)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.t); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, r := range batch {
+			if _, err := tx.Exec(`UPDATE `+table+` SET "`+column+`" = ? WHERE rowid = ?`, dbTime(r.t), r.id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		lastID = batch[len(batch)-1].id
+	}
+}
+
+// dedupKey 根据聊天、发送者、秒级时间和内容计算消息的去重键。
+// 导出的聊天记录通常只精确到秒，所以这里也截断到秒。
+func dedupKey(msg *adapter.Message) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s", msg.ChatID, msg.SenderID, msg.Time.Unix(), msg.Content)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ensureColumn 在列不存在时通过 ALTER TABLE 添加它
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN "` + column + `" ` + decl)
+	return err
+}
+
+// dbTime 把写入或用于比较的时间换成 UTC。驱动把时间保存为带时区的文本，
+// 只有时区统一时，按文本比较和排序才等于按时间先后。
+func dbTime(t time.Time) time.Time {
+	return t.UTC()
+}
+
+// parseStoredTime 解析驱动写入的时间文本（time.Time.String() 的格式，可能带有单调时钟读数），
+// 用于 MIN/MAX 等聚合结果——它们不会像普通列那样被驱动自动转换为 time.Time
+func parseStoredTime(s string) time.Time {
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999 -0700 MST", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Local()
+		}
+	}
+	return time.Time{}
+}
+
+// checkUnlocked 在加密数据库尚未解锁时拒绝写入，避免混入明文
+func (s *Store) checkUnlocked() error {
+	if s.encrypted && s.cipher == nil {
+		return ErrLocked
+	}
+	return nil
 }
 
 // Close, AddMessage, GetMessages 等其他所有函数都保持完全不变
 // 因为它们都是通过标准的 database/sql 接口操作，不关心底层具体是哪个驱动
 
 func (s *Store) Close() {
+	s.insert.Close()
 	s.db.Close()
 }
 
 func (s *Store) AddMessage(msg *adapter.Message) error {
-	insertSQL := `INSERT INTO messages(chat_id, chat_type, sender_id, sender_name, content, timestamp) VALUES (?, ?, ?, ?, ?, ?)`
-	stmt, err := s.db.Prepare(insertSQL)
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	return s.execInsert(s.insert, msg)
+}
+
+// AddMessages 在一个事务中保存一批消息并回填它们的 ID，任意一条失败时整批回滚
+func (s *Store) AddMessages(msgs []*adapter.Message) error {
+	if err := s.checkUnlocked(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(msg.ChatID, msg.ChatType, msg.SenderID, msg.SenderName, msg.Content, msg.Time)
+	stmt := tx.Stmt(s.insert)
+	for _, msg := range msgs {
+		if err := s.execInsert(stmt, msg); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		for _, msg := range msgs {
+			msg.ID = 0
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) execInsert(stmt *sql.Stmt, msg *adapter.Message) error {
+	res, err := stmt.Exec(msg.ChatID, msg.ChatType, msg.SenderID, s.sealText(msg.SenderName), s.sealText(msg.Content), dbTime(msg.Time), msg.Mentioned, msg.MessageID, dedupKeyWith(s.cipher, msg))
+	if err != nil {
+		return err
+	}
+	msg.ID, err = res.LastInsertId()
 	return err
 }
 
 func (s *Store) GetMessages(chatID string, limit int) ([]adapter.Message, error) {
-	querySQL := `SELECT chat_id, chat_type, sender_id, sender_name, content, timestamp FROM messages WHERE chat_id = ? ORDER BY timestamp DESC LIMIT ?`
+	querySQL := `SELECT ` + messageColumns + ` FROM messages WHERE chat_id = ? ORDER BY timestamp DESC, id DESC LIMIT ?`
 
 	rows, err := s.db.Query(querySQL, chatID, limit)
 	if err != nil {
@@ -75,16 +407,40 @@ func (s *Store) GetMessages(chatID string, limit int) ([]adapter.Message, error)
 	var messages []adapter.Message
 	for rows.Next() {
 		var msg adapter.Message
-		err := rows.Scan(&msg.ChatID, &msg.ChatType, &msg.SenderID, &msg.SenderName, &msg.Content, &msg.Time)
-		if err != nil {
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		if err := s.openMessage(&msg); err != nil {
 			return nil, err
 		}
 		messages = append(messages, msg)
 	}
-    
+
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
 
 	return messages, nil
-}
\ No newline at end of file
+}
+
+func (s *Store) FindMessage(chatID, messageID string) (*adapter.Message, error) {
+	if messageID == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM messages WHERE chat_id = ? AND message_id = ? ORDER BY id DESC LIMIT 1`, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var msg adapter.Message
+	if err := scanMessage(rows, &msg); err != nil {
+		return nil, err
+	}
+	if err := s.openMessage(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+// UnreadInfo 描述一个聊天的未读状态，由已读标记推导而来
+type UnreadInfo struct {
+	ChatID      string `json:"chat_id"`
+	ChatType    string `json:"chat_type"`
+	Unread      int    `json:"unread"`          // 已读标记之后的消息数
+	Mentions    int    `json:"mentions"`        // 其中 @ 了自己的消息数
+	FirstUnread int64  `json:"first_unread_id"` // 第一条未读消息的行号
+}
+
+// MarkRead 把聊天的已读标记移动到它当前最新的一条消息
+func (s *Store) MarkRead(chatID string) error {
+	_, err := s.db.Exec(`
+	INSERT INTO read_markers(chat_id, last_read_id)
+	SELECT ?, COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?
+	ON CONFLICT(chat_id) DO UPDATE SET last_read_id = excluded.last_read_id`, chatID, chatID)
+	return err
+}
+
+// LastReadID 返回聊天的已读标记，从未标记过时返回 0
+func (s *Store) LastReadID(chatID string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(last_read_id), 0) FROM read_markers WHERE chat_id = ?`, chatID).Scan(&id)
+	return id, err
+}
+
+// GetUnread 返回所有存在未读消息的聊天，按未读数从多到少排列
+func (s *Store) GetUnread() ([]UnreadInfo, error) {
+	rows, err := s.db.Query(`
+	SELECT m.chat_id, MAX(m.chat_type), COUNT(*), SUM(m.mentioned), MIN(m.id)
+	FROM messages m LEFT JOIN read_markers r ON r.chat_id = m.chat_id
+	WHERE m.id > COALESCE(r.last_read_id, 0)
+	GROUP BY m.chat_id
+	ORDER BY COUNT(*) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []UnreadInfo
+	for rows.Next() {
+		var info UnreadInfo
+		if err := rows.Scan(&info.ChatID, &info.ChatType, &info.Unread, &info.Mentions, &info.FirstUnread); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// ErrWriterClosed 表示 Writer 已经关闭，不再接受新消息
+var ErrWriterClosed = errors.New("storage writer is closed")
+
+// batchAdder 由支持在一个事务中写入多条消息的后端实现
+type batchAdder interface {
+	AddMessages(msgs []*adapter.Message) error
+}
+
+// 批量写入失败时的重试次数和初始等待时间，主要用于应对短暂的 SQLITE_BUSY
+const (
+	writerRetries    = 3
+	writerRetryDelay = 100 * time.Millisecond
+)
+
+// WriterOptions 控制 Writer 的批量写入行为，零值字段使用默认值
+type WriterOptions struct {
+	BatchSize int           // 每个事务最多写入的消息数，默认 100
+	Interval  time.Duration // 消息在队列中最长等待多久被提交，默认 200ms
+	QueueSize int           // 待写入队列的长度，队列满时 Write 会阻塞，默认 4096
+
+	// OnWritten 在消息提交成功后调用，此时 msg.ID 已经回填。它在写入 goroutine 中执行，不能阻塞。
+	OnWritten func(msg adapter.Message)
+	// OnError 在有消息最终写入失败时调用，failed 是这一批中丢失的条数。它在单独的 goroutine 中执行。
+	OnError func(err error, failed int)
+}
+
+// WriterStats 是 Writer 的运行指标
+type WriterStats struct {
+	Queued    int       `json:"queued"`  // 队列中等待写入的消息数
+	Written   int64     `json:"written"` // 已经成功写入的消息数
+	Batches   int64     `json:"batches"` // 已经提交的事务数
+	Failed    int64     `json:"failed"`  // 写入失败而丢失的消息数
+	LastError string    `json:"last_error,omitempty"`
+	ErrorTime time.Time `json:"error_time,omitzero"`
+}
+
+// Writer 包装一个 Backend，把收到的消息放入队列，由后台 goroutine 按批在事务中写入，
+// 避免逐条同步写库阻塞消息接收。读取和已读标记相关的方法会先等待队列写完，保证读到最新数据。
+type Writer struct {
+	Backend
+	opts WriterOptions
+
+	queue chan adapter.Message
+	flush chan chan struct{}
+	done  chan struct{}
+
+	mu     sync.RWMutex // 保护 closed，持有读锁时才能向 queue 发送
+	closed bool
+
+	statsMu sync.Mutex
+	stats   WriterStats
+}
+
+var _ Backend = (*Writer)(nil)
+
+// NewWriter 创建 Writer 并启动写入 goroutine
+func NewWriter(backend Backend, opts WriterOptions) *Writer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 200 * time.Millisecond
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 4096
+	}
+	w := &Writer{
+		Backend: backend,
+		opts:    opts,
+		queue:   make(chan adapter.Message, opts.QueueSize),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write 把消息放入写入队列，消息的 ID 要等提交后才会通过 OnWritten 回传
+func (w *Writer) Write(msg adapter.Message) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.queue <- msg
+	return nil
+}
+
+// Flush 等待调用前已进入队列的消息全部提交
+func (w *Writer) Flush() {
+	req := make(chan struct{})
+	select {
+	case w.flush <- req:
+		<-req
+	case <-w.done:
+	}
+}
+
+// Close 停止接受新消息并等待队列写完。它不会关闭被包装的 Backend。
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	<-w.done
+}
+
+// Stats 返回当前的写入指标
+func (w *Writer) Stats() WriterStats {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	stats := w.stats
+	stats.Queued = len(w.queue)
+	return stats
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	batch := make([]adapter.Message, 0, w.opts.BatchSize)
+	for {
+		select {
+		case msg, ok := <-w.queue:
+			if !ok {
+				w.commit(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= w.opts.BatchSize {
+				w.commit(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.commit(batch)
+			batch = batch[:0]
+		case req := <-w.flush:
+			for n := len(w.queue); n > 0; n-- {
+				batch = append(batch, <-w.queue)
+				if len(batch) >= w.opts.BatchSize {
+					w.commit(batch)
+					batch = batch[:0]
+				}
+			}
+			w.commit(batch)
+			batch = batch[:0]
+			close(req)
+		}
+	}
+}
+
+// commit 写入一批消息。整批写入多次失败后改为逐条写入，只丢弃确实写不进去的消息。
+func (w *Writer) commit(batch []adapter.Message) {
+	if len(batch) == 0 {
+		return
+	}
+	failed, err := w.insert(batch)
+
+	w.statsMu.Lock()
+	w.stats.Written += int64(len(batch) - failed)
+	w.stats.Batches++
+	w.stats.Failed += int64(failed)
+	if err != nil {
+		w.stats.LastError = err.Error()
+		w.stats.ErrorTime = time.Now()
+	}
+	w.statsMu.Unlock()
+
+	if failed > 0 {
+		log.Printf("Failed to store %d of %d messages: %v", failed, len(batch), err)
+		if w.opts.OnError != nil {
+			go w.opts.OnError(err, failed)
+		}
+	}
+	if w.opts.OnWritten != nil {
+		for _, msg := range batch {
+			if msg.ID != 0 {
+				w.opts.OnWritten(msg)
+			}
+		}
+	}
+}
+
+func (w *Writer) insert(batch []adapter.Message) (failed int, err error) {
+	if b, ok := w.Backend.(batchAdder); ok && len(batch) > 1 {
+		ptrs := make([]*adapter.Message, len(batch))
+		for i := range batch {
+			ptrs[i] = &batch[i]
+		}
+		for attempt := 0; ; attempt++ {
+			if err = b.AddMessages(ptrs); err == nil {
+				return 0, nil
+			}
+			if errors.Is(err, ErrLocked) || attempt == writerRetries {
+				break
+			}
+			time.Sleep(writerRetryDelay << attempt)
+		}
+		log.Printf("Batch insert of %d messages failed: %v, retrying one by one", len(batch), err)
+	}
+
+	err = nil
+	for i := range batch {
+		if e := w.Backend.AddMessage(&batch[i]); e != nil {
+			batch[i].ID = 0
+			failed++
+			err = e
+		}
+	}
+	return failed, err
+}
+
+func (w *Writer) GetMessages(chatID string, limit int) ([]adapter.Message, error) {
+	w.Flush()
+	return w.Backend.GetMessages(chatID, limit)
+}
+
+func (w *Writer) FindMessage(chatID, messageID string) (*adapter.Message, error) {
+	w.Flush()
+	return w.Backend.FindMessage(chatID, messageID)
+}
+
+func (w *Writer) ForEachMessage(chatID string, since, until time.Time, fn func(adapter.Message) error) error {
+	w.Flush()
+	return w.Backend.ForEachMessage(chatID, since, until, fn)
+}
+
+func (w *Writer) SearchMessages(q SearchQuery) ([]adapter.Message, error) {
+	w.Flush()
+	return w.Backend.SearchMessages(q)
+}
+
+func (w *Writer) ChatSummaries() ([]ChatSummary, error) {
+	w.Flush()
+	return w.Backend.ChatSummaries()
+}
+
+func (w *Writer) SenderNames(chatID string) (map[string]string, error) {
+	w.Flush()
+	return w.Backend.SenderNames(chatID)
+}
+
+func (w *Writer) MarkRead(chatID string) error {
+	w.Flush()
+	return w.Backend.MarkRead(chatID)
+}
+
+func (w *Writer) GetUnread() ([]UnreadInfo, error) {
+	w.Flush()
+	return w.Backend.GetUnread()
+}
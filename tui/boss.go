@@ -0,0 +1,379 @@
+package tui
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// BossOptions configures the boss key.
+type BossOptions struct {
+	Key    string // 切换伪装画面的按键，默认 "f12"
+	Screen string // "build"（默认）、"npm"、"htop" 或 "gitlog"
+}
+
+// Disguise screens shown by the boss key.
+const (
+	bossBuild  = "build"
+	bossNPM    = "npm"
+	bossHtop   = "htop"
+	bossGitLog = "gitlog"
+)
+
+// windowTitle is the window title of the TUI, set again when the disguise
+// screen is hidden.
+const windowTitle = "OneBot TUI"
+
+// bossTitles are the window titles set while each screen is shown.
+var bossTitles = map[string]string{
+	bossBuild:  "zsh — go test ./...",
+	bossNPM:    "zsh — npm install",
+	bossHtop:   "htop",
+	bossGitLog: "git log",
+}
+
+var (
+	bossDimStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+	bossPassStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("34"))
+	bossWarnStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("178"))
+	bossSHAStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("178"))
+	bossRefStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("37")).Bold(true)
+	bossMeterStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("37"))
+	bossBarLow      = lipgloss.NewStyle().Foreground(lipgloss.Color("40"))
+	bossBarHigh     = lipgloss.NewStyle().Foreground(lipgloss.Color("160"))
+	bossHeaderStyle = lipgloss.NewStyle().Background(lipgloss.Color("34")).Foreground(lipgloss.Color("16"))
+	bossKeyStyle    = lipgloss.NewStyle().Background(lipgloss.Color("37")).Foreground(lipgloss.Color("16"))
+)
+
+// boss is the disguise screen that replaces the TUI while the boss key is on.
+// Messages keep arriving in the background; only the view is swapped.
+type boss struct {
+	screen string
+	active bool
+	gen    int // 每次打开加一，让上一次的 tick 停下来
+
+	lines   []string // build/npm 画面已经输出的行
+	pending []string // 还没有输出的行
+	started time.Time
+	frame   uint64 // htop 画面只在 tick 时刷新数字
+	rng     *rand.Rand
+}
+
+// bossTickMsg advances the animated disguise screens.
+type bossTickMsg struct {
+	gen int
+}
+
+func newBoss(opts BossOptions) boss {
+	if _, ok := bossTitles[opts.Screen]; !ok {
+		opts.Screen = bossBuild
+	}
+	return boss{
+		screen: opts.Screen,
+		rng:    rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0)),
+	}
+}
+
+// toggle shows or hides the disguise screen.
+func (b *boss) toggle() tea.Cmd {
+	b.active = !b.active
+	b.gen++
+	if !b.active {
+		b.lines, b.pending = nil, nil
+		return tea.Batch(tea.SetWindowTitle(windowTitle), tea.ClearScreen)
+	}
+	b.started = time.Now().Add(-time.Duration(b.rng.IntN(40)+3) * time.Second)
+	switch b.screen {
+	case bossBuild:
+		b.lines = []string{"$ go test ./... -count=1"}
+	case bossNPM:
+		b.lines = []string{"$ npm install"}
+	}
+	// 清屏，去掉 Sixel 等直接画在终端上的图片
+	return tea.Batch(tea.SetWindowTitle(bossTitles[b.screen]), tea.ClearScreen, b.next())
+}
+
+// tick adds output to the animated screens and schedules the next tick.
+func (b *boss) tick(msg bossTickMsg) tea.Cmd {
+	if !b.active || msg.gen != b.gen {
+		return nil
+	}
+	b.frame++
+	switch b.screen {
+	case bossBuild, bossNPM:
+		for n := b.rng.IntN(3) + 1; n > 0; n-- {
+			if len(b.pending) == 0 {
+				b.pending = b.chunk()
+			}
+			b.lines = append(b.lines, b.pending[0])
+			b.pending = b.pending[1:]
+		}
+		// 只保留最后一屏多一点
+		if len(b.lines) > 500 {
+			b.lines = slices.Clone(b.lines[len(b.lines)-200:])
+		}
+	}
+	return b.next()
+}
+
+func (b *boss) next() tea.Cmd {
+	var d time.Duration
+	switch b.screen {
+	case bossBuild, bossNPM:
+		d = time.Duration(60+b.rng.IntN(400)) * time.Millisecond
+	case bossHtop:
+		d = 1500 * time.Millisecond
+	default:
+		return nil
+	}
+	gen := b.gen
+	return tea.Tick(d, func(time.Time) tea.Msg { return bossTickMsg{gen: gen} })
+}
+
+// view draws the disguise screen.
+func (b *boss) view(width, height int) string {
+	if height <= 0 {
+		return ""
+	}
+	var lines []string
+	switch b.screen {
+	case bossHtop:
+		lines = b.htop(width, height)
+	case bossGitLog:
+		lines = b.gitLog(height)
+	default:
+		lines = b.lines[max(len(b.lines)-height, 0):]
+	}
+	for i, line := range lines {
+		lines[i] = ansi.Truncate(line, width, "")
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines[:height], "\n")
+}
+
+func (b *boss) pick(list []string) string {
+	return list[b.rng.IntN(len(list))]
+}
+
+// chunk returns the next batch of fake output for the build and npm screens.
+func (b *boss) chunk() []string {
+	if b.screen == bossNPM {
+		return b.npmChunk()
+	}
+	pkg := "git.corp.example/platform/billing/" + b.pick(fakePackages)
+	if b.rng.IntN(6) == 0 {
+		return []string{"?       " + pkg + "    [no test files]"}
+	}
+	var lines []string
+	if b.rng.IntN(5) == 0 {
+		lines = append(lines, fmt.Sprintf("go: downloading %s v%d.%d.%d", b.pick(fakeModules), b.rng.IntN(3), b.rng.IntN(30), b.rng.IntN(12)))
+	}
+	var total float64
+	for n := b.rng.IntN(5) + 2; n > 0; n-- {
+		name := b.pick(fakeTests)
+		secs := float64(b.rng.IntN(900)) / 1000
+		total += secs
+		lines = append(lines, "=== RUN   "+name)
+		if b.rng.IntN(4) == 0 {
+			lines = append(lines, fmt.Sprintf("    %s_test.go:%d: %s", strings.ToLower(strings.TrimPrefix(name, "Test")), 20+b.rng.IntN(300), b.pick(fakeTestLogs)))
+		}
+		lines = append(lines, bossPassStyle.Render("--- PASS: ")+fmt.Sprintf("%s (%.2fs)", name, secs))
+	}
+	lines = append(lines, bossPassStyle.Render("ok")+fmt.Sprintf("      %s    %.3fs", pkg, total+0.01))
+	return lines
+}
+
+func (b *boss) npmChunk() []string {
+	var lines []string
+	for n := b.rng.IntN(6) + 3; n > 0; n-- {
+		pkg := b.pick(fakeNPMPackages)
+		switch b.rng.IntN(10) {
+		case 0:
+			lines = append(lines, bossWarnStyle.Render("npm warn")+fmt.Sprintf(" deprecated %s@%d.%d.%d: This package is no longer supported.", pkg, b.rng.IntN(5), b.rng.IntN(20), b.rng.IntN(10)))
+		case 1:
+			lines = append(lines, fmt.Sprintf("npm info run %s@%d.%d.%d postinstall node_modules/%s node ./install.js", pkg, b.rng.IntN(5), b.rng.IntN(20), b.rng.IntN(10), pkg))
+		default:
+			lines = append(lines, fmt.Sprintf("npm http fetch GET 200 https://registry.npmjs.org/%s %dms (cache miss)", pkg, 20+b.rng.IntN(600)))
+		}
+	}
+	if b.rng.IntN(12) == 0 {
+		added := 800 + b.rng.IntN(900)
+		lines = append(lines,
+			"",
+			fmt.Sprintf("added %d packages, and audited %d packages in %ds", added, added+1, 20+b.rng.IntN(60)),
+			"",
+			fmt.Sprintf("%d packages are looking for funding", 100+b.rng.IntN(150)),
+			"  run `npm fund` for details",
+			"",
+			"found "+bossPassStyle.Render("0")+" vulnerabilities",
+			"$ rm -rf node_modules && npm install",
+		)
+	}
+	return lines
+}
+
+// htop draws a fake htop screen with fresh numbers on every tick.
+func (b *boss) htop(width, height int) []string {
+	if height <= 1 {
+		return nil // 放不下底部的按键栏
+	}
+	rng := rand.New(rand.NewPCG(uint64(b.started.Unix()), b.frame))
+	cores := 8
+	if width < 100 {
+		cores = 4
+	}
+	colWidth := width/2 - 2
+	meter := func(label string, frac float64, text string) string {
+		inner := max(colWidth-len(label)-2, 10)
+		bars := max(int(frac*float64(inner-len(text))), 0)
+		bar := strings.Repeat("|", bars)
+		style := bossBarLow
+		if frac > 0.7 {
+			style = bossBarHigh
+		}
+		pad := strings.Repeat(" ", max(inner-bars-len(text), 0))
+		return bossMeterStyle.Render(label) + "[" + style.Render(bar) + pad + bossDimStyle.Render(text) + "]"
+	}
+	var lines []string
+	for i := 0; i < cores; i += 2 {
+		left, right := rng.Float64()*0.6, rng.Float64()*0.6
+		lines = append(lines, " "+
+			meter(fmt.Sprintf("%3d", i), left, fmt.Sprintf("%.1f%%", left*100))+"  "+
+			meter(fmt.Sprintf("%3d", i+1), right, fmt.Sprintf("%.1f%%", right*100)))
+	}
+	mem := 0.3 + rng.Float64()*0.05
+	uptime := 3*24*time.Hour + 4*time.Hour + time.Duration(b.frame)*1500*time.Millisecond
+	lines = append(lines,
+		" "+meter("Mem", mem, fmt.Sprintf("%.2fG/15.5G", mem*15.5))+"  "+
+			fmt.Sprintf("Tasks: %d, %d thr; %d running", 210+rng.IntN(10), 1000+rng.IntN(50), 1+rng.IntN(3)),
+		" "+meter("Swp", 0, "0K/2.00G")+"  "+
+			fmt.Sprintf("Load average: %.2f %.2f %.2f", 0.8+rng.Float64(), 0.9+rng.Float64()*0.5, 0.85),
+		" "+strings.Repeat(" ", max(colWidth+1, 0))+"  "+
+			fmt.Sprintf("Uptime: %d days, %s", int(uptime.Hours())/24, time.Time{}.Add(uptime%(24*time.Hour)).Format("15:04:05")),
+		"",
+	)
+	lines = append(lines, bossHeaderStyle.Render(fmt.Sprintf("%-*s", width, "    PID USER      PRI  NI  VIRT   RES   SHR S  CPU% MEM%   TIME+  Command")))
+
+	type proc struct {
+		pid       int
+		user, cmd string
+		cpu, mem  float64
+	}
+	procs := make([]proc, len(fakeProcesses))
+	for i, p := range fakeProcesses {
+		procs[i] = proc{pid: 1200 + i*137, user: p[0], cmd: p[1], cpu: rng.Float64() * 30 / float64(i+1), mem: 8 / float64(i+1)}
+	}
+	slices.SortFunc(procs, func(x, y proc) int {
+		if x.cpu > y.cpu {
+			return -1
+		}
+		return 1
+	})
+	for _, p := range procs {
+		if len(lines) >= height-1 {
+			break
+		}
+		secs := int(p.cpu * 900)
+		lines = append(lines, fmt.Sprintf("%7d %-9s  20   0 %5dM %4dM %4dM %s %5.1f %4.1f %3d:%02d.%02d %s",
+			p.pid, p.user, 200+int(p.mem*300), int(p.mem*80), int(p.mem*20), map[bool]string{true: "R", false: "S"}[p.cpu > 10],
+			p.cpu, p.mem, secs/60, secs%60, rng.IntN(100), p.cmd))
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	var keys strings.Builder
+	for _, k := range [][2]string{{"F1", "Help  "}, {"F2", "Setup "}, {"F3", "Search"}, {"F4", "Filter"}, {"F5", "Tree  "}, {"F6", "SortBy"}, {"F7", "Nice -"}, {"F8", "Nice +"}, {"F9", "Kill  "}, {"F10", "Quit  "}} {
+		keys.WriteString(k[0] + bossKeyStyle.Render(k[1]))
+	}
+	return append(lines, keys.String())
+}
+
+// gitLog draws a page of fake `git log` output in a pager.
+func (b *boss) gitLog(height int) []string {
+	if height <= 1 {
+		return nil // 放不下分页器的提示行
+	}
+	// 用打开的时间做种子，同一次打开画面不变
+	rng := rand.New(rand.NewPCG(uint64(b.started.Unix()), 1))
+	var lines []string
+	when := b.started.Add(-time.Duration(rng.IntN(90)) * time.Minute)
+	for i := 0; len(lines) < height-1; i++ {
+		sha := fmt.Sprintf("%016x%016x%08x", rng.Uint64(), rng.Uint64(), rng.Uint32())
+		head := bossSHAStyle.Render("commit " + sha)
+		if i == 0 {
+			head += bossSHAStyle.Render(" (") + bossRefStyle.Render("HEAD -> main") + bossSHAStyle.Render(", ") +
+				lipgloss.NewStyle().Foreground(lipgloss.Color("160")).Bold(true).Render("origin/main") + bossSHAStyle.Render(")")
+		}
+		author := fakeAuthors[rng.IntN(len(fakeAuthors))]
+		lines = append(lines,
+			head,
+			"Author: "+author,
+			"Date:   "+when.Format("Mon Jan 2 15:04:05 2006 -0700"),
+			"",
+			"    "+fakeCommits[rng.IntN(len(fakeCommits))],
+			"",
+		)
+		when = when.Add(-time.Duration(rng.IntN(300)+5) * time.Minute)
+	}
+	return append(lines[:height-1], ":")
+}
+
+var fakePackages = []string{
+	"cmd/api", "internal/auth", "internal/billing/invoice", "internal/billing/tax", "internal/cache",
+	"internal/queue/kafka", "internal/scheduler", "internal/store/postgres", "pkg/httpx", "pkg/metrics",
+	"pkg/retry", "pkg/ratelimit", "internal/webhook", "internal/ledger",
+}
+
+var fakeModules = []string{
+	"github.com/jackc/pgx/v5", "go.opentelemetry.io/otel", "github.com/segmentio/kafka-go",
+	"google.golang.org/grpc", "github.com/prometheus/client_golang", "golang.org/x/sync",
+}
+
+var fakeTests = []string{
+	"TestParseConfig", "TestRetryBackoff", "TestInvoiceTotals", "TestInvoiceTotals/rounding", "TestCacheEviction",
+	"TestHandlerAuth/expired_token", "TestMigrations", "TestConsumerRebalance", "TestRateLimiter/burst",
+	"TestLedgerBalance", "TestWebhookSignature", "TestSchedulerCron", "TestTaxRates/EU",
+}
+
+var fakeTestLogs = []string{
+	"using fixture testdata/invoices.json", "retrying after 50ms", "listening on 127.0.0.1:0",
+	"applied 42 migrations", "partition 3 assigned", "cache hit ratio 0.93",
+}
+
+var fakeNPMPackages = []string{
+	"react", "react-dom", "typescript", "@babel/core", "webpack", "eslint", "lodash", "@types/node",
+	"postcss", "vite", "rollup", "jest", "@testing-library/react", "core-js", "semver", "chokidar",
+}
+
+var fakeProcesses = [][2]string{
+	{"dev", "/usr/lib/jvm/java-17/bin/java -Xmx4g -jar idea.jar"}, {"dev", "node /usr/bin/webpack serve --mode development"},
+	{"dev", "/usr/bin/gopls -remote=auto"}, {"root", "/usr/bin/dockerd -H fd://"}, {"postgres", "postgres: checkpointer"},
+	{"dev", "/opt/google/chrome/chrome --type=renderer"}, {"root", "/usr/lib/systemd/systemd-journald"},
+	{"dev", "/usr/bin/zsh"}, {"redis", "redis-server 127.0.0.1:6379"}, {"root", "/usr/sbin/sshd -D"},
+	{"dev", "tmux new -s work"}, {"root", "containerd"}, {"dev", "/usr/bin/pipewire"},
+	{"postgres", "postgres: walwriter"}, {"root", "/usr/sbin/cron -f"}, {"dev", "ssh-agent"},
+	{"root", "/usr/lib/polkit-1/polkitd --no-debug"}, {"dev", "/usr/bin/python3 -m http.server"},
+	{"root", "/sbin/init splash"}, {"dev", "code --type=utility"}, {"root", "kworker/u16:2-events"},
+}
+
+var fakeAuthors = []string{
+	"Li Wei <li.wei@corp.example>", "Zhang Min <zhang.min@corp.example>", "Chen Jie <chen.jie@corp.example>",
+	"Wang Fang <wang.fang@corp.example>", "Liu Yang <liu.yang@corp.example>",
+}
+
+var fakeCommits = []string{
+	"fix(billing): round invoice totals before applying tax", "feat(api): add pagination to /v2/invoices",
+	"chore(deps): bump pgx to v5.7.4", "refactor(ledger): split balance calculation out of handler",
+	"test(queue): cover consumer rebalance during shutdown", "fix(auth): reject tokens with future iat",
+	"perf(cache): avoid allocating on hit path", "docs: update runbook for failed webhook retries",
+	"ci: cache go build directory between jobs", "fix(scheduler): handle DST transitions in cron parser",
+	"feat(webhook): sign payloads with rotating keys", "Merge pull request #1432 from feature/tax-eu",
+}
@@ -0,0 +1,520 @@
+package tui
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/media"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// command is a slash command typed in the composer, such as "/join 技术群".
+type command struct {
+	name string
+	args string // 参数的写法，用于帮助，如 "<chat>"
+	help string
+	// run executes the command with the text after its name, trimmed. An
+	// error is shown in the status line and keeps the input for editing.
+	run func(m *Model, args string) (tea.Cmd, error)
+	// complete returns replacements for the arguments typed so far, or nil
+	// if the command has no arguments to complete.
+	complete func(m *Model, args string) []string
+}
+
+// commandRegistry holds the slash commands by name. New commands only need
+// a register call in the init function below.
+type commandRegistry struct {
+	byName map[string]*command
+	names  []string // 注册顺序，用于帮助和补全
+}
+
+func (r *commandRegistry) register(c command) {
+	if r.byName == nil {
+		r.byName = make(map[string]*command)
+	}
+	if _, ok := r.byName[c.name]; ok {
+		panic("tui: command /" + c.name + " registered twice")
+	}
+	r.byName[c.name] = &c
+	r.names = append(r.names, c.name)
+}
+
+func (r *commandRegistry) lookup(name string) (*command, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// matching returns the commands whose name starts with prefix, in registration order.
+func (r *commandRegistry) matching(prefix string) []*command {
+	var matches []*command
+	for _, name := range r.names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, r.byName[name])
+		}
+	}
+	return matches
+}
+
+// commands are the slash commands the composer understands.
+var commands commandRegistry
+
+func init() {
+	commands.register(command{name: "join", args: "<chat>", help: "open a chat by name, remark, ID or pinyin",
+		run: (*Model).joinCommand, complete: (*Model).completeChat})
+	commands.register(command{name: "me", args: "<action>", help: "send an action, e.g. /me waves",
+		run: (*Model).meCommand})
+	commands.register(command{name: "image", args: "<file>", help: "send an image file",
+		run: (*Model).imageCommand, complete: func(m *Model, args string) []string { return completePath(args) }})
+	commands.register(command{name: "recall", help: "recall your last message in this chat",
+		run: (*Model).recallCommand})
+	commands.register(command{name: "search", args: "<text>", help: "search the history of this chat",
+		run: (*Model).searchCommand})
+	commands.register(command{name: "export", args: "[format] [file]", help: "export this chat (jsonl, markdown, html or text)",
+		run: (*Model).exportCommand, complete: completeExport})
+	commands.register(command{name: "mute", args: "<member> [duration]", help: "mute a group member, 10m by default, 0 to unmute",
+		run: (*Model).muteCommand, complete: (*Model).completeMember})
+	commands.register(command{name: "members", help: "list the members of this group",
+		run: (*Model).membersCommand})
+	commands.register(command{name: "theme", args: "[name]", help: "switch to a theme, or the next one",
+		run: (*Model).themeCommand, complete: (*Model).completeTheme})
+	commands.register(command{name: "split", args: "[chat]", help: "show another chat in a new pane below",
+		run: (*Model).splitCommand, complete: (*Model).completeChat})
+	commands.register(command{name: "vsplit", args: "[chat]", help: "show another chat in a new pane to the right",
+		run: (*Model).vsplitCommand, complete: (*Model).completeChat})
+	commands.register(command{name: "close", help: "close the focused pane",
+		run: (*Model).closeCommand})
+	commands.register(command{name: "help", help: "list the commands",
+		run: (*Model).helpCommand})
+}
+
+// errReported is returned by commands that already explained the problem in the status line.
+var errReported = errors.New("reported")
+
+// commandResultMsg carries the result of a command that ran in the background.
+type commandResultMsg struct {
+	command string
+	status  string // 成功时状态栏显示的内容
+	title   string // 不为空时在弹窗中显示 text
+	text    string
+	err     error
+}
+
+// parseCommand splits "/name args" into its parts. Text starting with "//" is
+// not a command; it is sent with the first slash removed.
+func parseCommand(text string) (name, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") || strings.HasPrefix(text, "//") {
+		return "", "", false
+	}
+	text = text[1:]
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		return text[:i], strings.TrimSpace(text[i:]), true
+	}
+	return text, "", true
+}
+
+// runCommand runs a slash command typed in the composer and reports whether
+// it ran, in which case the input can be cleared.
+func (m *Model) runCommand(name, args string) (tea.Cmd, bool) {
+	c, ok := commands.lookup(name)
+	if !ok {
+		m.statusText = fmt.Sprintf("Unknown command /%s. Type /help for the list.", name)
+		return nil, false
+	}
+	cmd, err := c.run(m, args)
+	if errors.Is(err, errReported) {
+		return nil, false
+	}
+	if err != nil {
+		m.statusText = fmt.Sprintf("/%s: %v", name, err)
+		return nil, false
+	}
+	return cmd, true
+}
+
+// handleCommandResult shows the result of a command that ran in the background.
+func (m *Model) handleCommandResult(msg commandResultMsg) {
+	if msg.err != nil {
+		m.statusText = fmt.Sprintf("/%s: %v", msg.command, msg.err)
+		return
+	}
+	m.statusText = msg.status
+	if msg.title != "" {
+		m.selection.openPopup(msg.title, msg.text)
+	}
+}
+
+func (m *Model) joinCommand(args string) (tea.Cmd, error) {
+	if args == "" {
+		return nil, errors.New("usage: /join <chat>")
+	}
+	chat, ok := m.findChat(args)
+	if !ok {
+		return nil, fmt.Errorf("no chat matches %q", args)
+	}
+	return m.openChat(chat), nil
+}
+
+// findChat finds a chat by exact ID, name or remark, or else the best fuzzy match.
+func (m *Model) findChat(query string) (adapter.ChatInfo, bool) {
+	for _, c := range m.sidebar.chats {
+		if c.ID == query || c.Name == query || c.Remark == query {
+			return c, true
+		}
+	}
+	if chats := m.finder.rank(query, m.sidebar.chats, m.sidebar.activity, m.sidebar.counts); len(chats) > 0 {
+		return chats[0], true
+	}
+	return adapter.ChatInfo{}, false
+}
+
+func (m *Model) completeChat(args string) []string {
+	var names []string
+	for _, c := range m.finder.rank(args, m.sidebar.chats, m.sidebar.activity, m.sidebar.counts) {
+		name := c.Remark
+		if name == "" {
+			name = c.Name
+		}
+		if name == "" {
+			name = c.ID
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// meCommand sends an action in the *waves* style of other chat apps.
+func (m *Model) meCommand(args string) (tea.Cmd, error) {
+	if args == "" {
+		return nil, errors.New("usage: /me <action>")
+	}
+	return m.sendCommandText("*"+args+"*", "*"+args+"*")
+}
+
+// maxImageSize is the largest image /image sends; QQ rejects bigger ones.
+const maxImageSize = 30 << 20
+
+func (m *Model) imageCommand(args string) (tea.Cmd, error) {
+	if args == "" {
+		return nil, errors.New("usage: /image <file>")
+	}
+	path := expandHome(args)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxImageSize {
+		return nil, fmt.Errorf("%s is %s, QQ allows %s", filepath.Base(path), formatSize(info.Size()), formatSize(maxImageSize))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, fmt.Errorf("%s is not an image", filepath.Base(path))
+	}
+	// 以 base64 发送，OneBot 实现不在本机时也能收到文件
+	content := adapter.FormatCQ(adapter.Segment{Type: "image", Data: map[string]string{"file": "base64://" + base64.StdEncoding.EncodeToString(data)}})
+	shown := adapter.FormatCQ(adapter.Segment{Type: "image", Data: map[string]string{"file": filepath.Base(path)}})
+	return m.sendCommandText(content, shown)
+}
+
+// sendCommandText sends content produced by a command to the active chat.
+func (m *Model) sendCommandText(content, shown string) (tea.Cmd, error) {
+	if m.activeChat == "" {
+		return nil, errors.New("no active chat")
+	}
+	cmd, ok := m.sendContent(content, shown)
+	if !ok {
+		return nil, errReported
+	}
+	return cmd, nil
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// completePath completes a file name, keeping the directory as typed.
+func completePath(arg string) []string {
+	dir, base := filepath.Split(arg)
+	readDir := expandHome(dir)
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if e.IsDir() {
+			name += string(filepath.Separator)
+		}
+		paths = append(paths, dir+name)
+	}
+	return paths
+}
+
+func (m *Model) recallCommand(string) (tea.Cmd, error) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := m.messages[i]; m.isSelf(msg) && msg.MessageID != "" {
+			return m.recallMessage(msg), nil
+		}
+	}
+	return nil, errors.New("no message of yours to recall in this chat")
+}
+
+// searchLimit is how many results /search shows.
+const searchLimit = 50
+
+func (m *Model) searchCommand(args string) (tea.Cmd, error) {
+	if args == "" {
+		return nil, errors.New("usage: /search <text>")
+	}
+	if m.activeChat == "" {
+		return nil, errors.New("no active chat")
+	}
+	m.statusText = "Searching..."
+	store, chatID, names, t, isSelf := m.store, m.activeChat, maps.Clone(m.names), m.theme, m.isSelf
+	return func() tea.Msg {
+		results, err := store.SearchMessages(storage.SearchQuery{Text: args, ChatID: chatID, Limit: searchLimit})
+		if err != nil {
+			return commandResultMsg{command: "search", err: err}
+		}
+		if len(results) == 0 {
+			return commandResultMsg{command: "search", status: fmt.Sprintf("Nothing found for %q.", args)}
+		}
+		var b strings.Builder
+		for _, msg := range results {
+			fmt.Fprintf(&b, "%s  %s\n%s\n\n", t.senderStyle(msg.SenderID, isSelf(msg)).Render(msg.SenderName),
+				t.hint.Render(msg.Time.Format("2006-01-02 15:04")), strings.TrimSpace(plainText(msg.Content, names)))
+		}
+		title := fmt.Sprintf("Search %q (%d)", args, len(results))
+		if len(results) == searchLimit {
+			title = fmt.Sprintf("Search %q (latest %d)", args, searchLimit)
+		}
+		return commandResultMsg{command: "search", title: title, text: strings.TrimSpace(b.String())}
+	}, nil
+}
+
+func (m *Model) exportCommand(args string) (tea.Cmd, error) {
+	if m.activeChat == "" {
+		return nil, errors.New("no active chat")
+	}
+	fields := strings.Fields(args)
+	if len(fields) > 2 {
+		return nil, errors.New("usage: /export [format] [file]")
+	}
+	var name, path string
+	if len(fields) > 0 {
+		name = fields[0]
+	}
+	format, err := storage.ParseExportFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 1 {
+		path = expandHome(fields[1])
+	} else {
+		path = m.activeChat + "." + format.Ext()
+	}
+	m.statusText = "Exporting..."
+	store := m.store
+	opts := storage.ExportOptions{ChatID: m.activeChat, ChatName: m.appState.GetChatName(m.activeChat), Format: format}
+	if format == storage.ExportHTML {
+		local := m.images.opts.LocalFile
+		opts.InlineImage = func(url string) (string, error) { return media.InlineImage(url, local) }
+	}
+	return func() tea.Msg {
+		f, err := os.Create(path)
+		if err != nil {
+			return commandResultMsg{command: "export", err: err}
+		}
+		res, err := storage.Export(store, f, opts)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if abs, aerr := filepath.Abs(path); aerr == nil {
+			path = abs
+		}
+		status := fmt.Sprintf("Exported %d messages to %s.", res.Messages, path)
+		if res.MissingImages > 0 {
+			status += fmt.Sprintf(" %d images could not be fetched and are shown as text.", res.MissingImages)
+		}
+		return commandResultMsg{command: "export", status: status, err: err}
+	}, nil
+}
+
+func completeExport(_ *Model, args string) []string {
+	if strings.ContainsAny(args, " \t") {
+		return nil
+	}
+	var formats []string
+	for _, f := range []storage.ExportFormat{storage.ExportJSONL, storage.ExportMarkdown, storage.ExportHTML, storage.ExportText} {
+		if strings.HasPrefix(string(f), args) {
+			formats = append(formats, string(f)+" ")
+		}
+	}
+	return formats
+}
+
+// defaultMute is how long /mute mutes for without a duration.
+const defaultMute = 10 * time.Minute
+
+func (m *Model) muteCommand(args string) (tea.Cmd, error) {
+	if m.appState.GetChatType(m.activeChat) != "group" {
+		return nil, errors.New("only works in groups")
+	}
+	who, duration, label := args, defaultMute, "10m"
+	if i := strings.LastIndexFunc(args, unicode.IsSpace); i >= 0 {
+		if d, err := parseMuteDuration(args[i+1:]); err == nil {
+			who, duration, label = strings.TrimSpace(args[:i]), d, args[i+1:]
+		}
+	}
+	if who == "" {
+		return nil, errors.New("usage: /mute <member> [duration]")
+	}
+	target, ok := m.findMember(who)
+	if !ok {
+		return nil, fmt.Errorf("no single member matches %q, press Tab to pick one", who)
+	}
+	status := fmt.Sprintf("Muted %s for %s.", target.name, label)
+	if duration == 0 {
+		status = fmt.Sprintf("Unmuted %s.", target.name)
+	}
+	m.statusText = "Muting..."
+	bot, chatID := m.bot, m.activeChat
+	return func() tea.Msg {
+		return commandResultMsg{command: "mute", status: status, err: bot.MuteMember(chatID, target.id, duration)}
+	}, nil
+}
+
+// parseMuteDuration parses a Go duration such as "90s" or "1h30m", a number of
+// days such as "3d", or "0".
+func parseMuteDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func (m *Model) completeMember(args string) []string {
+	var names []string
+	for _, c := range m.matchMembers(args) {
+		names = append(names, c.name+" ")
+	}
+	return names
+}
+
+func (m *Model) membersCommand(string) (tea.Cmd, error) {
+	if m.appState.GetChatType(m.activeChat) != "group" {
+		return nil, errors.New("only works in groups")
+	}
+	g := m.groupMembers(m.activeChat)
+	if g.loaded {
+		m.showMembers(m.activeChat)
+		return nil, nil
+	}
+	// 加载完成后再显示
+	g.show = true
+	g.failed = false
+	m.statusText = "Loading members..."
+	return nil, nil
+}
+
+// showMembers lists the members of a group in a popup, owner and admins first.
+func (m *Model) showMembers(chatID string) {
+	list := slices.Clone(m.groupMembers(chatID).list)
+	rank := map[string]int{"owner": 0, "admin": 1}
+	sort.SliceStable(list, func(i, j int) bool {
+		ri, ok := rank[list[i].Role]
+		if !ok {
+			ri = 2
+		}
+		rj, ok := rank[list[j].Role]
+		if !ok {
+			rj = 2
+		}
+		if ri != rj {
+			return ri < rj
+		}
+		return memberName(list[i]) < memberName(list[j])
+	})
+	var b strings.Builder
+	for _, p := range list {
+		name := m.theme.senderStyle(p.UserID, false).Render(memberName(p))
+		if p.Card != "" && p.Nickname != "" && p.Card != p.Nickname {
+			name += " " + m.theme.hint.Render("("+p.Nickname+")")
+		}
+		role := ""
+		if p.Role == "owner" || p.Role == "admin" {
+			role = "  " + m.theme.menuKey.Render(p.Role)
+		}
+		fmt.Fprintf(&b, "%s  %s%s\n", name, m.theme.hint.Render(p.UserID), role)
+	}
+	m.selection.openPopup(fmt.Sprintf("Members of %s (%d)", m.appState.GetChatName(chatID), len(list)), strings.TrimSpace(b.String()))
+}
+
+func (m *Model) themeCommand(args string) (tea.Cmd, error) {
+	if args == "" {
+		m.nextTheme()
+		return nil, nil
+	}
+	return nil, m.applyTheme(args)
+}
+
+func (m *Model) completeTheme(args string) []string {
+	var names []string
+	for _, name := range themeNames(m.themeOpts.Dir) {
+		if strings.HasPrefix(name, args) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (m *Model) helpCommand(string) (tea.Cmd, error) {
+	var b strings.Builder
+	for _, name := range commands.names {
+		c := commands.byName[name]
+		usage := "/" + c.name
+		if c.args != "" {
+			usage += " " + c.args
+		}
+		fmt.Fprintf(&b, "%s\n  %s\n", m.theme.menuKey.Render(usage), c.help)
+	}
+	b.WriteString("\n" + m.theme.hint.Render("Tab completes commands and their arguments. Start a message with // to send a leading /."))
+	m.selection.openPopup("Commands", b.String())
+	return nil, nil
+}
@@ -0,0 +1,34 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"90s", 90 * time.Second, false},
+		{"10m", 10 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"3d", 72 * time.Hour, false},
+		{"0d", 0, false},
+		{"", 0, true},
+		{"10", 0, true},
+		{"-5m", 0, true},
+		{"-1d", 0, true},
+		{"1.5d", 0, true},
+		{"d", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMuteDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMuteDuration(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
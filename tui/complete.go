@@ -0,0 +1,233 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-runewidth"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// completion is the state of Tab completion in the composer. Pressing Tab
+// again without typing cycles through the candidates.
+type completion struct {
+	prefix     string // 输入框中被补全部分之前的内容
+	candidates []string
+	index      int
+	applied    string // 上次补全后输入框的内容，不同说明又输入了，要重新补全
+}
+
+// canComplete reports whether Tab completes the input instead of focusing the chat list.
+func (m *Model) canComplete() bool {
+	_, _, ok := parseCommand(m.composer.Value())
+	return ok
+}
+
+// complete completes the command name or argument at the end of the input.
+func (m *Model) complete() {
+	value := m.composer.Value()
+	c := &m.completion
+	if value == c.applied && len(c.candidates) > 1 {
+		c.index = (c.index + 1) % len(c.candidates)
+	} else {
+		prefix, candidates := m.completionsFor(value)
+		if len(candidates) == 0 {
+			*c = completion{}
+			m.statusText = "No completions."
+			return
+		}
+		*c = completion{prefix: prefix, candidates: candidates}
+	}
+	m.composer.SetValue(c.prefix + c.candidates[c.index])
+	m.composer.CursorEnd()
+	m.fitComposer()
+	c.applied = m.composer.Value()
+
+	if len(c.candidates) == 1 {
+		m.statusText = ""
+		return
+	}
+	parts := make([]string, len(c.candidates))
+	for i, s := range c.candidates {
+		s = strings.TrimSpace(s)
+		if i == c.index {
+			s = "[" + s + "]"
+		}
+		parts[i] = s
+	}
+	m.statusText = runewidth.Truncate(strings.Join(parts, "  "), max(m.width-2, 10), "…")
+}
+
+// completionsFor returns the candidates for the end of value and the text in front of them.
+func (m *Model) completionsFor(value string) (string, []string) {
+	name, args, _ := parseCommand(value)
+	if !strings.ContainsAny(value, " \t\n") {
+		var names []string
+		for _, c := range commands.matching(name) {
+			names = append(names, c.name+" ")
+		}
+		return "/", names
+	}
+	c, ok := commands.lookup(name)
+	if !ok || c.complete == nil {
+		return "", nil
+	}
+	// 保留参数前原样的空白
+	args = strings.TrimLeft(value[len(name)+1:], " \t\n")
+	return value[:len(value)-len(args)], c.complete(m, args)
+}
+
+// groupMembers is the member list of a group, loaded on first use.
+type groupMembers struct {
+	list    []adapter.UserProfile
+	loading bool
+	loaded  bool
+	failed  bool
+	show    bool // 加载完成后显示成员列表，见 /members
+}
+
+// membersLoadedMsg carries the member list of a group.
+type membersLoadedMsg struct {
+	chatID  string
+	members []adapter.UserProfile
+	err     error
+}
+
+// groupMembers returns the member list of a group, queuing it for
+// fetchMembers if it has not been loaded.
+func (m *Model) groupMembers(chatID string) *groupMembers {
+	g, ok := m.members[chatID]
+	if !ok {
+		g = &groupMembers{}
+		m.members[chatID] = g
+	}
+	return g
+}
+
+// fetchMembers starts loading the member lists that were asked for.
+func (m *Model) fetchMembers() tea.Cmd {
+	var cmds []tea.Cmd
+	for chatID, g := range m.members {
+		if g.loaded || g.loading || g.failed {
+			continue
+		}
+		g.loading = true
+		bot := m.bot
+		cmds = append(cmds, func() tea.Msg {
+			members, err := bot.GetGroupMembers(chatID)
+			return membersLoadedMsg{chatID: chatID, members: members, err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// handleMembersLoaded stores a loaded member list.
+func (m *Model) handleMembersLoaded(msg membersLoadedMsg) {
+	g := m.groupMembers(msg.chatID)
+	g.loading = false
+	if msg.err != nil {
+		g.failed = true
+		if g.show {
+			g.show = false
+			m.statusText = "/members: " + msg.err.Error()
+		}
+		return
+	}
+	g.list, g.loaded = msg.members, true
+	if m.mention.open {
+		m.updateMention()
+	}
+	if g.show && msg.chatID == m.activeChat {
+		g.show = false
+		m.statusText = ""
+		m.showMembers(msg.chatID)
+	}
+}
+
+// memberName is how a member is shown: the group card, else the nickname.
+func memberName(p adapter.UserProfile) string {
+	if p.Card != "" {
+		return p.Card
+	}
+	if p.Nickname != "" {
+		return p.Nickname
+	}
+	return p.UserID
+}
+
+// member is someone in the active chat, for completion.
+type member struct {
+	id, name string
+	nickname string // 群名片之外的昵称，也用于匹配
+}
+
+// chatMembers returns the members of the active group, or the senders seen in
+// its history until the member list has loaded.
+func (m *Model) chatMembers() []member {
+	var members []member
+	seen := make(map[string]bool)
+	if m.appState.GetChatType(m.activeChat) == "group" {
+		for _, p := range m.groupMembers(m.activeChat).list {
+			members = append(members, member{id: p.UserID, name: memberName(p), nickname: p.Nickname})
+			seen[p.UserID] = true
+		}
+	}
+	for id, name := range m.names {
+		if !seen[id] && name != "" && id != m.bot.SelfID() {
+			members = append(members, member{id: id, name: name})
+		}
+	}
+	return members
+}
+
+// matchMembers ranks the members of the active chat against query by name,
+// nickname, QQ number and pinyin, best first.
+func (m *Model) matchMembers(query string) []member {
+	query = strings.ToLower(strings.Join(strings.Fields(query), ""))
+	type scored struct {
+		member
+		score int
+	}
+	var matches []scored
+	for _, c := range m.chatMembers() {
+		score := 1
+		if query != "" {
+			name, nick := m.finder.pinyinOf(c.name), m.finder.pinyinOf(c.nickname)
+			score = 0
+			for _, key := range []string{strings.ToLower(c.name), strings.ToLower(c.nickname), c.id, name.initials, name.full, nick.initials, nick.full} {
+				score = max(score, fuzzyScore(query, key))
+			}
+			if score == 0 {
+				continue
+			}
+		}
+		matches = append(matches, scored{c, score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].name < matches[j].name
+	})
+	var members []member
+	for i := 0; i < len(matches) && i < finderMaxResults; i++ {
+		members = append(members, matches[i].member)
+	}
+	return members
+}
+
+// findMember finds a member of the active chat by QQ number or exact name, or
+// else the only one that matches. Several matches are not guessed between.
+func (m *Model) findMember(query string) (member, bool) {
+	members := m.chatMembers()
+	for _, c := range members {
+		if c.id == query || c.name == query || c.nickname == query {
+			return c, true
+		}
+	}
+	if matches := m.matchMembers(query); len(matches) == 1 {
+		return matches[0], true
+	}
+	return member{}, false
+}
@@ -0,0 +1,253 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+	"github.com/mozillazg/go-pinyin"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// finderMaxResults is how many matches the quick switcher shows at once.
+const finderMaxResults = 10
+
+// finder is the Ctrl+K quick switcher. It fuzzy matches chat names, remarks,
+// IDs and the pinyin of Chinese names, e.g. "jsq" or "jishu" finds "技术群".
+type finder struct {
+	input   textinput.Model
+	results []adapter.ChatInfo
+	cursor  int
+
+	recent []string                 // 本次运行中打开过的聊天，最近的在前
+	keys   map[string]finderKeys    // chatID -> 用于匹配的字符串
+	pinyin map[string]pinyinStrings // 名称 -> 拼音，避免重复转换
+}
+
+// finderKeys are the lowercase strings a chat can be matched by.
+type finderKeys struct {
+	name, remark, id, initials, full string
+}
+
+type pinyinStrings struct {
+	initials, full string
+}
+
+func newFinder() finder {
+	ti := textinput.New()
+	ti.Prompt = "> "
+	ti.Placeholder = "Jump to chat (name, ID or pinyin)"
+	return finder{
+		input:  ti,
+		keys:   make(map[string]finderKeys),
+		pinyin: make(map[string]pinyinStrings),
+	}
+}
+
+// open resets the query and focuses the input.
+func (f *finder) open(chats []adapter.ChatInfo, activity map[string]time.Time, counts map[string]int64) tea.Cmd {
+	f.input.Reset()
+	f.filter(chats, activity, counts)
+	return f.input.Focus()
+}
+
+// opened records a chat switch so recently used chats rank first.
+func (f *finder) opened(chatID string) {
+	for i, id := range f.recent {
+		if id == chatID {
+			f.recent = append(f.recent[:i], f.recent[i+1:]...)
+			break
+		}
+	}
+	f.recent = append([]string{chatID}, f.recent...)
+	if len(f.recent) > finderMaxResults {
+		f.recent = f.recent[:finderMaxResults]
+	}
+}
+
+func (f *finder) selected() (adapter.ChatInfo, bool) {
+	if f.cursor < 0 || f.cursor >= len(f.results) {
+		return adapter.ChatInfo{}, false
+	}
+	return f.results[f.cursor], true
+}
+
+func (f *finder) move(delta int) {
+	f.cursor = min(max(f.cursor+delta, 0), max(len(f.results)-1, 0))
+}
+
+// filter ranks all chats against the current query.
+func (f *finder) filter(chats []adapter.ChatInfo, activity map[string]time.Time, counts map[string]int64) {
+	f.results = f.rank(f.input.Value(), chats, activity, counts)
+	f.cursor = 0
+}
+
+// rank returns the chats that match query, best first, at most finderMaxResults.
+func (f *finder) rank(query string, chats []adapter.ChatInfo, activity map[string]time.Time, counts map[string]int64) []adapter.ChatInfo {
+	query = strings.ToLower(strings.Join(strings.Fields(query), ""))
+	now := time.Now()
+
+	type scored struct {
+		chat  adapter.ChatInfo
+		score float64
+	}
+	var matches []scored
+	for _, c := range chats {
+		score := 0.0
+		if query != "" {
+			k := f.keysFor(c)
+			best := 0
+			for _, key := range []string{k.name, k.remark, k.id, k.initials, k.full} {
+				best = max(best, fuzzyScore(query, key))
+			}
+			if best == 0 {
+				continue
+			}
+			score = float64(best)
+		}
+		score += rankBonus(f.recent, c.ID, activity[c.ID], counts[c.ID], now)
+		matches = append(matches, scored{c, score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	var results []adapter.ChatInfo
+	for i := 0; i < len(matches) && i < finderMaxResults; i++ {
+		results = append(results, matches[i].chat)
+	}
+	return results
+}
+
+func (f *finder) keysFor(c adapter.ChatInfo) finderKeys {
+	if k, ok := f.keys[c.ID]; ok && k.name == strings.ToLower(c.Name) && k.remark == strings.ToLower(c.Remark) {
+		return k
+	}
+	name := f.pinyinOf(c.Name)
+	remark := f.pinyinOf(c.Remark)
+	k := finderKeys{
+		name:     strings.ToLower(c.Name),
+		remark:   strings.ToLower(c.Remark),
+		id:       c.ID,
+		initials: name.initials + " " + remark.initials,
+		full:     name.full + " " + remark.full,
+	}
+	f.keys[c.ID] = k
+	return k
+}
+
+// pinyinOf converts the Chinese characters of s to pinyin, keeping other letters and digits.
+func (f *finder) pinyinOf(s string) pinyinStrings {
+	if p, ok := f.pinyin[s]; ok {
+		return p
+	}
+	args := pinyin.NewArgs()
+	var initials, full strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			if py := pinyin.SinglePinyin(r, args); len(py) > 0 && py[0] != "" {
+				initials.WriteByte(py[0][0])
+				full.WriteString(py[0])
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			initials.WriteRune(unicode.ToLower(r))
+			full.WriteRune(unicode.ToLower(r))
+		}
+	}
+	p := pinyinStrings{initials: initials.String(), full: full.String()}
+	f.pinyin[s] = p
+	return p
+}
+
+// fuzzyScore returns how well query matches key as a subsequence, or 0 if it does not.
+// Matches at the start of the key and runs of consecutive characters score higher.
+func fuzzyScore(query, key string) int {
+	if key == "" {
+		return 0
+	}
+	if strings.HasPrefix(key, query) {
+		return 100 + len([]rune(query))*10
+	}
+	if strings.Contains(key, query) {
+		return 60 + len([]rune(query))*10
+	}
+	q := []rune(query)
+	score, qi, run := 0, 0, 0
+	for i, r := range []rune(key) {
+		if qi == len(q) {
+			break
+		}
+		if r != q[qi] {
+			run = 0
+			continue
+		}
+		score += 5 + run*5
+		if i == 0 {
+			score += 10
+		}
+		run++
+		qi++
+	}
+	if qi < len(q) {
+		return 0
+	}
+	return score
+}
+
+// rankBonus favours chats that were opened recently, are active and have many messages.
+func rankBonus(recent []string, chatID string, last time.Time, count int64, now time.Time) float64 {
+	bonus := 0.0
+	for i, id := range recent {
+		if id == chatID {
+			bonus += float64(40 - 3*i)
+			break
+		}
+	}
+	if !last.IsZero() {
+		switch age := now.Sub(last); {
+		case age < time.Hour:
+			bonus += 20
+		case age < 24*time.Hour:
+			bonus += 12
+		case age < 7*24*time.Hour:
+			bonus += 5
+		}
+	}
+	return bonus + 2*math.Log2(float64(count)+1)
+}
+
+func (f *finder) view(t *theme, width int, unread map[string]int) string {
+	width = min(max(width-4, 30), 60)
+	inner := width - t.finder.GetHorizontalFrameSize()
+	f.input.Width = inner - 3
+
+	lines := []string{f.input.View(), ""}
+	if len(f.results) == 0 {
+		lines = append(lines, t.hint.Render("No matching chats"))
+	}
+	for i, c := range f.results {
+		kind := "群"
+		if c.Type == "private" {
+			kind = "友"
+		}
+		suffix := " " + c.ID
+		if n := unread[c.ID]; n > 0 {
+			suffix += t.unread.Render(fmt.Sprintf(" •%d", n))
+		}
+		nameWidth := inner - 4 - lipgloss.Width(suffix)
+		name := runewidth.FillRight(runewidth.Truncate(displayName(c), nameWidth, "…"), nameWidth)
+		line := kind + "  " + name + t.hint.Render(suffix)
+		if i == f.cursor {
+			line = t.finderCursor.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", t.hint.Render("↑/↓ select · Enter open · Esc cancel"))
+	return t.finder.Width(width - 2).Render(strings.Join(lines, "\n"))
+}
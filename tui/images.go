@@ -0,0 +1,431 @@
+package tui
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // 注册解码器，GIF 只显示第一帧
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// ImageOptions controls how images in messages are shown.
+type ImageOptions struct {
+	Protocol    string // "auto"（默认）、"kitty"、"iterm"、"sixel"、"blocks" 或 "off"
+	MaxFileSize int64  // 超过这个大小的图片不下载，默认 5 MiB
+	MaxWidth    int    // 最大宽度（字符），0 表示适应消息区域
+	MaxHeight   int    // 最大高度（行），默认 20
+
+	// LocalFile returns the cached copy of a remote image, if there is one.
+	LocalFile func(url string) (string, bool)
+}
+
+const (
+	defaultImageFileSize = 5 << 20
+	defaultImageHeight   = 20
+	maxImagePixels       = 40_000_000 // 拒绝解码后过大的图片
+	maxImageSide         = 1600       // 解码后缩小到这个宽高以内再保存
+	maxImageDownloads    = 4          // 同时下载的图片数
+	imageCacheBytes      = 128 << 20  // 内存中图片和渲染结果的大致上限
+
+	// Kitty 命令在界面中保留的时间，比 Bubble Tea 的重绘间隔长，保证至少输出一次
+	kittyFlushDelay = 100 * time.Millisecond
+)
+
+var errImageTooLarge = errors.New("image exceeds size limit")
+
+type imageState int
+
+const (
+	imageLoading imageState = iota + 1
+	imageReady
+	imageFailed
+)
+
+// imageEntry is a downloaded image and its renderings at the sizes used so far.
+type imageEntry struct {
+	state   imageState
+	img     image.Image
+	renders map[[2]int]*imageRender // {最大宽度, 最大高度} -> 渲染结果
+	kittyID int                     // 已经传给 Kitty 的图片 ID，0 表示还没有传
+	used    int                     // 最后一次渲染的序号，用于淘汰最久没用的图片
+}
+
+// size estimates the memory held by the entry in bytes.
+func (e *imageEntry) size() int {
+	n := 0
+	if e.img != nil {
+		b := e.img.Bounds()
+		n = b.Dx() * b.Dy() * 4
+	}
+	for _, r := range e.renders {
+		n += len(r.seq)
+		for _, l := range r.lines {
+			n += len(l)
+		}
+	}
+	return n
+}
+
+// imageRender is an image drawn for a particular size.
+type imageRender struct {
+	cols, rows int
+	lines      []string // 放进消息内容的行：半块字符或 Kitty 占位符
+	seq        string   // iTerm2 或 Sixel 序列，图片完全可见时画在 lines 上面
+}
+
+// imagePlacement is where a rendered image ended up in the viewport content.
+type imagePlacement struct {
+	render *imageRender
+	line   int // 第一行在内容中的行号
+	col    int // 第一列在消息区域中的位置
+}
+
+// images holds the inline image state of the TUI.
+type images struct {
+	opts     ImageOptions
+	protocol string
+	enabled  bool
+
+	entries    map[string]*imageEntry
+	wanted     map[string]int // 内容中还没有下载的图片 -> 所在行，用于按需下载
+	placements []imagePlacement
+	downloads  int
+	used       int // 渲染计数，见 imageEntry.used
+
+	kittyID      int      // 最后分配的 Kitty 图片 ID
+	kittyQueue   []string // 还没有输出到终端的 Kitty 命令，见 kittyView
+	kittyFlushes bool     // 是否已经安排了 kittyFlushedMsg
+}
+
+// kittyFlushedMsg is sent once the first n queued Kitty commands have been
+// part of the view long enough to reach the terminal.
+type kittyFlushedMsg struct{ n int }
+
+// imageLoadedMsg carries an image downloaded for display.
+type imageLoadedMsg struct {
+	url string
+	img image.Image
+	err error
+}
+
+func newImages(opts ImageOptions) images {
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = defaultImageFileSize
+	}
+	if opts.MaxHeight == 0 {
+		opts.MaxHeight = defaultImageHeight
+	}
+	im := images{
+		opts:     opts,
+		protocol: opts.Protocol,
+		enabled:  opts.Protocol != protocolOff,
+		entries:  make(map[string]*imageEntry),
+		wanted:   make(map[string]int),
+	}
+	if im.protocol == "" || im.protocol == "auto" || im.protocol == protocolOff {
+		im.protocol = detectProtocol()
+	}
+	return im
+}
+
+// toggle turns inline images on or off and returns a description for the status line.
+func (im *images) toggle() string {
+	im.enabled = !im.enabled
+	if !im.enabled {
+		return "Inline images off."
+	}
+	return fmt.Sprintf("Inline images on (%s).", im.protocol)
+}
+
+// render returns the rendering of the image at url, or nil if it is not downloaded.
+func (im *images) render(url string, maxCols int) *imageRender {
+	e := im.entries[url]
+	if e == nil || e.state != imageReady {
+		return nil
+	}
+	im.used++
+	e.used = im.used
+	if im.opts.MaxWidth > 0 {
+		maxCols = min(maxCols, im.opts.MaxWidth)
+	}
+	size := [2]int{max(maxCols, 1), im.opts.MaxHeight}
+	if r, ok := e.renders[size]; ok {
+		return r
+	}
+
+	cols, rows := fitCells(e.img, size[0], size[1])
+	r := &imageRender{cols: cols, rows: rows}
+	switch im.protocol {
+	case protocolKitty:
+		if id := im.kittyImage(e); id != 0 {
+			// 每种大小一个虚拟放置，图片数据只传一次
+			placement := len(e.renders) + 1
+			im.kittyQueue = append(im.kittyQueue, kittyPlace(id, placement, cols, rows))
+			r.lines = kittyPlaceholderRows(id, placement, cols, rows)
+		}
+	case protocolITerm:
+		if data, err := itermData(e.img, cols, rows); err == nil {
+			r.seq = itermSequence(data, cols, rows)
+		}
+	case protocolSixel:
+		r.seq = sixelSequence(e.img, cols, rows)
+	}
+	if r.lines == nil {
+		r.lines = halfBlockRows(e.img, cols, rows)
+	}
+	if e.renders == nil {
+		e.renders = make(map[[2]int]*imageRender)
+	}
+	e.renders[size] = r
+	return r
+}
+
+// prune drops the images that no pane shows any more, and then the least
+// recently used ones until the rest fit in imageCacheBytes. It reports whether
+// an image that is still shown was dropped, in which case the content needs
+// redrawing.
+func (im *images) prune(shown func(url string) bool) bool {
+	total := 0
+	var kept []string
+	for url, e := range im.entries {
+		if e.state == imageLoading {
+			continue
+		}
+		if !shown(url) {
+			im.drop(url)
+			continue
+		}
+		total += e.size()
+		kept = append(kept, url)
+	}
+	if total <= imageCacheBytes {
+		return false
+	}
+	sort.Slice(kept, func(i, j int) bool { return im.entries[kept[i]].used < im.entries[kept[j]].used })
+	for _, url := range kept {
+		if total <= imageCacheBytes {
+			break
+		}
+		total -= im.entries[url].size()
+		im.drop(url)
+	}
+	return true
+}
+
+// drop forgets the image at url, deleting it from the terminal if it was sent to Kitty.
+func (im *images) drop(url string) {
+	if e := im.entries[url]; e != nil && e.kittyID != 0 {
+		im.kittyQueue = append(im.kittyQueue, kittyDelete(e.kittyID))
+	}
+	delete(im.entries, url)
+}
+
+// kittyImage returns the Kitty image ID of e, queueing the image data for the
+// terminal the first time. It returns 0 if the image could not be encoded.
+func (im *images) kittyImage(e *imageEntry) int {
+	if e.kittyID != 0 {
+		return e.kittyID
+	}
+	seq, err := kittyTransmit(e.img, im.kittyID+1)
+	if err != nil {
+		return 0
+	}
+	im.kittyID++
+	e.kittyID = im.kittyID
+	im.kittyQueue = append(im.kittyQueue, seq)
+	return e.kittyID
+}
+
+// kittyView returns the queued Kitty commands. They are printed as part of the
+// view rather than written to the terminal directly, so they cannot interleave
+// with Bubble Tea's own output.
+func (im *images) kittyView() string {
+	return strings.Join(im.kittyQueue, "")
+}
+
+// flushKitty schedules the removal of the queued Kitty commands from the view.
+func (im *images) flushKitty() tea.Cmd {
+	if len(im.kittyQueue) == 0 || im.kittyFlushes {
+		return nil
+	}
+	im.kittyFlushes = true
+	n := len(im.kittyQueue)
+	return tea.Tick(kittyFlushDelay, func(time.Time) tea.Msg { return kittyFlushedMsg{n: n} })
+}
+
+// kittyFlushed drops the Kitty commands that have been sent.
+func (im *images) kittyFlushed(msg kittyFlushedMsg) {
+	im.kittyQueue = im.kittyQueue[msg.n:]
+	im.kittyFlushes = false
+}
+
+// fetch starts downloading the wanted images that are on or near the screen.
+func (im *images) fetch(yOffset, height int) tea.Cmd {
+	if !im.enabled {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for url, line := range im.wanted {
+		if im.downloads >= maxImageDownloads {
+			break
+		}
+		if line < yOffset-height || line >= yOffset+2*height || im.entries[url] != nil {
+			continue
+		}
+		im.entries[url] = &imageEntry{state: imageLoading}
+		im.downloads++
+		cmds = append(cmds, loadImage(url, im.opts))
+	}
+	return tea.Batch(cmds...)
+}
+
+// loaded records a finished download and reports whether the content needs redrawing.
+func (im *images) loaded(msg imageLoadedMsg) bool {
+	im.downloads--
+	e := im.entries[msg.url]
+	if e == nil {
+		return false
+	}
+	if msg.err != nil {
+		e.state = imageFailed
+		return false
+	}
+	e.state, e.img = imageReady, msg.img
+	_, shown := im.wanted[msg.url]
+	return shown
+}
+
+// place finds the rendered images in the content, starting at the given line.
+func (im *images) place(lines []string, from int, renders []*imageRender) {
+	for _, r := range renders {
+		for i := from; i < len(lines); i++ {
+			if idx := strings.Index(lines[i], r.lines[0]); idx >= 0 {
+				im.placements = append(im.placements, imagePlacement{render: r, line: i, col: ansi.StringWidth(lines[i][:idx])})
+				from = i + r.rows
+				break
+			}
+		}
+	}
+}
+
+// overlay draws iTerm2 and Sixel images over their half-block stand-ins in the
+// rendered viewport. Only images that are completely visible are drawn, since
+// the graphics would otherwise spill over the input box. x is the column of the
+// viewport on the screen.
+func (im *images) overlay(view string, yOffset, height, x int) string {
+	if !im.enabled || (im.protocol != protocolITerm && im.protocol != protocolSixel) {
+		return view
+	}
+	lines := strings.Split(view, "\n")
+	for _, p := range im.placements {
+		r := p.render
+		top := p.line - yOffset
+		if r.seq == "" || top < 0 || top+r.rows > min(height, len(lines)) {
+			continue
+		}
+		blank := strings.Repeat(" ", r.cols)
+		for k := 0; k < r.rows; k++ {
+			lines[top+k] = strings.Replace(lines[top+k], r.lines[k], blank, 1)
+		}
+		// 在最后一行输出，这样 Bubble Tea 逐行重绘时不会擦掉已经画好的图片
+		var seq strings.Builder
+		seq.WriteString(ansi.SaveCursor)
+		if r.rows > 1 {
+			seq.WriteString(ansi.CursorUp(r.rows - 1))
+		}
+		seq.WriteString(ansi.CursorHorizontalAbsolute(x + p.col + 1))
+		seq.WriteString(r.seq)
+		seq.WriteString(ansi.RestoreCursor)
+		lines[top+r.rows-1] += seq.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// imageURL returns the download address of an image segment.
+func imageURL(seg adapter.Segment) string {
+	for _, k := range []string{"url", "file"} {
+		if v := seg.Data[k]; strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			return v
+		}
+	}
+	return ""
+}
+
+var imageClient = &http.Client{Timeout: 30 * time.Second}
+
+// loadImage is a command that downloads and decodes an image, preferring the media cache.
+func loadImage(url string, opts ImageOptions) tea.Cmd {
+	return func() tea.Msg {
+		img, err := fetchImage(url, opts)
+		return imageLoadedMsg{url: url, img: img, err: err}
+	}
+}
+
+func fetchImage(url string, opts ImageOptions) (image.Image, error) {
+	var body io.Reader
+	if path, ok := localImage(url, opts); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	} else {
+		resp, err := imageClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		if resp.ContentLength > opts.MaxFileSize {
+			return nil, errImageTooLarge
+		}
+		body = resp.Body
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, opts.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > opts.MaxFileSize {
+		return nil, errImageTooLarge
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// 显示时只会用到小图，大图不用一直占着内存
+	b := img.Bounds()
+	if side := max(b.Dx(), b.Dy()); side > maxImageSide {
+		img = scaleImage(img, max(b.Dx()*maxImageSide/side, 1), max(b.Dy()*maxImageSide/side, 1))
+	}
+	return img, nil
+}
+
+func localImage(url string, opts ImageOptions) (string, bool) {
+	if opts.LocalFile == nil {
+		return "", false
+	}
+	return opts.LocalFile(url)
+}
@@ -0,0 +1,252 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/ansi/iterm2"
+	"github.com/charmbracelet/x/ansi/kitty"
+)
+
+// Image protocols, from best to most compatible.
+const (
+	protocolKitty  = "kitty"
+	protocolITerm  = "iterm"
+	protocolSixel  = "sixel"
+	protocolBlocks = "blocks"
+	protocolOff    = "off"
+)
+
+// 终端字符单元的像素大小无法可靠获取，Sixel 按常见的 10x20 估算
+const (
+	cellWidthPx  = 10
+	cellHeightPx = 20
+)
+
+// detectProtocol guesses the best image protocol from the environment.
+func detectProtocol() string {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		// 图片协议需要 tmux 透传，位置也不可靠
+		return protocolBlocks
+	case term == "xterm-kitty" || os.Getenv("KITTY_WINDOW_ID") != "" || program == "ghostty":
+		return protocolKitty
+	case program == "iTerm.app" || program == "WezTerm":
+		return protocolITerm
+	case strings.Contains(term, "sixel") || term == "foot" || strings.HasPrefix(term, "mlterm") ||
+		os.Getenv("WT_SESSION") != "" || os.Getenv("KONSOLE_VERSION") != "":
+		return protocolSixel
+	}
+	return protocolBlocks
+}
+
+// fitCells returns the size in cells of img scaled to fit maxCols x maxRows,
+// never scaling small images up.
+func fitCells(img image.Image, maxCols, maxRows int) (cols, rows int) {
+	b := img.Bounds()
+	w, h := max(b.Dx(), 1), max(b.Dy(), 1)
+	cols = min(maxCols, (w+cellWidthPx-1)/cellWidthPx)
+	rows = (cols*h*cellWidthPx/w + cellHeightPx/2) / cellHeightPx
+	if rows > maxRows {
+		rows = maxRows
+		cols = rows * cellHeightPx * w / h / cellWidthPx
+	}
+	return max(cols, 1), max(rows, 1)
+}
+
+// scaleImage resizes img to w x h by averaging the source pixels under each target pixel.
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	src := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(src.Min.X+(x+1)*src.Dx()/w, x0+1)
+			var r, g, b, a, n uint32
+			// 缩小倍数很大时隔点取样，避免大图卡住界面
+			step := max((x1-x0)/4, 1)
+			for sy := y0; sy < y1; sy += step {
+				for sx := x0; sx < x1; sx += step {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+cr, g+cg, b+cb, a+ca, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// halfBlockRows draws img with "▀" characters, two pixels per cell.
+func halfBlockRows(img image.Image, cols, rows int) []string {
+	small := scaleImage(img, cols, rows*2)
+	lines := make([]string, rows)
+	for y := 0; y < rows; y++ {
+		var b strings.Builder
+		for x := 0; x < cols; x++ {
+			top, bottom := small.RGBAAt(x, 2*y), small.RGBAAt(x, 2*y+1)
+			fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		b.WriteString("\x1b[0m")
+		lines[y] = b.String()
+	}
+	return lines
+}
+
+// kittyTransmit returns the sequence that uploads img to the terminal as image
+// id. The image is sent once and shown at any size with kittyPlace.
+func kittyTransmit(img image.Image, id int) (string, error) {
+	var buf bytes.Buffer
+	err := ansi.WriteKittyGraphics(&buf, img, &kitty.Options{
+		Action:       kitty.Transmit,
+		Transmission: kitty.Direct,
+		Format:       kitty.PNG,
+		ID:           id,
+		Quite:        2,
+		Chunk:        true,
+	})
+	return buf.String(), err
+}
+
+// kittyPlace returns the sequence that creates a virtual placement of image id
+// cols x rows cells in size, shown wherever its kittyPlaceholderRows are printed.
+func kittyPlace(id, placement, cols, rows int) string {
+	return ansi.KittyGraphics(nil, (&kitty.Options{
+		Action:           kitty.Put,
+		ID:               id,
+		PlacementID:      placement,
+		Columns:          cols,
+		Rows:             rows,
+		VirtualPlacement: true,
+		Quite:            2,
+	}).Options()...)
+}
+
+// kittyDelete returns the sequence that deletes image id and frees its data.
+func kittyDelete(id int) string {
+	return ansi.KittyGraphics(nil, (&kitty.Options{
+		Action:          kitty.Delete,
+		Delete:          kitty.DeleteID,
+		DeleteResources: true,
+		ID:              id,
+		Quite:           2,
+	}).Options()...)
+}
+
+// kittyPlaceholderRows returns the Unicode placeholder cells for a placement of
+// image id. The image ID is encoded in the foreground colour, the placement ID
+// in the underline colour and the cell position in diacritics.
+func kittyPlaceholderRows(id, placement, cols, rows int) []string {
+	fg := fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[58;2;%d;%d;%dm",
+		id>>16&0xff, id>>8&0xff, id&0xff, placement>>16&0xff, placement>>8&0xff, placement&0xff)
+	lines := make([]string, rows)
+	for y := 0; y < rows; y++ {
+		var b strings.Builder
+		b.WriteString(fg)
+		for x := 0; x < cols; x++ {
+			b.WriteRune(kitty.Placeholder)
+			b.WriteRune(kitty.Diacritic(y))
+			b.WriteRune(kitty.Diacritic(x))
+		}
+		b.WriteString("\x1b[39;59m")
+		lines[y] = b.String()
+	}
+	return lines
+}
+
+// itermSequence returns the iTerm2 inline image sequence for the encoded image data.
+func itermSequence(data []byte, cols, rows int) string {
+	return ansi.ITerm2(iterm2.File{
+		Inline:          true,
+		Width:           iterm2.Cells(cols),
+		Height:          iterm2.Cells(rows),
+		Size:            int64(len(data)),
+		DoNotMoveCursor: true,
+		Content:         []byte(base64.StdEncoding.EncodeToString(data)),
+	})
+}
+
+// sixelSequence encodes img scaled to cols x rows cells as Sixel graphics.
+func sixelSequence(img image.Image, cols, rows int) string {
+	w, h := cols*cellWidthPx, rows*cellHeightPx
+	small := scaleImage(img, w, h)
+	pal := image.NewPaletted(small.Bounds(), palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, pal.Bounds(), small, image.Point{})
+
+	var b strings.Builder
+	// P2=1：没有绘制的像素保持背景色
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", w, h)
+	for i, c := range pal.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+	band := make([]byte, w)
+	for y0 := 0; y0 < h; y0 += 6 {
+		used := make(map[uint8]bool)
+		for y := y0; y < min(y0+6, h); y++ {
+			for x := 0; x < w; x++ {
+				used[pal.ColorIndexAt(x, y)] = true
+			}
+		}
+		first := true
+		for ci := range pal.Palette {
+			if !used[uint8(ci)] {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && y0+dy < h; dy++ {
+					if pal.ColorIndexAt(x, y0+dy) == uint8(ci) {
+						bits |= 1 << dy
+					}
+				}
+				band[x] = '?' + bits
+			}
+			if !first {
+				b.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&b, "#%d", ci)
+			writeSixelRuns(&b, band)
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRuns writes a band of sixel characters with run-length encoding.
+func writeSixelRuns(b *strings.Builder, band []byte) {
+	for i := 0; i < len(band); {
+		j := i
+		for j < len(band) && band[j] == band[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(b, "!%d%c", n, band[i])
+		} else {
+			b.WriteString(strings.Repeat(string(band[i]), n))
+		}
+		i = j
+	}
+}
+
+// itermData encodes img for iTerm2 as a PNG at twice the cell resolution, which
+// keeps the sequence small while staying sharp on HiDPI screens.
+func itermData(img image.Image, cols, rows int) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, scaleImage(img, cols*cellWidthPx*2, rows*cellHeightPx*2))
+	return buf.Bytes(), err
+}
@@ -0,0 +1,155 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// keyMap holds the key bindings of the chat screen. Keys inside the chat
+// list, the quick switcher and selection mode are fixed.
+type keyMap struct {
+	Quit, Help, Send, Newline, Editor, Complete       key.Binding
+	Finder, Sidebar, FocusSidebar, Select, JumpUnread key.Binding
+	NextPane, Images, Stats, LogView, Theme, Boss     key.Binding
+	listNav, selectionNav                             []key.Binding // 只用于帮助
+}
+
+func newKeyMap() keyMap {
+	b := func(help string, keys ...string) key.Binding {
+		return key.NewBinding(key.WithKeys(keys...), key.WithHelp(keyHelp(keys), help))
+	}
+	return keyMap{
+		Quit:         b("quit", "ctrl+c"),
+		Help:         b("help (empty input)", "?"),
+		Send:         b("send", "enter"),
+		Newline:      b("new line", "alt+enter", "shift+enter", "ctrl+j"),
+		Editor:       b("open $EDITOR", "alt+e"),
+		Complete:     b("complete /command", "tab"),
+		Finder:       b("find chat", "ctrl+k"),
+		Sidebar:      b("toggle chat list", "ctrl+b"),
+		FocusSidebar: b("focus chat list", "tab"),
+		Select:       b("select messages", "alt+v"),
+		JumpUnread:   b("first unread", "alt+u"),
+		NextPane:     b("next pane", "alt+w"),
+		Images:       b("toggle images", "alt+i"),
+		Stats:        b("statistics", "alt+s"),
+		LogView:      b("log view", "alt+l"),
+		Theme:        b("next theme", "alt+t"),
+		Boss:         b("boss key", "f12"),
+		listNav: []key.Binding{
+			b("move", "up", "down", "j", "k"),
+			b("first/last", "g", "G"),
+			b("open", "enter"),
+			b("back", "esc"),
+		},
+		selectionNav: []key.Binding{
+			b("move", "up", "down", "j", "k"),
+			b("action menu", "enter"),
+			b("leave", "esc"),
+		},
+	}
+}
+
+// bindings maps the action names used in the config to the bindings.
+func (k *keyMap) bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"quit": &k.Quit, "help": &k.Help, "send": &k.Send, "newline": &k.Newline, "editor": &k.Editor,
+		"complete": &k.Complete, "finder": &k.Finder, "sidebar": &k.Sidebar, "focusSidebar": &k.FocusSidebar, "select": &k.Select,
+		"jumpUnread": &k.JumpUnread, "nextPane": &k.NextPane, "images": &k.Images, "stats": &k.Stats, "logView": &k.LogView,
+		"theme": &k.Theme, "boss": &k.Boss,
+	}
+}
+
+// override rebinds actions. Each value is a comma-separated list of keys, as
+// Bubble Tea names them, e.g. "ctrl+q" or "alt+enter, ctrl+j".
+func (k *keyMap) override(keys map[string]string) error {
+	bindings := k.bindings()
+	var unknown []string
+	for name, value := range keys {
+		b, ok := bindings[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		if len(list) == 0 {
+			b.SetEnabled(false)
+			continue
+		}
+		b.SetKeys(list...)
+		b.SetHelp(keyHelp(list), b.Help().Desc)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown key actions: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// keyHelp is how keys are shown in the help, e.g. "alt+enter/ctrl+j".
+func keyHelp(keys []string) string {
+	return strings.Join(keys, "/")
+}
+
+// firstKey is the main key of a binding, for hints.
+func firstKey(b key.Binding) string {
+	if keys := b.Keys(); len(keys) > 0 && b.Enabled() {
+		return keys[0]
+	}
+	return "-"
+}
+
+// ShortHelp is shown in the status line hint.
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Help, k.Finder, k.Select, k.Quit}
+}
+
+// FullHelp is shown in the help overlay, one column per group.
+func (k keyMap) FullHelp() [][]key.Binding {
+	var actions []key.Binding
+	for _, a := range messageActions {
+		actions = append(actions, key.NewBinding(key.WithKeys(a.key), key.WithHelp(a.key, strings.ToLower(a.label))))
+	}
+	return [][]key.Binding{
+		{k.Send, k.Newline, k.Editor, k.Complete, k.Finder, k.Sidebar, k.FocusSidebar, k.JumpUnread, k.Select, k.NextPane},
+		{k.Images, k.Stats, k.LogView, k.Theme, k.Boss, k.Help, k.Quit},
+		append(append([]key.Binding{}, k.selectionNav...), actions...),
+	}
+}
+
+// helpView renders the help overlay.
+func (m *Model) helpView() string {
+	h := help.New()
+	h.Styles.FullKey = m.theme.menuKey
+	h.Styles.FullDesc = lipgloss.NewStyle()
+	h.Styles.FullSeparator = m.theme.hint
+	h.FullSeparator = "    "
+	h.Width = max(m.width-6, 20)
+	columns := m.keys.FullHelp()
+	body := m.theme.popupTitle.Render("Keys") + "\n\n" +
+		h.FullHelpView(columns[:2]) + "\n\n" +
+		m.theme.popupTitle.Render("Chat list") + "\n" + h.FullHelpView([][]key.Binding{m.keys.listNav}) + "\n\n" +
+		m.theme.popupTitle.Render("Selected message") + "\n" + h.FullHelpView(splitColumns(columns[2], 2)) + "\n\n" +
+		m.theme.hint.Render("Press any key to close")
+	return m.theme.menu.Render(body)
+}
+
+// splitColumns spreads bindings over n columns.
+func splitColumns(bindings []key.Binding, n int) [][]key.Binding {
+	size := (len(bindings) + n - 1) / n
+	var columns [][]key.Binding
+	for i := 0; i < len(bindings); i += size {
+		columns = append(columns, bindings[i:min(i+size, len(bindings))])
+	}
+	return columns
+}
@@ -0,0 +1,260 @@
+package tui
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/mattn/go-runewidth"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// mentionMaxQuery is the longest text after @ that is still looked up as a member.
+const mentionMaxQuery = 20
+
+// mentionAll is the candidate for mentioning everyone in a group.
+var mentionAll = member{id: "all", name: "全体成员", nickname: "all"}
+
+// mentionPopup is the list of group members offered after typing @ in a group.
+type mentionPopup struct {
+	open      bool
+	query     string // @ 之后输入的文字
+	results   []member
+	cursor    int
+	at        [2]int // @ 在输入框中的行和列
+	dismissed bool   // 这个位置的 @ 已经按 Esc 关掉了
+}
+
+// mentionToken finds an @ being typed in front of the cursor and returns the
+// text after it and its position.
+func (m *Model) mentionToken() (query string, at [2]int, ok bool) {
+	row := m.composer.Line()
+	lines := strings.Split(m.composer.Value(), "\n")
+	if row >= len(lines) {
+		return "", at, false
+	}
+	info := m.composer.LineInfo()
+	runes := []rune(lines[row])
+	before := runes[:min(info.StartColumn+info.ColumnOffset, len(runes))]
+	i := len(before) - 1
+	for i >= 0 && before[i] != '@' {
+		i--
+	}
+	if i < 0 || len(before)-i-1 > mentionMaxQuery {
+		return "", at, false
+	}
+	// 前面是字母或数字时多半是邮箱地址
+	if i > 0 && before[i-1] < unicode.MaxASCII && (unicode.IsLetter(before[i-1]) || unicode.IsDigit(before[i-1])) {
+		return "", at, false
+	}
+	query = string(before[i+1:])
+	if strings.ContainsFunc(query, unicode.IsSpace) {
+		return "", at, false
+	}
+	return query, [2]int{row, i}, true
+}
+
+// updateMention opens, filters or closes the member list after the input changed.
+func (m *Model) updateMention() {
+	p := &m.mention
+	query, at, ok := m.mentionToken()
+	if !ok || m.finderOpen || m.sidebarFocused || m.selection.active || m.appState.GetChatType(m.activeChat) != "group" {
+		p.open, p.dismissed = false, false
+		return
+	}
+	if p.dismissed && p.at == at {
+		return
+	}
+	if !p.open || p.query != query || p.at != at {
+		p.cursor = 0
+	}
+	p.dismissed, p.at, p.query = false, at, query
+	p.results = m.mentionCandidates(query)
+	p.open = len(p.results) > 0
+	p.cursor = min(p.cursor, max(len(p.results)-1, 0))
+}
+
+// mentionCandidates are the members matching query, with @全体成员 last.
+func (m *Model) mentionCandidates(query string) []member {
+	results := m.matchMembers(query)
+	q := strings.ToLower(query)
+	all := m.finder.pinyinOf(mentionAll.name)
+	for _, key := range []string{mentionAll.name, mentionAll.nickname, all.initials, all.full} {
+		if fuzzyScore(q, key) > 0 || q == "" {
+			results = append(results, mentionAll)
+			break
+		}
+	}
+	return results
+}
+
+// updateMentionKeys handles the keys of the open member list and reports
+// whether the key was used.
+func (m *Model) updateMentionKeys(msg tea.KeyMsg) bool {
+	p := &m.mention
+	switch {
+	case key.Matches(msg, m.keys.Complete) || msg.String() == "enter":
+		m.insertMention(p.results[p.cursor])
+	case msg.String() == "up" || msg.String() == "ctrl+p":
+		p.cursor = max(p.cursor-1, 0)
+	case msg.String() == "down" || msg.String() == "ctrl+n":
+		p.cursor = min(p.cursor+1, len(p.results)-1)
+	case msg.String() == "esc":
+		p.open, p.dismissed = false, true
+	default:
+		return false
+	}
+	return true
+}
+
+// pickedMention is a member picked from the member list for the draft. Two
+// members can have the same name, so the picks are kept in the order their
+// @Name appears in the draft and matched up with it in that order.
+type pickedMention struct {
+	name string
+	id   string
+}
+
+// mentionSpan is where a picked mention is in the draft.
+type mentionSpan struct {
+	start, end int // @Name 在草稿中的字节范围
+	pick       int // picked 中的下标
+}
+
+// insertMention replaces the @ being typed with @Name and remembers who Name
+// is, so that send turns it into an at segment.
+func (m *Model) insertMention(who member) {
+	p := &m.mention
+	for range []rune("@" + p.query) {
+		m.composer, _ = m.composer.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	m.composer.InsertString("@" + who.name + " ")
+	m.fitComposer()
+
+	// 按草稿现在的内容重新排列，已经删掉的 @ 不再保留
+	text := m.composer.Value()
+	at := composerOffset(text, p.at)
+	picked := m.picked[m.activeChat]
+	var list []pickedMention
+	added := false
+	for _, s := range matchMentions(text, picked, at) {
+		if !added && s.start > at {
+			list, added = append(list, pickedMention{name: who.name, id: who.id}), true
+		}
+		list = append(list, picked[s.pick])
+	}
+	if !added {
+		list = append(list, pickedMention{name: who.name, id: who.id})
+	}
+	m.picked[m.activeChat] = list
+
+	if _, ok := m.names[who.id]; !ok && who.id != mentionAll.id {
+		m.names[who.id] = who.name
+	}
+	p.open = false
+}
+
+// composerOffset converts a row and rune column in text to a byte offset.
+func composerOffset(text string, pos [2]int) int {
+	offset := 0
+	for row, line := range strings.Split(text, "\n") {
+		if row == pos[0] {
+			runes := []rune(line)
+			return offset + len(string(runes[:min(pos[1], len(runes))]))
+		}
+		offset += len(line) + 1
+	}
+	return len(text)
+}
+
+// matchMentions finds the picked mentions in text, in order. At each @ the
+// longest matching name is used, so "张三丰" is not taken for "张三", and each
+// pick is used once. The @ at byte offset skip is ignored; pass -1 to use all.
+func matchMentions(text string, picked []pickedMention, skip int) []mentionSpan {
+	var spans []mentionSpan
+	used := make([]bool, len(picked))
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' || i == skip {
+			continue
+		}
+		best := -1
+		for j, p := range picked {
+			if !used[j] && strings.HasPrefix(text[i+1:], p.name) && (best < 0 || len(p.name) > len(picked[best].name)) {
+				best = j
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		used[best] = true
+		spans = append(spans, mentionSpan{start: i, end: i + 1 + len(picked[best].name), pick: best})
+		i += len(picked[best].name)
+	}
+	return spans
+}
+
+// expandMentions turns the @Name mentions picked from the member list into at segments.
+func (m *Model) expandMentions(text string) string {
+	picked := m.picked[m.activeChat]
+	var b strings.Builder
+	last := 0
+	for _, s := range matchMentions(text, picked, -1) {
+		b.WriteString(text[last:s.start])
+		b.WriteString(adapter.FormatCQ(adapter.Segment{Type: "at", Data: map[string]string{"qq": picked[s.pick].id}}))
+		last = s.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// mentionView renders the member list.
+func (m *Model) mentionView() string {
+	p := &m.mention
+	var lines []string
+	for i, c := range p.results {
+		line := runewidth.Truncate(c.name, 20, "…")
+		if c.id != mentionAll.id {
+			line += "  " + m.theme.hint.Render(c.id)
+		}
+		if i == p.cursor {
+			line = m.theme.menuCursor.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return m.theme.menu.Render(strings.Join(lines, "\n"))
+}
+
+// mentionColumn is the screen column of the @ being completed, relative to the composer.
+func (m *Model) mentionColumn() int {
+	info := m.composer.LineInfo()
+	lines := strings.Split(m.composer.Value(), "\n")
+	runes := []rune(lines[min(m.mention.at[0], len(lines)-1)])
+	from := min(info.StartColumn, m.mention.at[1])
+	return ansi.StringWidth(m.composer.Prompt) + runewidth.StringWidth(string(runes[from:min(m.mention.at[1], len(runes))]))
+}
+
+// overlayBottom draws box over the last lines of view, starting at column x.
+func overlayBottom(view, box string, x int) string {
+	lines := strings.Split(view, "\n")
+	boxLines := strings.Split(box, "\n")
+	if len(boxLines) > len(lines) {
+		boxLines = boxLines[len(boxLines)-len(lines):]
+	}
+	start := len(lines) - len(boxLines)
+	for i, b := range boxLines {
+		line := lines[start+i]
+		width := ansi.StringWidth(line)
+		left := ansi.Truncate(line, x, "")
+		left += strings.Repeat(" ", max(x-ansi.StringWidth(left), 0))
+		right := ansi.Cut(line, x+ansi.StringWidth(b), width)
+		// 切在宽字符中间时会多出或少掉一列
+		line = left + ansiReset + b + ansiReset + right
+		if w := ansi.StringWidth(line); w > width {
+			line = ansi.Truncate(line, width, "")
+		}
+		lines[start+i] = line + strings.Repeat(" ", max(width-ansi.StringWidth(line), 0))
+	}
+	return strings.Join(lines, "\n")
+}
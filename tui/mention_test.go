@@ -0,0 +1,33 @@
+package tui
+
+import "testing"
+
+func TestExpandMentions(t *testing.T) {
+	tests := []struct {
+		name   string
+		picked []pickedMention
+		text   string
+		want   string
+	}{
+		{"one", []pickedMention{{"张三", "1"}}, "@张三 hi", "[CQ:at,qq=1] hi"},
+		{"not picked", []pickedMention{{"张三", "1"}}, "@李四 hi", "@李四 hi"},
+		{"nothing picked", nil, "@张三 hi", "@张三 hi"},
+		// 每次选择只对应一个 @
+		{"typed again", []pickedMention{{"张三", "1"}}, "@张三 @张三", "[CQ:at,qq=1] @张三"},
+		// 名字是另一个名字的前缀时取最长的
+		{"longest name", []pickedMention{{"张三", "1"}, {"张三丰", "2"}}, "@张三丰 @张三", "[CQ:at,qq=2] [CQ:at,qq=1]"},
+		// 同名的成员按选择的顺序对应
+		{"same name", []pickedMention{{"小明", "1"}, {"小明", "2"}}, "@小明 和 @小明", "[CQ:at,qq=1] 和 [CQ:at,qq=2]"},
+		{"everyone", []pickedMention{{"全体成员", "all"}}, "@全体成员 开会", "[CQ:at,qq=all] 开会"},
+		{"multiline", []pickedMention{{"bob", "3"}}, "hi\n@bob!", "hi\n[CQ:at,qq=3]!"},
+	}
+	for _, tt := range tests {
+		m := &Model{activeChat: "100", picked: map[string][]pickedMention{
+			"100": tt.picked,
+			"200": {{"李四", "4"}}, // 其他聊天中选择的成员不会用到
+		}}
+		if got := m.expandMentions(tt.text); got != tt.want {
+			t.Errorf("%s: expandMentions(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+const (
+	maxPanes      = 4
+	minPaneWidth  = 24 // 左右分屏时每个窗格至少的列数
+	minPaneHeight = 5  // 上下分屏时每个窗格至少的行数，包括标题行
+)
+
+// paneState is the part of the Model that belongs to the chat shown in a
+// pane. The focused pane lives in the Model's own fields, so the rest of the
+// TUI keeps working on the active chat; the other panes are kept here.
+type paneState struct {
+	chatID        string
+	headerText    string
+	messages      []adapter.Message
+	msgLines      []int
+	firstUnreadID int64
+	names         map[string]string
+	viewport      viewport.Model
+	showStats     bool
+	statsText     string
+	placements    []imagePlacement
+	wanted        map[string]int
+}
+
+// splitPaneMsg opens a new pane after the command that asked for it has been
+// cleared from the input, so it is not kept as a draft of either chat.
+type splitPaneMsg struct {
+	vertical bool
+	chat     adapter.ChatInfo // 新窗格中打开的聊天，ID 为空时不打开
+}
+
+// closePaneMsg closes the focused pane, see splitPaneMsg.
+type closePaneMsg struct{}
+
+func (m *Model) savePane() paneState {
+	return paneState{
+		chatID:        m.activeChat,
+		headerText:    m.headerText,
+		messages:      m.messages,
+		msgLines:      m.msgLines,
+		firstUnreadID: m.firstUnreadID,
+		names:         m.names,
+		viewport:      m.viewport,
+		showStats:     m.showStats,
+		statsText:     m.statsText,
+		placements:    m.images.placements,
+		wanted:        m.images.wanted,
+	}
+}
+
+func (m *Model) loadPane(p paneState) {
+	if p.names == nil {
+		p.names = make(map[string]string)
+	}
+	if p.wanted == nil {
+		p.wanted = make(map[string]int)
+	}
+	m.activeChat, m.headerText = p.chatID, p.headerText
+	m.messages, m.msgLines, m.firstUnreadID = p.messages, p.msgLines, p.firstUnreadID
+	m.names, m.viewport = p.names, p.viewport
+	m.showStats, m.statsText = p.showStats, p.statsText
+	m.images.placements, m.images.wanted = p.placements, p.wanted
+}
+
+// inPane runs f with pane i in the Model's fields, so that code written for
+// the active chat can update any pane.
+func (m *Model) inPane(i int, f func()) {
+	if i == m.focus {
+		f()
+		return
+	}
+	focused, sel := m.savePane(), m.selection
+	m.selection = selection{width: sel.width, height: sel.height}
+	m.loadPane(m.panes[i])
+	f()
+	m.panes[i] = m.savePane()
+	m.loadPane(focused)
+	m.selection = sel
+}
+
+// eachPane runs f for every pane, see inPane.
+func (m *Model) eachPane(f func()) {
+	for i := range m.panes {
+		m.inPane(i, f)
+	}
+}
+
+// paneOf returns the pane showing a chat, or -1.
+func (m *Model) paneOf(chatID string) int {
+	for i := range m.panes {
+		id := m.panes[i].chatID
+		if i == m.focus {
+			id = m.activeChat
+		}
+		if id != "" && id == chatID {
+			return i
+		}
+	}
+	return -1
+}
+
+// redraw renders the messages of every pane again, keeping the panes that
+// were scrolled to the bottom there.
+func (m *Model) redraw() {
+	m.eachPane(func() {
+		if m.showStats {
+			return
+		}
+		atBottom := m.viewport.AtBottom()
+		m.updateViewportContent()
+		if atBottom {
+			m.viewport.GotoBottom()
+		}
+	})
+}
+
+// paneWants reports whether a pane other than the focused one shows url.
+func (m *Model) paneWants(url string) bool {
+	for i, p := range m.panes {
+		if _, ok := p.wanted[url]; ok && i != m.focus {
+			return true
+		}
+	}
+	return false
+}
+
+// imageShown reports whether any pane shows the image at url.
+func (m *Model) imageShown(url string) bool {
+	_, ok := m.images.wanted[url]
+	return ok || m.paneWants(url)
+}
+
+// focusPane moves the focus, and with it the input, to pane i.
+func (m *Model) focusPane(i int) tea.Cmd {
+	if i == m.focus {
+		return nil
+	}
+	m.saveDraft()
+	m.panes[m.focus] = m.savePane()
+	m.focus = i
+	return m.enterPane()
+}
+
+// enterPane makes the focused pane's chat the active one and returns the
+// command that marks it as read.
+func (m *Model) enterPane() tea.Cmd {
+	m.loadPane(m.panes[m.focus])
+	m.panes[m.focus] = paneState{} // 焦点窗格的状态在 Model 的字段中
+	if m.activeChat != "" {
+		m.loadInput()
+	} else {
+		m.composer.Reset()
+		m.history, m.historyPos = nil, 0
+	}
+	m.fitComposer()
+	m.selection = selection{width: m.selection.width, height: m.selection.height}
+	m.setReply(nil)
+	m.appState.SetActiveChat(m.activeChat)
+	m.sidebar.moveTo(m.activeChat)
+	return m.markActiveRead()
+}
+
+// canSplit reports why no more panes fit, if they do not.
+func (m *Model) canSplit(vertical bool) error {
+	n := len(m.panes) + 1
+	width, height := m.messageArea()
+	switch {
+	case n > maxPanes:
+		return fmt.Errorf("at most %d panes", maxPanes)
+	case vertical && (width-(n-1))/n < minPaneWidth, !vertical && height/n < minPaneHeight:
+		return errors.New("not enough room for another pane")
+	}
+	return nil
+}
+
+// splitPane opens an empty pane after the focused one and focuses it. All
+// panes are laid out the same way, so vertical changes the existing ones too.
+func (m *Model) splitPane(vertical bool) error {
+	if err := m.canSplit(vertical); err != nil {
+		return err
+	}
+	m.saveDraft()
+	m.panes[m.focus] = m.savePane()
+	m.panes = slices.Insert(m.panes, m.focus+1, paneState{headerText: "No Active Chat"})
+	m.splitVertical = vertical
+	m.focus++
+	m.enterPane() // 新窗格中没有聊天，不需要标记已读
+	m.resize()
+	return nil
+}
+
+// closePane closes the focused pane and focuses the next one.
+func (m *Model) closePane() (tea.Cmd, error) {
+	if len(m.panes) == 1 {
+		return nil, errors.New("there is only one pane")
+	}
+	m.saveDraft()
+	m.panes = slices.Delete(m.panes, m.focus, m.focus+1)
+	m.focus = min(m.focus, len(m.panes)-1)
+	cmd := m.enterPane()
+	m.resize()
+	return cmd, nil
+}
+
+// paneSize returns the viewport size of pane i in a message area of the given size.
+func (m *Model) paneSize(i, width, height int) (int, int) {
+	n := len(m.panes)
+	if n == 1 {
+		return width, height
+	}
+	// 每个窗格有一行标题，左右排列时窗格之间还有一列分隔线；除不尽的给最后一个窗格
+	if m.splitVertical {
+		w := (width - (n - 1)) / n
+		if i == n-1 {
+			w = width - (n-1)*(w+1)
+		}
+		return w, height - 1
+	}
+	h := height / n
+	if i == n-1 {
+		h = height - (n-1)*h
+	}
+	return width, h - 1
+}
+
+// panesView renders the message panes. x is the screen column of the message
+// area, used to draw images over the focused pane unless images is false.
+func (m *Model) panesView(x int, images bool) string {
+	focused := func(x int) string {
+		if !images {
+			return m.viewport.View()
+		}
+		return m.images.overlay(m.viewport.View(), m.viewport.YOffset, m.viewport.Height, x)
+	}
+	if len(m.panes) == 1 {
+		return focused(x)
+	}
+	var views []string
+	for i := range m.panes {
+		vp, header := &m.panes[i].viewport, m.panes[i].headerText
+		title := m.theme.hint.Padding(0, 1)
+		if i == m.focus {
+			vp, header, title = &m.viewport, m.headerText, m.theme.header
+		}
+		if m.splitVertical && i > 0 {
+			views = append(views, m.theme.hint.Render(strings.TrimSuffix(strings.Repeat("│\n", vp.Height+1), "\n")))
+			x++
+		}
+		view := vp.View()
+		if i == m.focus {
+			view = focused(x)
+		}
+		title = title.Width(vp.Width).MaxWidth(vp.Width)
+		header = runewidth.Truncate(header, max(vp.Width-title.GetHorizontalPadding(), 0), "…")
+		views = append(views, title.Render(header)+"\n"+view)
+		x += vp.Width
+	}
+	if m.splitVertical {
+		return lipgloss.JoinHorizontal(lipgloss.Top, views...)
+	}
+	return strings.Join(views, "\n")
+}
+
+func (m *Model) splitCommand(args string) (tea.Cmd, error) {
+	return m.splitWith(false, args)
+}
+
+func (m *Model) vsplitCommand(args string) (tea.Cmd, error) {
+	return m.splitWith(true, args)
+}
+
+// splitWith checks a /split or /vsplit command and asks for the new pane.
+func (m *Model) splitWith(vertical bool, args string) (tea.Cmd, error) {
+	if err := m.canSplit(vertical); err != nil {
+		return nil, err
+	}
+	var chat adapter.ChatInfo
+	if args != "" {
+		c, ok := m.findChat(args)
+		if !ok {
+			return nil, fmt.Errorf("no chat matches %q", args)
+		}
+		if m.paneOf(c.ID) >= 0 {
+			return nil, fmt.Errorf("%s is already shown", args)
+		}
+		chat = c
+	}
+	return func() tea.Msg { return splitPaneMsg{vertical: vertical, chat: chat} }, nil
+}
+
+func (m *Model) closeCommand(args string) (tea.Cmd, error) {
+	if len(m.panes) == 1 {
+		return nil, errors.New("there is only one pane")
+	}
+	return func() tea.Msg { return closePaneMsg{} }, nil
+}
+
+// handleSplitPane opens the pane asked for by splitWith.
+func (m *Model) handleSplitPane(msg splitPaneMsg) tea.Cmd {
+	if err := m.splitPane(msg.vertical); err != nil {
+		m.statusText = "Error splitting: " + err.Error()
+		return nil
+	}
+	if msg.chat.ID == "" {
+		m.statusText = fmt.Sprintf("New pane. Open a chat with /join or %s.", firstKey(m.keys.Finder))
+		return nil
+	}
+	return m.openChat(msg.chat)
+}
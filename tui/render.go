@@ -0,0 +1,442 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/mattn/go-runewidth"
+	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// markdownCacheSize bounds the rendered markdown kept between redraws.
+const markdownCacheSize = 64
+
+// quote is the message a reply refers to, loaded from storage or the bot.
+type quote struct {
+	msg     *adapter.Message // nil 表示还在加载或者找不到
+	loading bool
+	failed  bool // 存储和 get_msg 都没有找到，不再重试
+}
+
+// quoteLoadedMsg carries the original of a replied-to message.
+type quoteLoadedMsg struct {
+	key string
+	msg *adapter.Message
+	err error
+}
+
+// bodyBuilder joins inline segments and block segments (images, quotes, cards)
+// that need lines of their own.
+type bodyBuilder struct {
+	b          strings.Builder
+	afterBlock bool
+}
+
+func (bb *bodyBuilder) inline(s string) {
+	if bb.afterBlock {
+		// 块后面紧跟的空白只是 QQ 客户端插入的分隔
+		if s = strings.TrimLeft(s, " \n"); s == "" {
+			return
+		}
+	}
+	bb.b.WriteString(s)
+	bb.afterBlock = false
+}
+
+func (bb *bodyBuilder) block(s string) {
+	if bb.b.Len() > 0 && !strings.HasSuffix(bb.b.String(), "\n") {
+		bb.b.WriteString("\n")
+	}
+	bb.b.WriteString(s + "\n")
+	bb.afterBlock = true
+}
+
+func (bb *bodyBuilder) String() string {
+	return strings.TrimSuffix(bb.b.String(), "\n")
+}
+
+// renderBody draws the content of msg for the viewport. line is the content
+// line the message starts on, used to decide which images to download first.
+func (m *Model) renderBody(msg adapter.Message, line int) (string, []*imageRender) {
+	width := max(m.viewport.Width-6, 10)
+	var (
+		bb      bodyBuilder
+		renders []*imageRender
+	)
+	for _, seg := range adapter.ParseCQ(msg.Content) {
+		switch seg.Type {
+		case "text":
+			bb.inline(seg.Text())
+		case "reply":
+			bb.block(m.renderQuote(msg.ChatID, seg.Data["id"], width))
+		case "at":
+			bb.inline(m.theme.mention.Render(segmentText(seg, m.names)))
+		case "face":
+			bb.inline(m.theme.face.Render(faceLabel(seg.Data["id"])))
+		case "image":
+			url := imageURL(seg)
+			r := (*imageRender)(nil)
+			if m.images.enabled && url != "" {
+				m.images.wanted[url] = line
+				r = m.images.render(url, width)
+			}
+			if r == nil {
+				bb.inline(m.theme.chip.Render(imageLabel(seg)))
+				continue
+			}
+			bb.block(strings.Join(r.lines, "\n"))
+			renders = append(renders, r)
+		case "json":
+			bb.block(renderCard(m.theme, summarizeCard(seg.Data["data"]), width))
+		case "share":
+			bb.block(renderCard(m.theme, card{source: "分享", title: seg.Data["title"], desc: seg.Data["content"], url: seg.Data["url"]}, width))
+		case "markdown":
+			bb.block(m.renderMarkdown(markdownContent(seg.Data["content"]), width))
+		default:
+			bb.inline(m.theme.chip.Render(segmentChip(seg)))
+		}
+	}
+	return wrapText(bb.String(), width), renders
+}
+
+// renderQuote draws the one-line preview of a replied-to message, loading it if needed.
+func (m *Model) renderQuote(chatID, messageID string, width int) string {
+	orig := m.findQuote(chatID, messageID)
+	text := "[回复]"
+	if orig != nil {
+		text = orig.SenderName + ": " + strings.Join(strings.Fields(plainText(orig.Content, m.names)), " ")
+	}
+	return m.theme.quote.Render(runewidth.Truncate(text, width-m.theme.quote.GetHorizontalFrameSize(), "…"))
+}
+
+// findQuote looks for a replied-to message among the loaded messages and
+// earlier lookups, and queues a lookup for fetchQuotes when it is not found.
+func (m *Model) findQuote(chatID, messageID string) *adapter.Message {
+	if messageID == "" {
+		return nil
+	}
+	for i := range m.messages {
+		if m.messages[i].MessageID == messageID {
+			return &m.messages[i]
+		}
+	}
+	key := chatID + ":" + messageID
+	if q, ok := m.quotes[key]; ok {
+		return q.msg
+	}
+	m.quotes[key] = &quote{}
+	return nil
+}
+
+// fetchQuotes starts loading the replied-to messages that are not known yet.
+func (m *Model) fetchQuotes() tea.Cmd {
+	var cmds []tea.Cmd
+	for key, q := range m.quotes {
+		if q.msg != nil || q.loading || q.failed {
+			continue
+		}
+		q.loading = true
+		cmds = append(cmds, loadQuote(m.store, m.bot, key))
+	}
+	return tea.Batch(cmds...)
+}
+
+// loadQuote is a command that looks up a message in storage, then asks the bot for it.
+func loadQuote(store storage.Backend, bot adapter.BotAdapter, key string) tea.Cmd {
+	chatID, messageID, _ := strings.Cut(key, ":")
+	return func() tea.Msg {
+		msg, err := store.FindMessage(chatID, messageID)
+		if err == nil && msg == nil {
+			var orig adapter.Message
+			if orig, err = bot.GetMessage(messageID); err == nil {
+				msg = &orig
+			}
+		}
+		return quoteLoadedMsg{key: key, msg: msg, err: err}
+	}
+}
+
+// renderMarkdown renders markdown for the terminal, caching the result per width.
+func (m *Model) renderMarkdown(src string, width int) string {
+	key := strconv.Itoa(width) + "\x00" + src
+	if out, ok := m.markdown[key]; ok {
+		return out
+	}
+	out := src
+	r, err := glamour.NewTermRenderer(glamour.WithStyles(markdownStyle(m.theme.markdown)), glamour.WithWordWrap(width))
+	if err == nil {
+		if rendered, err := r.Render(src); err == nil {
+			// glamour 把每行用带样式的空格补到换行宽度，去掉以免右对齐的消息被撑满
+			lines := strings.Split(rendered, "\n")
+			for i, line := range lines {
+				if line = trailingBlankRegex.ReplaceAllString(line, ""); line != "" {
+					line += ansiReset
+				}
+				lines[i] = line
+			}
+			out = strings.Trim(strings.Join(lines, "\n"), "\n")
+		}
+	}
+	if len(m.markdown) >= markdownCacheSize {
+		clear(m.markdown)
+	}
+	m.markdown[key] = out
+	return out
+}
+
+const ansiReset = "\x1b[0m"
+
+// trailingBlankRegex matches spaces and SGR sequences at the end of a line.
+var trailingBlankRegex = regexp.MustCompile(`(?:\x1b\[[0-9;]*m| )+$`)
+
+// markdownStyle is glamour's dark or light style without the document margin,
+// since messages are already indented.
+func markdownStyle(name string) ansi.StyleConfig {
+	style := styles.DarkStyleConfig
+	if name == "light" {
+		style = styles.LightStyleConfig
+	}
+	var margin uint
+	style.Document.Margin = &margin
+	return style
+}
+
+// markdownContent unwraps markdown that NapCat sends as {"content": "..."}.
+func markdownContent(s string) string {
+	var wrapped struct {
+		Content string `json:"content"`
+	}
+	if strings.HasPrefix(strings.TrimSpace(s), "{") && json.Unmarshal([]byte(s), &wrapped) == nil && wrapped.Content != "" {
+		return wrapped.Content
+	}
+	return s
+}
+
+// card is the summary of a mini-app or link share.
+type card struct {
+	source, title, desc, url string
+}
+
+// summarizeCard extracts the title, description and link of a [CQ:json] card.
+// Cards differ per app, so this looks at the first object under "meta" for the
+// fields most apps use, and falls back to the "prompt" text.
+func summarizeCard(data string) card {
+	var raw struct {
+		Prompt string                            `json:"prompt"`
+		Meta   map[string]map[string]interface{} `json:"meta"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return card{title: "[卡片]"}
+	}
+	c := card{title: raw.Prompt}
+	for kind, obj := range raw.Meta {
+		str := func(keys ...string) string {
+			for _, k := range keys {
+				if v, ok := obj[k].(string); ok && v != "" {
+					return v
+				}
+			}
+			return ""
+		}
+		c.url = str("qqdocurl", "jumpUrl", "url")
+		c.source = str("tag", "source")
+		if kind == "detail_1" {
+			// 小程序的 title 是应用名，desc 才是内容标题
+			c.source, c.title = str("title"), str("desc")
+		} else {
+			c.title, c.desc = str("title"), str("desc")
+		}
+		if c.title == "" {
+			c.title = raw.Prompt
+		}
+		break
+	}
+	return c
+}
+
+func renderCard(t *theme, c card, width int) string {
+	inner := width - t.card.GetHorizontalFrameSize()
+	var lines []string
+	if c.source != "" {
+		lines = append(lines, t.cardSource.Render(runewidth.Truncate(c.source, inner, "…")))
+	}
+	if c.title != "" {
+		lines = append(lines, t.cardTitle.Render(runewidth.Truncate(c.title, inner, "…")))
+	}
+	if c.desc != "" && c.desc != c.title {
+		lines = append(lines, runewidth.Truncate(strings.Join(strings.Fields(c.desc), " "), inner, "…"))
+	}
+	if c.url != "" {
+		lines = append(lines, t.link.Render(runewidth.Truncate(c.url, inner, "…")))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "[卡片]")
+	}
+	return t.card.Render(strings.Join(lines, "\n"))
+}
+
+// imageLabel is shown for images that are not drawn, e.g. "[动画表情]".
+func imageLabel(seg adapter.Segment) string {
+	if s := strings.TrimSpace(seg.Data["summary"]); s != "" && strings.HasPrefix(s, "[") {
+		return s
+	}
+	return "[图片]"
+}
+
+// segmentChip labels segments that have no richer rendering, with the file size where known.
+func segmentChip(seg adapter.Segment) string {
+	size := ""
+	for _, k := range []string{"file_size", "size"} {
+		if n, err := strconv.ParseInt(seg.Data[k], 10, 64); err == nil && n > 0 {
+			size = " · " + formatSize(n)
+			break
+		}
+	}
+	switch seg.Type {
+	case "file":
+		name := seg.Data["name"]
+		if name == "" {
+			name = seg.Data["file"]
+		}
+		return strings.TrimSpace("[文件] " + name + size)
+	case "video":
+		return "[视频]" + size
+	case "record":
+		return "[语音]"
+	case "forward":
+		return "[聊天记录]"
+	case "mface":
+		if s := seg.Data["summary"]; s != "" {
+			return s
+		}
+		return "[商城表情]"
+	case "poke":
+		return "[戳一戳]"
+	case "dice":
+		return "[骰子] " + seg.Data["result"]
+	case "rps":
+		return "[猜拳] " + map[string]string{"1": "布", "2": "剪刀", "3": "石头"}[seg.Data["result"]]
+	case "location":
+		return strings.TrimSpace("[位置] " + seg.Data["title"])
+	case "music":
+		return "[音乐]"
+	case "contact":
+		return "[名片]"
+	case "xml":
+		return "[卡片]"
+	}
+	return "[" + seg.Type + "]"
+}
+
+// formatSize formats a byte count as KiB/MiB/GiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// faceLabel returns the emoji or "[/name]" of a QQ face ID.
+func faceLabel(id string) string {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return "[表情]"
+	}
+	if e, ok := faceEmoji[n]; ok {
+		return e
+	}
+	if name, ok := faceNames[n]; ok {
+		return "[/" + name + "]"
+	}
+	return "[表情" + id + "]"
+}
+
+// faceEmoji maps QQ faces that have a close single-codepoint emoji.
+var faceEmoji = map[int]string{
+	2: "😍", 5: "😢", 9: "😭", 11: "😡", 12: "😜", 13: "😁", 14: "🙂", 20: "🤭", 21: "😊",
+	26: "😱", 32: "❓", 39: "👋", 53: "🎂", 59: "💩", 60: "☕", 63: "🌹", 67: "💔", 69: "🎁",
+	74: "🌞", 75: "🌙", 76: "👍", 77: "👎", 78: "🤝", 79: "✌", 89: "🍉", 99: "👏", 113: "🍺",
+	114: "🏀", 124: "👌", 179: "🐶", 182: "😂", 264: "🤦", 271: "🍉", 277: "🐶",
+}
+
+// faceNames maps QQ face IDs to the names the QQ client shows.
+var faceNames = map[int]string{
+	0: "惊讶", 1: "撇嘴", 2: "色", 3: "发呆", 4: "得意", 5: "流泪", 6: "害羞", 7: "闭嘴", 8: "睡",
+	9: "大哭", 10: "尴尬", 11: "发怒", 12: "调皮", 13: "呲牙", 14: "微笑", 15: "难过", 16: "酷",
+	18: "抓狂", 19: "吐", 20: "偷笑", 21: "可爱", 22: "白眼", 23: "傲慢", 24: "饥饿", 25: "困",
+	26: "惊恐", 27: "流汗", 28: "憨笑", 29: "悠闲", 30: "奋斗", 31: "咒骂", 32: "疑问", 33: "嘘",
+	34: "晕", 35: "折磨", 36: "衰", 37: "骷髅", 38: "敲打", 39: "再见", 41: "发抖", 42: "爱情",
+	43: "跳跳", 46: "猪头", 49: "拥抱", 53: "蛋糕", 54: "闪电", 55: "炸弹", 56: "刀", 57: "足球",
+	59: "便便", 60: "咖啡", 61: "饭", 63: "玫瑰", 64: "凋谢", 66: "爱心", 67: "心碎", 69: "礼物",
+	74: "太阳", 75: "月亮", 76: "赞", 77: "踩", 78: "握手", 79: "胜利", 85: "飞吻", 86: "怄火",
+	89: "西瓜", 96: "冷汗", 97: "擦汗", 98: "抠鼻", 99: "鼓掌", 100: "糗大了", 101: "坏笑",
+	102: "左哼哼", 103: "右哼哼", 104: "哈欠", 105: "鄙视", 106: "委屈", 107: "快哭了", 108: "阴险",
+	109: "左亲亲", 110: "吓", 111: "可怜", 112: "菜刀", 113: "啤酒", 114: "篮球", 115: "乒乓",
+	116: "示爱", 117: "瓢虫", 118: "抱拳", 119: "勾引", 120: "拳头", 121: "差劲", 122: "爱你",
+	123: "NO", 124: "OK", 125: "转圈", 129: "挥手", 144: "喝彩", 146: "爆筋", 147: "棒棒糖",
+	171: "茶", 172: "眨眼睛", 173: "泪奔", 174: "无奈", 175: "卖萌", 176: "小纠结", 177: "喷血",
+	178: "斜眼笑", 179: "doge", 180: "惊喜", 181: "骚扰", 182: "笑哭", 183: "我最美", 187: "幽灵",
+	201: "点赞", 212: "托腮", 262: "脑阔疼", 263: "沧桑", 264: "捂脸", 265: "辣眼睛", 266: "哦哟",
+	267: "头秃", 268: "问号脸", 269: "暗中观察", 270: "emm", 271: "吃瓜", 272: "呵呵哒", 273: "我酸了",
+	277: "汪汪", 281: "无眼笑", 282: "敬礼", 284: "面无表情", 285: "摸鱼", 287: "哦", 289: "睁眼",
+	293: "摸锦鲤", 294: "期待", 297: "拜谢", 298: "元宝", 299: "牛啊", 305: "右亲亲", 306: "牛气冲天",
+	307: "喵喵", 314: "仔细分析", 315: "加油", 318: "崇拜", 319: "比心", 320: "庆祝", 322: "拒绝",
+	324: "吃糖", 326: "生气",
+}
+
+// plainText renders content as a single string without styling, e.g. for
+// copying, quoting and reply previews.
+func plainText(content string, names map[string]string) string {
+	var b strings.Builder
+	for _, seg := range adapter.ParseCQ(content) {
+		b.WriteString(segmentText(seg, names))
+	}
+	return b.String()
+}
+
+// segmentText is the plain text form of one segment.
+func segmentText(seg adapter.Segment, names map[string]string) string {
+	switch seg.Type {
+	case "text":
+		return seg.Text()
+	case "reply":
+		return ""
+	case "at":
+		qq := seg.Data["qq"]
+		switch {
+		case qq == "all":
+			return "@全体成员"
+		case seg.Data["name"] != "":
+			return "@" + seg.Data["name"]
+		case names[qq] != "":
+			return "@" + names[qq]
+		}
+		return "@" + qq
+	case "face":
+		return faceLabel(seg.Data["id"])
+	case "image":
+		return imageLabel(seg)
+	case "json":
+		c := summarizeCard(seg.Data["data"])
+		return strings.TrimSpace("[卡片] " + c.title + " " + c.url)
+	case "share":
+		return strings.TrimSpace("[分享] " + seg.Data["title"] + " " + seg.Data["url"])
+	case "markdown":
+		return markdownContent(seg.Data["content"])
+	}
+	return segmentChip(seg)
+}
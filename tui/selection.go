@@ -0,0 +1,454 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+var urlRegex = regexp.MustCompile(`https?://[^\s\]\[<>"']+`)
+
+// selection is the vim-style mode for acting on a single message, entered with Alt+V.
+type selection struct {
+	active bool
+	cursor int // m.messages 中选中的消息
+
+	menuOpen   bool
+	menuCursor int
+	actions    []messageAction // 选中消息可用的操作
+
+	popup         *viewport.Model // 原始内容、转发、资料等弹窗，nil 表示没有打开
+	popupTitle    string
+	width, height int
+}
+
+// messageAction is an entry of the per-message action menu.
+type messageAction struct {
+	key       string
+	label     string
+	available func(m *Model, msg adapter.Message) bool
+	run       func(m *Model, msg adapter.Message) tea.Cmd
+}
+
+// actionResultMsg carries the result of an action that talks to the bot.
+type actionResultMsg struct {
+	action    string // "recall"、"forward"、"profile" 或 "open"
+	messageID string
+	title     string
+	text      string
+	err       error
+}
+
+var messageActions = []messageAction{
+	{"r", "Reply", func(_ *Model, msg adapter.Message) bool { return msg.MessageID != "" }, (*Model).replyToMessage},
+	{"q", "Quote", nil, (*Model).quoteMessage},
+	{"y", "Copy text", nil, func(m *Model, msg adapter.Message) tea.Cmd {
+		m.copyToClipboard(plainText(msg.Content, m.names), "message text")
+		return nil
+	}},
+	{"Y", "Copy sender ID", func(_ *Model, msg adapter.Message) bool { return msg.SenderID != "" }, func(m *Model, msg adapter.Message) tea.Cmd {
+		m.copyToClipboard(msg.SenderID, "sender ID")
+		return nil
+	}},
+	{"d", "Recall", func(m *Model, msg adapter.Message) bool { return m.isSelf(msg) && msg.MessageID != "" }, (*Model).recallMessage},
+	{"v", "View raw", nil, (*Model).viewRaw},
+	{"o", "Open image/link", func(_ *Model, msg adapter.Message) bool { return firstURL(msg.Content) != "" }, func(m *Model, msg adapter.Message) tea.Cmd {
+		return openURL(firstURL(msg.Content))
+	}},
+	{"f", "View forward", func(_ *Model, msg adapter.Message) bool { return forwardID(msg.Content) != "" }, (*Model).viewForward},
+	{"p", "Sender profile", func(_ *Model, msg adapter.Message) bool { return msg.SenderID != "" }, (*Model).viewProfile},
+}
+
+// actionsFor returns the actions that apply to msg.
+func (m *Model) actionsFor(msg adapter.Message) []messageAction {
+	var actions []messageAction
+	for _, a := range messageActions {
+		if a.available == nil || a.available(m, msg) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// startSelection enters selection mode on the latest message.
+func (m *Model) startSelection() {
+	if m.showStats || len(m.messages) == 0 {
+		m.statusText = "No messages to select."
+		return
+	}
+	m.selection.active = true
+	m.selection.cursor = len(m.messages) - 1
+	m.statusText = "j/k move · Enter actions · r reply · q quote · y copy · Esc exit"
+	m.updateViewportContent()
+	m.scrollToSelection()
+}
+
+// stopSelection leaves selection mode.
+func (m *Model) stopSelection() {
+	m.selection = selection{width: m.selection.width, height: m.selection.height}
+	m.statusText = ""
+	m.updateViewportContent()
+}
+
+// updateSelection handles keys while a message is selected.
+func (m *Model) updateSelection(msg tea.KeyMsg) tea.Cmd {
+	s := &m.selection
+	if s.cursor >= len(m.messages) {
+		m.stopSelection()
+		return nil
+	}
+	selected := m.messages[s.cursor]
+
+	if s.menuOpen {
+		switch msg.String() {
+		case "esc":
+			s.menuOpen = false
+		case "up", "k":
+			s.menuCursor = max(s.menuCursor-1, 0)
+		case "down", "j":
+			s.menuCursor = min(s.menuCursor+1, len(s.actions)-1)
+		case "enter", " ":
+			if s.menuCursor < len(s.actions) {
+				s.menuOpen = false
+				return s.actions[s.menuCursor].run(m, selected)
+			}
+		default:
+			return m.runAction(msg.String(), selected)
+		}
+		return nil
+	}
+
+	if key.Matches(msg, m.keys.Select) {
+		m.stopSelection()
+		return nil
+	}
+	switch msg.String() {
+	case "esc", "i":
+		m.stopSelection()
+		return nil
+	case "up", "k":
+		m.moveSelection(s.cursor - 1)
+	case "down", "j":
+		m.moveSelection(s.cursor + 1)
+	case "home", "g":
+		m.moveSelection(0)
+	case "end", "G":
+		m.moveSelection(len(m.messages) - 1)
+	case "pgup", "pgdown":
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return cmd
+	case "enter", " ":
+		s.actions = m.actionsFor(selected)
+		s.menuOpen = true
+		s.menuCursor = 0
+	default:
+		return m.runAction(msg.String(), selected)
+	}
+	return nil
+}
+
+// updatePopup handles keys while a popup is open, in selection mode or after a command.
+func (m *Model) updatePopup(msg tea.KeyMsg) tea.Cmd {
+	s := &m.selection
+	switch msg.String() {
+	case "esc", "q", "enter":
+		s.popup = nil
+		return nil
+	}
+	var cmd tea.Cmd
+	*s.popup, cmd = s.popup.Update(msg)
+	return cmd
+}
+
+// runAction runs the action bound to key, if it applies to msg.
+func (m *Model) runAction(key string, msg adapter.Message) tea.Cmd {
+	for _, a := range m.actionsFor(msg) {
+		if a.key == key {
+			m.selection.menuOpen = false
+			return a.run(m, msg)
+		}
+	}
+	return nil
+}
+
+func (m *Model) moveSelection(cursor int) {
+	m.selection.cursor = min(max(cursor, 0), len(m.messages)-1)
+	m.updateViewportContent()
+	m.scrollToSelection()
+}
+
+// scrollToSelection scrolls the viewport so the selected message is visible.
+func (m *Model) scrollToSelection() {
+	cur := m.selection.cursor
+	if cur >= len(m.msgLines) {
+		return
+	}
+	start := m.msgLines[cur]
+	end := m.viewport.TotalLineCount()
+	if cur+1 < len(m.msgLines) {
+		end = m.msgLines[cur+1]
+	}
+	switch {
+	case start < m.viewport.YOffset || end-start > m.viewport.Height:
+		m.viewport.SetYOffset(start)
+	case end > m.viewport.YOffset+m.viewport.Height:
+		m.viewport.SetYOffset(end - m.viewport.Height)
+	}
+}
+
+// selectedStyle marks the selected message with a bar on the side it is aligned to.
+func selectedStyle(t *theme, style lipgloss.Style, self bool, width int) lipgloss.Style {
+	if self {
+		return style.PaddingRight(1).BorderStyle(lipgloss.ThickBorder()).BorderRight(true).
+			BorderForeground(t.accent).Width(width - 1)
+	}
+	return style.PaddingLeft(1).BorderStyle(lipgloss.ThickBorder()).BorderLeft(true).
+		BorderForeground(t.accent)
+}
+
+// resize keeps popups inside the terminal.
+func (s *selection) resize(width, height int) {
+	s.width, s.height = width, height
+	if s.popup != nil {
+		s.popup.Width, s.popup.Height = popupSize(width, height)
+	}
+}
+
+func popupSize(width, height int) (int, int) {
+	return max(min(width-6, 100), 20), max(height-10, 3)
+}
+
+// openPopup shows text in a scrollable box over the messages.
+func (s *selection) openPopup(title, text string) {
+	w, h := popupSize(s.width, s.height)
+	vp := viewport.New(w, h)
+	vp.SetContent(lipgloss.NewStyle().Width(w).Render(text))
+	if vp.TotalLineCount() < h {
+		vp.Height = max(vp.TotalLineCount(), 1)
+	}
+	s.popup = &vp
+	s.popupTitle = title
+}
+
+// overlay renders the open menu or popup, or "" if neither is open.
+func (s *selection) overlay(t *theme, width int) string {
+	switch {
+	case s.popup != nil:
+		footer := t.hint.Render("↑/↓ scroll · Esc close")
+		return t.menu.Render(t.popupTitle.Render(s.popupTitle) + "\n\n" + s.popup.View() + "\n\n" + footer)
+	case s.menuOpen:
+		var lines []string
+		for i, a := range s.actions {
+			line := t.menuKey.Render(a.key) + "  " + a.label
+			if i == s.menuCursor {
+				line = t.menuCursor.Render(line)
+			}
+			lines = append(lines, line)
+		}
+		lines = append(lines, "", t.hint.Render("Enter run · Esc cancel"))
+		return t.menu.Render(strings.Join(lines, "\n"))
+	}
+	return ""
+}
+
+func (m *Model) replyToMessage(msg adapter.Message) tea.Cmd {
+	m.stopSelection()
+	m.setReply(&msg)
+	m.viewport.GotoBottom()
+	return nil
+}
+
+// quoteMessage puts the message, prefixed with "> ", in front of the composer text.
+func (m *Model) quoteMessage(msg adapter.Message) tea.Cmd {
+	var quote strings.Builder
+	for i, line := range strings.Split(strings.TrimSpace(plainText(msg.Content, m.names)), "\n") {
+		if i == 0 {
+			line = msg.SenderName + ": " + line
+		}
+		quote.WriteString("> " + line + "\n")
+	}
+	m.stopSelection()
+	m.composer.SetValue(quote.String() + m.composer.Value())
+	m.fitComposer()
+	m.viewport.GotoBottom()
+	return nil
+}
+
+// copyToClipboard copies text to the system clipboard. Without one (e.g. over
+// SSH) it falls back to the OSC 52 escape sequence understood by most terminals.
+func (m *Model) copyToClipboard(text, what string) {
+	if err := clipboard.WriteAll(text); err != nil {
+		termenv.Copy(text)
+	}
+	m.statusText = "Copied " + what + "."
+}
+
+func (m *Model) recallMessage(msg adapter.Message) tea.Cmd {
+	m.statusText = "Recalling message..."
+	bot := m.bot
+	return func() tea.Msg {
+		return actionResultMsg{action: "recall", messageID: msg.MessageID, err: bot.RecallMessage(msg.MessageID)}
+	}
+}
+
+func (m *Model) viewRaw(msg adapter.Message) tea.Cmd {
+	raw, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		raw = []byte(err.Error())
+	}
+	m.selection.openPopup("Raw message", msg.Content+"\n\n"+string(raw))
+	return nil
+}
+
+func (m *Model) viewForward(msg adapter.Message) tea.Cmd {
+	id := forwardID(msg.Content)
+	m.statusText = "Loading forwarded messages..."
+	bot, t := m.bot, m.theme
+	return func() tea.Msg {
+		messages, err := bot.GetForwardMessages(id)
+		var b strings.Builder
+		for _, fm := range messages {
+			fmt.Fprintf(&b, "%s  %s\n%s\n\n", t.senderStyle(fm.SenderID, false).Render(fm.SenderName), fm.Time.Format("01-02 15:04"), plainText(fm.Content, nil))
+		}
+		return actionResultMsg{action: "forward", title: fmt.Sprintf("Forwarded messages (%d)", len(messages)), text: strings.TrimSpace(b.String()), err: err}
+	}
+}
+
+func (m *Model) viewProfile(msg adapter.Message) tea.Cmd {
+	m.statusText = "Loading profile..."
+	bot := m.bot
+	return func() tea.Msg {
+		p, err := bot.GetUserProfile(msg.ChatID, msg.ChatType, msg.SenderID)
+		var b strings.Builder
+		field := func(name, value string) {
+			if value != "" && value != "0" {
+				fmt.Fprintf(&b, "%-12s %s\n", name, value)
+			}
+		}
+		field("QQ", p.UserID)
+		field("Nickname", p.Nickname)
+		field("Card", p.Card)
+		field("Role", p.Role)
+		field("Title", p.Title)
+		field("Sex", p.Sex)
+		field("Age", fmt.Sprint(p.Age))
+		field("Level", fmt.Sprint(p.Level))
+		field("Sign", p.Sign)
+		if !p.JoinTime.IsZero() {
+			field("Joined", p.JoinTime.Format("2006-01-02"))
+		}
+		if !p.LastSentTime.IsZero() {
+			field("Last spoke", p.LastSentTime.Format("2006-01-02 15:04"))
+		}
+		return actionResultMsg{action: "profile", title: "Profile of " + msg.SenderName, text: strings.TrimSpace(b.String()), err: err}
+	}
+}
+
+// handleActionResult shows the result of an action that talked to the bot.
+func (m *Model) handleActionResult(msg actionResultMsg) tea.Cmd {
+	if msg.err != nil && msg.text == "" {
+		m.statusText = fmt.Sprintf("Error: %s failed: %v", msg.action, msg.err)
+		return nil
+	}
+	m.statusText = ""
+	switch msg.action {
+	case "recall":
+		for i, sent := range m.messages {
+			if sent.MessageID == msg.messageID {
+				m.messages = append(m.messages[:i], m.messages[i+1:]...)
+				break
+			}
+		}
+		if m.selection.active {
+			m.moveSelection(m.selection.cursor)
+		} else {
+			m.updateViewportContent()
+		}
+		m.statusText = "Message recalled."
+	case "open":
+		m.statusText = "Opened " + msg.text
+	default:
+		if msg.err != nil {
+			// 群成员信息获取失败时仍有部分资料可以显示
+			m.statusText = fmt.Sprintf("Error: %s incomplete: %v", msg.action, msg.err)
+		}
+		if m.selection.active {
+			m.selection.openPopup(msg.title, msg.text)
+		}
+	}
+	return nil
+}
+
+// openURL opens url with the default application of the system.
+func openURL(url string) tea.Cmd {
+	return func() tea.Msg {
+		var cmd *exec.Cmd
+		switch runtime.GOOS {
+		case "darwin":
+			cmd = exec.Command("open", url)
+		case "windows":
+			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+		default:
+			cmd = exec.Command("xdg-open", url)
+		}
+		if err := cmd.Start(); err != nil {
+			return actionResultMsg{action: "open", text: url, err: err}
+		}
+		// 浏览器一般由打开程序转交后台运行，这里只需要回收进程
+		go cmd.Wait()
+		return actionResultMsg{action: "open", text: url}
+	}
+}
+
+// firstURL returns the first image or link in content.
+func firstURL(content string) string {
+	for _, seg := range adapter.ParseCQ(content) {
+		switch seg.Type {
+		case "text":
+			if v := urlRegex.FindString(seg.Text()); v != "" {
+				return v
+			}
+		case "image", "video", "record", "file":
+			for _, k := range []string{"url", "file"} {
+				if v := seg.Data[k]; webURL(v) {
+					return v
+				}
+			}
+		case "share":
+			// 分享链接由对方填写，不能把任意内容交给系统打开
+			if v := seg.Data["url"]; webURL(v) {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// webURL reports whether s is an absolute http or https address.
+func webURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// forwardID returns the id of the merged forward in content, if any.
+func forwardID(content string) string {
+	for _, seg := range adapter.ParseCQ(content) {
+		if seg.Type == "forward" {
+			return seg.Data["id"]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/storage"
+)
+
+// sidebarWidth is the width of the chat list including its border.
+const sidebarWidth = 30
+
+// sidebar is the chat list shown on the left of the screen. Every chat takes
+// two lines: its name with an unread badge, and a preview of the latest message.
+type sidebar struct {
+	chats    []adapter.ChatInfo
+	activity map[string]time.Time // chatID -> 最后一条消息的时间
+	counts   map[string]int64     // chatID -> 保存的消息数，用于快速切换时的排序
+	cursor   int
+	offset   int // 第一个可见的聊天
+	height   int
+}
+
+// sidebarLoadedMsg carries the chat list with previews loaded from storage.
+type sidebarLoadedMsg struct {
+	chats     []adapter.ChatInfo
+	summaries []storage.ChatSummary
+	err       error
+}
+
+// setChats replaces the chat list, filling previews and activity from the stored summaries.
+func (s *sidebar) setChats(chats []adapter.ChatInfo, summaries []storage.ChatSummary) {
+	selected := s.selectedID()
+	s.activity = make(map[string]time.Time, len(summaries))
+	s.counts = make(map[string]int64, len(summaries))
+	latest := make(map[string]string, len(summaries))
+	for _, c := range summaries {
+		s.activity[c.ChatID] = c.Latest.Time
+		s.counts[c.ChatID] = c.Messages
+		latest[c.ChatID] = c.Preview()
+	}
+	s.chats = append([]adapter.ChatInfo(nil), chats...)
+	for i := range s.chats {
+		if preview, ok := latest[s.chats[i].ID]; ok {
+			s.chats[i].LatestMsg = preview
+		}
+	}
+	s.sort(selected)
+}
+
+// touch moves the chat of a new message to the top and updates its preview.
+func (s *sidebar) touch(msg adapter.Message, name string) {
+	if s.activity == nil {
+		s.activity = make(map[string]time.Time)
+		s.counts = make(map[string]int64)
+	}
+	selected := s.selectedID()
+	s.activity[msg.ChatID] = msg.Time
+	s.counts[msg.ChatID]++
+	preview := storage.ChatSummary{ChatID: msg.ChatID, ChatType: msg.ChatType, Latest: msg}.Preview()
+	for i := range s.chats {
+		if s.chats[i].ID == msg.ChatID {
+			s.chats[i].LatestMsg = preview
+			s.sort(selected)
+			return
+		}
+	}
+	if name == "" {
+		name = msg.ChatID
+	}
+	s.chats = append(s.chats, adapter.ChatInfo{ID: msg.ChatID, Name: name, Type: msg.ChatType, LatestMsg: preview})
+	s.sort(selected)
+}
+
+// sort orders chats by last activity, keeping the cursor on the same chat.
+func (s *sidebar) sort(selected string) {
+	sort.SliceStable(s.chats, func(i, j int) bool {
+		return s.activity[s.chats[i].ID].After(s.activity[s.chats[j].ID])
+	})
+	for i, c := range s.chats {
+		if c.ID == selected {
+			s.cursor = i
+			break
+		}
+	}
+	s.clamp()
+}
+
+func (s *sidebar) selectedID() string {
+	if c, ok := s.selected(); ok {
+		return c.ID
+	}
+	return ""
+}
+
+func (s *sidebar) selected() (adapter.ChatInfo, bool) {
+	if s.cursor < 0 || s.cursor >= len(s.chats) {
+		return adapter.ChatInfo{}, false
+	}
+	return s.chats[s.cursor], true
+}
+
+// moveTo puts the cursor on the given chat, if it is in the list.
+func (s *sidebar) moveTo(chatID string) {
+	for i, c := range s.chats {
+		if c.ID == chatID {
+			s.cursor = i
+			s.clamp()
+			return
+		}
+	}
+}
+
+func (s *sidebar) move(delta int) {
+	s.cursor += delta
+	s.clamp()
+}
+
+// visibleRows returns how many chats fit in the sidebar.
+func (s *sidebar) visibleRows() int {
+	return max(s.height/2, 1)
+}
+
+// clamp keeps the cursor inside the list and scrolls it into view.
+func (s *sidebar) clamp() {
+	s.cursor = min(max(s.cursor, 0), max(len(s.chats)-1, 0))
+	rows := s.visibleRows()
+	if s.cursor < s.offset {
+		s.offset = s.cursor
+	}
+	if s.cursor >= s.offset+rows {
+		s.offset = s.cursor - rows + 1
+	}
+	s.offset = min(s.offset, max(len(s.chats)-rows, 0))
+}
+
+func (s *sidebar) view(t *theme, activeChat string, unread, mentions map[string]int, focused bool) string {
+	inner := sidebarWidth - t.sidebar.GetHorizontalFrameSize() - t.sidebarItem.GetHorizontalPadding()
+	var lines []string
+	if len(s.chats) == 0 {
+		lines = append(lines, t.sidebarItem.Render(t.sidebarPreview.Render("Loading chats...")))
+	}
+	for i := s.offset; i < len(s.chats) && i < s.offset+s.visibleRows(); i++ {
+		c := s.chats[i]
+		badge := ""
+		if n := unread[c.ID]; n > 0 && c.ID != activeChat {
+			badge = fmt.Sprintf(" %d", n)
+			if mentions[c.ID] > 0 {
+				badge = " @" + strings.TrimSpace(badge)
+			}
+		}
+		name := displayName(c)
+		name = runewidth.Truncate(name, inner-runewidth.StringWidth(badge), "…")
+		name = runewidth.FillRight(name, inner-runewidth.StringWidth(badge)) + t.unread.Render(badge)
+		preview := runewidth.FillRight(runewidth.Truncate(c.LatestMsg, inner, "…"), inner)
+
+		style := t.sidebarItem
+		switch {
+		case focused && i == s.cursor:
+			style = t.sidebarCursor
+		case c.ID == activeChat:
+			style = t.sidebarActive
+		}
+		lines = append(lines, style.Render(name), style.Render(t.sidebarPreview.Render(preview)))
+	}
+
+	border := t.sidebar
+	if focused {
+		border = border.BorderForeground(t.accent)
+	}
+	return border.
+		Width(sidebarWidth - t.sidebar.GetHorizontalFrameSize()).
+		Height(s.height).
+		MaxHeight(s.height).
+		Render(strings.Join(lines, "\n"))
+}
+
+// displayName prefers the remark the user gave a friend over their nickname.
+func displayName(c adapter.ChatInfo) string {
+	switch {
+	case c.Remark != "":
+		return c.Remark
+	case c.Name != "":
+		return c.Name
+	}
+	return c.ID
+}
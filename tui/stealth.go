@@ -0,0 +1,105 @@
+package tui
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// stealthSelectedStyle marks the selected line without using colour.
+var stealthSelectedStyle = lipgloss.NewStyle().Reverse(true)
+
+// Words for the pseudo-component names that stand in for senders.
+var (
+	stealthPrefixes = []string{"api", "auth", "cache", "cron", "db", "gateway", "grpc", "http", "kafka", "ledger", "mq", "rpc", "sched", "store", "sync"}
+	stealthSuffixes = []string{"client", "conn", "dispatch", "handler", "pool", "proxy", "reaper", "router", "sink", "worker"}
+)
+
+// updateStealthContent renders the messages as plain log lines: timestamp,
+// level, a module name derived from the sender and the content on one line.
+func (m *Model) updateStealthContent() {
+	style := lipgloss.NewStyle().Width(m.viewport.Width)
+	var content strings.Builder
+	m.msgLines = m.msgLines[:0]
+	for i, msg := range m.messages {
+		if msg.ID != 0 && msg.ID == m.firstUnreadID {
+			content.WriteString("-- MARK --\n")
+		}
+		m.msgLines = append(m.msgLines, strings.Count(content.String(), "\n"))
+
+		self := m.isSelf(msg)
+		line := fmt.Sprintf("%s %-5s [%s] %s", stealthTime(msg), stealthLevel(msg, self), stealthModule(msg, self), m.stealthText(msg.Content))
+		lineStyle := style
+		if m.selection.active && i == m.selection.cursor {
+			lineStyle = lineStyle.Inherit(stealthSelectedStyle)
+		}
+		content.WriteString(lineStyle.Render(line) + "\n")
+	}
+	m.viewport.SetContent(content.String())
+}
+
+// stealthTime formats the message time like a log timestamp. Stored times only
+// have seconds, so the milliseconds are made up from the message.
+func stealthTime(msg adapter.Message) string {
+	t := msg.Time
+	if t.Nanosecond() == 0 {
+		t = t.Add(time.Duration(stealthHash(msg.MessageID+msg.Content)%1000) * time.Millisecond)
+	}
+	return t.Format("2006-01-02 15:04:05.000")
+}
+
+// stealthLevel maps own messages to DEBUG and mentions to WARN, so they stand out a little.
+func stealthLevel(msg adapter.Message, self bool) string {
+	switch {
+	case self:
+		return "DEBUG"
+	case msg.Mentioned:
+		return "WARN"
+	}
+	return "INFO"
+}
+
+// stealthModule turns a sender into a stable pseudo-component name such as "rpc.pool-3f".
+func stealthModule(msg adapter.Message, self bool) string {
+	if self {
+		return "main"
+	}
+	h := stealthHash(msg.SenderID)
+	return fmt.Sprintf("%s.%s-%02x", stealthPrefixes[h%uint32(len(stealthPrefixes))],
+		stealthSuffixes[h/16%uint32(len(stealthSuffixes))], h>>24)
+}
+
+func stealthHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// stealthText flattens content to a single line. Mentions use the same module
+// names as senders, media becomes byte counts and faces are dropped.
+func (m *Model) stealthText(content string) string {
+	var b strings.Builder
+	for _, seg := range adapter.ParseCQ(content) {
+		switch seg.Type {
+		case "face", "mface":
+		case "reply":
+			fmt.Fprintf(&b, "ref=%s ", seg.Data["id"])
+		case "at":
+			if seg.Data["qq"] == "all" {
+				b.WriteString("@all ")
+			} else {
+				b.WriteString("@" + stealthModule(adapter.Message{SenderID: seg.Data["qq"]}, seg.Data["qq"] == m.bot.SelfID()) + " ")
+			}
+		case "image", "record", "video", "file":
+			// 同一个文件每次显示同样的大小
+			fmt.Fprintf(&b, "recv %d bytes ", 2048+stealthHash(imageURL(seg)+seg.Data["file"])%480000)
+		default:
+			b.WriteString(segmentText(seg, m.names))
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
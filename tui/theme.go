@@ -0,0 +1,275 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"gopkg.in/yaml.v3"
+)
+
+// ThemeOptions selects the colours of the TUI.
+type ThemeOptions struct {
+	Name      string            // 内置主题或者 Dir 中主题文件的名字，默认 "dark"
+	Dir       string            // 主题文件目录，里面的 <名字>.yml 都可以切换
+	Colors    map[string]string // 覆盖 Name 主题中的颜色，键同主题文件
+	Nicknames []string          // 覆盖 Name 主题的昵称颜色
+}
+
+// themeColors are the colours a theme is made of. Colours are anything lipgloss
+// accepts: ANSI numbers such as "62" or hex such as "#268bd2". An empty colour
+// leaves the terminal's own.
+type themeColors struct {
+	accent         string // 标题栏背景、边框、选中标记
+	accentText     string // 标题栏文字
+	text           string // 消息正文
+	muted          string // 提示、预览、引用
+	subtle         string // 状态栏、分隔线
+	highlight      string // 光标所在行的背景
+	self           string // 自己的昵称、当前聊天
+	unread         string // 未读数、新消息分隔线
+	link           string // @、链接
+	face           string // QQ 表情
+	chipText       string // 文件等标签的文字
+	chipBackground string // 文件等标签的背景
+	markdown       string // markdown 消息用 glamour 的哪个样式："dark" 或 "light"
+	nicknames      []string
+}
+
+// colors maps the keys used in theme files and config to the fields.
+func (p *themeColors) colors() map[string]*string {
+	return map[string]*string{
+		"accent": &p.accent, "accentText": &p.accentText, "text": &p.text, "muted": &p.muted,
+		"subtle": &p.subtle, "highlight": &p.highlight, "self": &p.self, "unread": &p.unread,
+		"link": &p.link, "face": &p.face, "chipText": &p.chipText, "chipBackground": &p.chipBackground,
+		"markdown": &p.markdown,
+	}
+}
+
+// override replaces the colours given in colors and nicknames, ignoring unknown keys.
+func (p *themeColors) override(colors map[string]string, nicknames []string) {
+	fields := p.colors()
+	for k, v := range colors {
+		if f, ok := fields[k]; ok {
+			*f = v
+		} else {
+			log.Printf("Unknown theme color %q", k)
+		}
+	}
+	if len(nicknames) > 0 {
+		p.nicknames = nicknames
+	}
+}
+
+// builtinThemes are the themes that need no theme file.
+var builtinThemes = map[string]themeColors{
+	"dark": {
+		accent: "62", accentText: "230", muted: "244", subtle: "240", highlight: "237",
+		self: "86", unread: "203", link: "39", face: "214", chipText: "252", chipBackground: "237",
+		markdown:  "dark",
+		nicknames: []string{"203", "209", "215", "149", "78", "80", "75", "141", "177", "211"},
+	},
+	"light": {
+		accent: "63", accentText: "255", text: "235", muted: "243", subtle: "246", highlight: "254",
+		self: "29", unread: "160", link: "25", face: "130", chipText: "236", chipBackground: "253",
+		markdown:  "light",
+		nicknames: []string{"124", "130", "28", "30", "25", "91", "127", "94", "58", "23"},
+	},
+	"solarized": {
+		accent: "#268bd2", accentText: "#fdf6e3", text: "#839496", muted: "#586e75", subtle: "#586e75",
+		highlight: "#073642", self: "#859900", unread: "#dc322f", link: "#2aa198", face: "#b58900",
+		chipText: "#93a1a1", chipBackground: "#073642", markdown: "dark",
+		nicknames: []string{"#b58900", "#cb4b16", "#dc322f", "#d33682", "#6c71c4", "#268bd2", "#2aa198", "#859900"},
+	},
+	"high-contrast": {
+		accent: "11", accentText: "0", text: "15", muted: "15", subtle: "15", highlight: "4",
+		self: "10", unread: "9", link: "14", face: "11", chipText: "0", chipBackground: "15",
+		markdown:  "dark",
+		nicknames: []string{"9", "10", "11", "12", "13", "14"},
+	},
+}
+
+// themeFile is the format of a theme file: a built-in theme to start from and
+// the colours to change.
+type themeFile struct {
+	Base      string            `yaml:"base"`
+	Colors    map[string]string `yaml:"colors"`
+	Nicknames []string          `yaml:"nicknames"`
+}
+
+// theme holds every style the TUI is drawn with.
+type theme struct {
+	name     string
+	markdown string // glamour 样式名
+
+	header, status, leftMsg, rightMsg, sender, selfSender, unread, reply lipgloss.Style
+	quote, mention, face, chip, card, cardSource, cardTitle, link        lipgloss.Style
+	menu, menuCursor, menuKey, popupTitle, hint                          lipgloss.Style
+	finder, finderCursor                                                 lipgloss.Style
+	sidebar, sidebarItem, sidebarActive, sidebarCursor, sidebarPreview   lipgloss.Style
+	accent                                                               lipgloss.Color
+	nicknames                                                            []lipgloss.Color
+}
+
+func newTheme(name string, p themeColors) *theme {
+	c := func(s string) lipgloss.Color { return lipgloss.Color(s) }
+	t := &theme{
+		name:     name,
+		markdown: p.markdown,
+		accent:   c(p.accent),
+
+		header:     lipgloss.NewStyle().Background(c(p.accent)).Foreground(c(p.accentText)).Padding(0, 1),
+		status:     lipgloss.NewStyle().Foreground(c(p.subtle)).Padding(0, 1),
+		leftMsg:    lipgloss.NewStyle().PaddingLeft(2).Foreground(c(p.text)),
+		rightMsg:   lipgloss.NewStyle().PaddingRight(2).Align(lipgloss.Right).Foreground(c(p.text)),
+		sender:     lipgloss.NewStyle().Bold(true),
+		selfSender: lipgloss.NewStyle().Bold(true).Foreground(c(p.self)),
+		unread:     lipgloss.NewStyle().Foreground(c(p.unread)),
+		reply:      lipgloss.NewStyle().Foreground(c(p.muted)).Padding(0, 1),
+
+		quote:      lipgloss.NewStyle().Foreground(c(p.muted)).BorderStyle(lipgloss.NormalBorder()).BorderLeft(true).BorderForeground(c(p.subtle)).PaddingLeft(1),
+		mention:    lipgloss.NewStyle().Foreground(c(p.link)),
+		face:       lipgloss.NewStyle().Foreground(c(p.face)),
+		chip:       lipgloss.NewStyle().Foreground(c(p.chipText)).Background(c(p.chipBackground)).Padding(0, 1),
+		card:       lipgloss.NewStyle().BorderStyle(lipgloss.ThickBorder()).BorderLeft(true).BorderForeground(c(p.accent)).PaddingLeft(1),
+		cardSource: lipgloss.NewStyle().Foreground(c(p.muted)),
+		cardTitle:  lipgloss.NewStyle().Bold(true),
+		link:       lipgloss.NewStyle().Foreground(c(p.link)).Underline(true),
+
+		menu:       lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(c(p.accent)).Padding(0, 1),
+		menuCursor: lipgloss.NewStyle().Background(c(p.highlight)).Bold(true),
+		menuKey:    lipgloss.NewStyle().Foreground(c(p.self)),
+		popupTitle: lipgloss.NewStyle().Bold(true),
+		hint:       lipgloss.NewStyle().Foreground(c(p.muted)),
+
+		finder:       lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(c(p.accent)).Padding(0, 1),
+		finderCursor: lipgloss.NewStyle().Background(c(p.highlight)).Bold(true),
+
+		sidebar:        lipgloss.NewStyle().BorderStyle(lipgloss.NormalBorder()).BorderRight(true).BorderForeground(c(p.subtle)),
+		sidebarItem:    lipgloss.NewStyle().PaddingLeft(1),
+		sidebarActive:  lipgloss.NewStyle().PaddingLeft(1).Bold(true).Foreground(c(p.self)),
+		sidebarCursor:  lipgloss.NewStyle().PaddingLeft(1).Background(c(p.highlight)),
+		sidebarPreview: lipgloss.NewStyle().Foreground(c(p.muted)),
+	}
+	for _, n := range p.nicknames {
+		t.nicknames = append(t.nicknames, c(n))
+	}
+	return t
+}
+
+// senderStyle colours a nickname. Other people get a colour picked from their
+// QQ number, so the same person has the same colour in every chat.
+func (t *theme) senderStyle(id string, self bool) lipgloss.Style {
+	if self {
+		return t.selfSender
+	}
+	if len(t.nicknames) == 0 {
+		return t.sender
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return t.sender.Foreground(t.nicknames[h.Sum32()%uint32(len(t.nicknames))])
+}
+
+// loadTheme builds a theme by name: a built-in, or <dir>/<name>.yml.
+func loadTheme(name, dir string) (*theme, themeColors, error) {
+	if p, ok := builtinThemes[name]; ok {
+		return newTheme(name, p), p, nil
+	}
+	path, err := themePath(name, dir)
+	if err != nil {
+		return nil, themeColors{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, themeColors{}, err
+	}
+	var f themeFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, themeColors{}, fmt.Errorf("parse theme %s: %w", path, err)
+	}
+	if f.Base == "" {
+		f.Base = "dark"
+	}
+	p, ok := builtinThemes[f.Base]
+	if !ok {
+		return nil, themeColors{}, fmt.Errorf("theme %s: unknown base theme %q", path, f.Base)
+	}
+	p.override(f.Colors, f.Nicknames)
+	return newTheme(name, p), p, nil
+}
+
+func themePath(name, dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("unknown theme %q", name)
+	}
+	for _, ext := range []string{".yml", ".yaml"} {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("unknown theme %q", name)
+}
+
+// themeNames lists the built-in themes followed by the theme files in dir.
+func themeNames(dir string) []string {
+	names := []string{"dark", "light", "solarized", "high-contrast"}
+	entries, _ := os.ReadDir(dir)
+	var files []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if name := strings.TrimSuffix(e.Name(), ext); !e.IsDir() && (ext == ".yml" || ext == ".yaml") && !slices.Contains(names, name) {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return append(names, files...)
+}
+
+// setTheme switches to the named theme. The colours from the config only
+// apply to the theme it names.
+func (m *Model) setTheme(name string) error {
+	t, p, err := loadTheme(name, m.themeOpts.Dir)
+	if err != nil {
+		return err
+	}
+	if name == m.themeOpts.Name && (len(m.themeOpts.Colors) > 0 || len(m.themeOpts.Nicknames) > 0) {
+		p.override(m.themeOpts.Colors, m.themeOpts.Nicknames)
+		t = newTheme(name, p)
+	}
+	m.theme = t
+	m.composer.FocusedStyle.Placeholder = t.hint
+	m.composer.FocusedStyle.Text = lipgloss.NewStyle().Foreground(lipgloss.Color(p.text))
+	clear(m.markdown)
+	return nil
+}
+
+// nextTheme switches to the theme after the current one.
+func (m *Model) nextTheme() {
+	names := themeNames(m.themeOpts.Dir)
+	i := slices.Index(names, m.theme.name)
+	if err := m.applyTheme(names[(i+1)%len(names)]); err != nil {
+		m.statusText = fmt.Sprintf("Error loading theme: %v", err)
+	}
+}
+
+// applyTheme switches to the named theme and redraws.
+func (m *Model) applyTheme(name string) error {
+	if err := m.setTheme(name); err != nil {
+		return err
+	}
+	m.statusText = "Theme: " + name + "."
+	m.resize()
+	m.updateViewportContent()
+	return nil
+}
@@ -0,0 +1,105 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ziyi233/onebot-tui/adapter"
+)
+
+// Timestamp styles for Options.Timestamps.
+const (
+	TimestampsAbsolute = "absolute"
+	TimestampsRelative = "relative"
+	TimestampsOff      = "off"
+)
+
+// clockTickMsg refreshes relative timestamps and the day separators.
+type clockTickMsg time.Time
+
+// clockTick is a command that ticks at the start of the next minute.
+func clockTick() tea.Cmd {
+	now := time.Now()
+	return tea.Tick(now.Truncate(time.Minute).Add(time.Minute).Sub(now), func(t time.Time) tea.Msg {
+		return clockTickMsg(t)
+	})
+}
+
+// handleClockTick redraws the messages when their timestamps or day labels
+// have changed.
+func (m *Model) handleClockTick(now time.Time) tea.Cmd {
+	if m.ready && (m.timestamps == TimestampsRelative || !sameDay(now, m.renderedAt)) {
+		m.redraw()
+	}
+	return clockTick()
+}
+
+// timestamp formats the time of a message. The date is left to the day separator.
+func (m *Model) timestamp(t, now time.Time) string {
+	switch m.timestamps {
+	case TimestampsOff:
+		return ""
+	case TimestampsRelative:
+		switch age := now.Sub(t); {
+		case age < time.Minute:
+			return "just now"
+		case age < time.Hour:
+			return fmt.Sprintf("%dm ago", int(age/time.Minute))
+		case age < 24*time.Hour:
+			return fmt.Sprintf("%dh ago", int(age/time.Hour))
+		}
+	}
+	return t.Format("15:04")
+}
+
+// daySeparator is the line above the first message of each day.
+func (m *Model) daySeparator(t, now time.Time) string {
+	var label string
+	switch {
+	case sameDay(t, now):
+		label = "Today"
+	case sameDay(t, now.AddDate(0, 0, -1)):
+		label = "Yesterday"
+	case t.Year() == now.Year():
+		label = t.Format("Mon, Jan 2")
+	default:
+		label = t.Format("Mon, Jan 2, 2006")
+	}
+	return m.theme.hint.Render(lipgloss.PlaceHorizontal(m.viewport.Width, lipgloss.Center, "── "+label+" ──"))
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// groupedWith reports whether msg continues the messages of prev, so its
+// sender and time are not repeated.
+func (m *Model) groupedWith(prev, msg adapter.Message) bool {
+	if m.groupWithin <= 0 || !sameDay(prev.Time, msg.Time) || msg.Time.Before(prev.Time) {
+		return false
+	}
+	if self := m.isSelf(msg); self || m.isSelf(prev) {
+		// 自己发出的消息在回显之前显示为 "You"，和存下来的昵称不同
+		return self && m.isSelf(prev) && msg.Time.Sub(prev.Time) < m.groupWithin
+	}
+	return msg.SenderID == prev.SenderID && msg.SenderName == prev.SenderName && msg.Time.Sub(prev.Time) < m.groupWithin
+}
+
+// messageHeader is the sender line of a message with its time, on the
+// side the message is aligned to.
+func (m *Model) messageHeader(msg adapter.Message, self bool, now time.Time) string {
+	sender := m.theme.senderStyle(msg.SenderID, self).Render(msg.SenderName)
+	ts := m.timestamp(msg.Time, now)
+	switch {
+	case ts == "":
+		return sender
+	case self:
+		return m.theme.hint.Render(ts) + "  " + sender
+	default:
+		return sender + "  " + m.theme.hint.Render(ts)
+	}
+}
@@ -1,50 +1,125 @@
 package tui
 
 import (
+	"cmp"
 	"fmt"
-	"regexp"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 	"github.com/ziyi233/onebot-tui/adapter"
+	"github.com/ziyi233/onebot-tui/stats"
 	"github.com/ziyi233/onebot-tui/storage"
 )
 
-var (
-	headerStyle      = lipgloss.NewStyle().Background(lipgloss.Color("62")).Foreground(lipgloss.Color("230")).Padding(0, 1)
-	statusStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Padding(0, 1)
-	leftMsgStyle     = lipgloss.NewStyle().PaddingLeft(2)
-	rightMsgStyle    = lipgloss.NewStyle().PaddingRight(2).Align(lipgloss.Right)
-	senderStyle      = lipgloss.NewStyle().Bold(true)
-	selfSenderStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
-	cqImageRegex   = regexp.MustCompile(`\[CQ:image,.*?\]`)
-	cqForwardRegex = regexp.MustCompile(`\[CQ:forward,.*?\]`)
-)
+// maxMessageLength is the longest text message QQ accepts, in characters.
+const maxMessageLength = 4500
+
+// composerMaxHeight is how many lines the input grows to before it scrolls.
+const composerMaxHeight = 6
+
+// inputHistorySize is how many sent inputs per chat are available with up/down.
+const inputHistorySize = 100
 
 // Model represents the state of the TUI.
 type Model struct {
-	appState    appState
-	bot         adapter.BotAdapter
-	store       *storage.Store
-	messageChan chan adapter.Message
+	appState appState
+	bot      adapter.BotAdapter
+	store    storage.Backend
+
+	viewport   viewport.Model
+	composer   textarea.Model
+	headerText string
+	statusText string
+	activeChat string
+	messages   []adapter.Message
+	ready      bool
+
+	unread        map[string]int // chatID -> 未读消息数
+	mentions      map[string]int // chatID -> 未读的 @我 消息数
+	firstUnreadID int64          // 当前聊天中第一条未读消息的行号，0 表示没有
+	msgLines      []int          // 每条消息在 viewport 内容中的起始行
+
+	history      []string // 当前聊天发送过的输入，按时间顺序
+	historyPos   int      // 正在浏览的历史位置，等于 len(history) 表示没有在浏览
+	historyStash string   // 开始浏览历史前输入框中的内容
+
+	width, height  int
+	sidebar        sidebar
+	showSidebar    bool // 是否显示左侧的聊天列表
+	sidebarFocused bool // 键盘操作的是聊天列表而不是输入框
+	finder         finder
+	finderOpen     bool
+
+	showStats bool   // viewport 中显示的是统计面板而不是消息
+	statsText string // 渲染好的统计面板
+
+	selection selection        // 逐条选择消息的模式，见 selection.go
+	replyTo   *adapter.Message // 下一条发送的消息要回复的消息
+	images    images           // 消息中的图片，见 images.go
+
+	names    map[string]string // 当前聊天中 QQ 号 -> 昵称，用于显示 @
+	quotes   map[string]*quote // "chatID:messageID" -> 被回复的消息，见 render.go
+	markdown map[string]string // 渲染好的 markdown 消息
 
-	viewport    viewport.Model
-	textInput   textinput.Model
-	headerText  string
-	statusText  string
-	activeChat  string
-	messages    []adapter.Message
-	ready       bool
+	theme     *theme       // 当前主题，见 theme.go
+	themeOpts ThemeOptions // 配置的主题，切换主题时用
+
+	completion completion                 // 输入框的 Tab 补全，见 complete.go
+	members    map[string]*groupMembers   // 群号 -> 群成员，用于补全和 /members
+	mention    mentionPopup               // 输入 @ 时的成员列表，见 mention.go
+	picked     map[string][]pickedMention // chatID -> 草稿中从成员列表插入的 @，按在草稿中的顺序
+
+	keys        keyMap // 见 keys.go
+	showHelp    bool   // 显示按键帮助
+	confirmQuit bool   // 正在询问是否退出
+	sending     int    // 已经发出、还没有结果的消息数
+
+	boss    boss // 老板键的伪装画面，见 boss.go
+	stealth bool // 把消息显示成日志行，见 stealth.go
+
+	timestamps  string        // 消息时间的显示方式，见 timeline.go
+	groupWithin time.Duration // 同一发送者的连续消息间隔小于此值时合并显示
+	renderedAt  time.Time     // 上次绘制消息的时间，跨天时要更新日期分隔线
+
+	panes         []paneState // 分屏的窗格，焦点窗格的状态在上面的字段中，见 panes.go
+	focus         int         // 焦点窗格的下标
+	splitVertical bool        // 窗格左右排列，否则上下排列
+}
+
+// Options configures the TUI.
+type Options struct {
+	Images ImageOptions
+	Boss   BossOptions
+	Theme  ThemeOptions
+	// Keys rebinds actions by name, e.g. {"quit": "ctrl+q"}; see keys.go.
+	Keys map[string]string
+	// Stealth starts the TUI with messages shown as log lines.
+	Stealth bool
+	// Timestamps shows message times as "absolute" (15:04, the default),
+	// "relative" (5m ago) or "off".
+	Timestamps string
+	// GroupWithin shows the sender once for consecutive messages sent within
+	// this long of each other; 0 shows it on every message.
+	GroupWithin time.Duration
 }
 
 // appState is an interface to get chat type without circular dependency
 type appState interface {
 	GetChatType(chatID string) string
 	GetChatName(chatID string) string
+	SetActiveChat(chatID string)
 }
 
 // ActiveChatChangedMsg is a message to notify the TUI that the active chat has changed.
@@ -54,35 +129,153 @@ type ActiveChatChangedMsg struct {
 }
 
 // CachesPopulatedMsg is a message to notify the TUI that the caches are populated.
-type CachesPopulatedMsg struct{}
+type CachesPopulatedMsg struct {
+	Chats []adapter.ChatInfo // groups and friends, shown in the sidebar
+}
+
+// ChatReadMsg is a message to notify the TUI that a chat was marked as read elsewhere.
+type ChatReadMsg struct {
+	ID string
+}
+
+// MessageStoredMsg is a message to notify the TUI that a received message was
+// saved, with its ID filled in.
+type MessageStoredMsg struct {
+	Message adapter.Message
+}
+
+// StorageErrorMsg is a message to notify the TUI that received messages could not be saved.
+type StorageErrorMsg struct {
+	Err    error
+	Failed int
+}
+
+// statsLoadedMsg carries the statistics computed for the stats panel.
+type statsLoadedMsg struct {
+	chatID string
+	report *stats.Report
+	err    error
+}
+
+// sendResultMsg reports whether a message sent from the composer was accepted.
+type sendResultMsg struct {
+	chatID    string
+	sentAt    time.Time // 标识 m.messages 中对应的那条消息
+	messageID string
+	err       error
+}
+
+// historyLoadedMsg carries the recent messages of a chat that was opened.
+type historyLoadedMsg struct {
+	chatID   string
+	messages []adapter.Message
+	names    map[string]string
+	unread   bool  // 打开时有未读消息，要显示新消息分隔线
+	lastRead int64 // 打开前的已读标记
+	err      error
+}
+
+// markReadFailedMsg reports that the read marker of a chat could not be moved.
+type markReadFailedMsg struct {
+	err error
+}
+
+// unreadLoadedMsg carries the unread counters loaded from storage at startup.
+type unreadLoadedMsg struct {
+	infos []storage.UnreadInfo
+	err   error
+}
 
 // New creates a new TUI model.
-func New(appState appState, bot adapter.BotAdapter, store *storage.Store, messageChan chan adapter.Message) *Model {
-	ti := textinput.New()
-	ti.Placeholder = "Send a message..."
-	ti.Focus()
-	ti.CharLimit = 256
-	ti.Width = 20
-
-	return &Model{
+func New(appState appState, bot adapter.BotAdapter, store storage.Backend, opts Options) *Model {
+	keys := newKeyMap()
+	if opts.Boss.Key != "" {
+		keys.Boss.SetKeys(opts.Boss.Key)
+		keys.Boss.SetHelp(opts.Boss.Key, keys.Boss.Help().Desc)
+	}
+	keysErr := keys.override(opts.Keys)
+
+	ta := textarea.New()
+	ta.Placeholder = fmt.Sprintf("Send a message or /command... (%s for a new line, %s for help)",
+		firstKey(keys.Newline), firstKey(keys.Help))
+	ta.Prompt = "> "
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0 // 长度在发送时按 QQ 的上限检查
+	ta.MaxHeight = 0
+	ta.SetHeight(1)
+	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	ta.KeyMap.InsertNewline = keys.Newline
+	ta.Focus()
+
+	m := &Model{
 		appState:    appState,
 		bot:         bot,
 		store:       store,
-		messageChan: messageChan,
-		textInput:   ti,
+		composer:    ta,
+		keys:        keys,
+		images:      newImages(opts.Images),
+		boss:        newBoss(opts.Boss),
+		stealth:     opts.Stealth,
 		headerText:  "No Active Chat",
-		statusText:  "Ready. Press Ctrl+C to quit.",
 		messages:    []adapter.Message{},
+		unread:      make(map[string]int),
+		mentions:    make(map[string]int),
+		showSidebar: true,
+		finder:      newFinder(),
+		names:       make(map[string]string),
+		quotes:      make(map[string]*quote),
+		markdown:    make(map[string]string),
+		members:     make(map[string]*groupMembers),
+		picked:      make(map[string][]pickedMention),
+		themeOpts:   opts.Theme,
+		timestamps:  opts.Timestamps,
+		panes:       make([]paneState, 1),
+		groupWithin: opts.GroupWithin,
+	}
+	if m.themeOpts.Name == "" {
+		m.themeOpts.Name = "dark"
+	}
+	m.statusText = "Ready. " + help.New().ShortHelpView(keys.ShortHelp())
+	if keysErr != nil {
+		m.statusText = fmt.Sprintf("Error in key bindings: %v", keysErr)
 	}
+	switch m.timestamps {
+	case TimestampsAbsolute, TimestampsRelative, TimestampsOff:
+	default:
+		if m.timestamps != "" {
+			m.statusText = fmt.Sprintf("Unknown timestamps %q, using %s.", m.timestamps, TimestampsAbsolute)
+		}
+		m.timestamps = TimestampsAbsolute
+	}
+	if err := m.setTheme(m.themeOpts.Name); err != nil {
+		m.setTheme("dark")
+		m.statusText = fmt.Sprintf("Error loading theme, using dark: %v", err)
+	}
+	return m
 }
 
 // Init is the first command that is run when the program starts.
 func (m *Model) Init() tea.Cmd {
-	return tea.Batch(textinput.Blink, waitForMessage(m.messageChan))
+	return tea.Batch(textarea.Blink, tea.SetWindowTitle(windowTitle), loadUnread(m.store), clockTick())
 }
 
 // Update handles all incoming messages.
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+	if _, ok := msg.(tea.KeyMsg); ok {
+		m.updateMention() // 输入框的内容可能变了
+		if m.composer.Value() == "" {
+			delete(m.picked, m.activeChat) // 草稿清空了
+		}
+	}
+	if m.images.prune(m.imageShown) {
+		m.redraw()
+	}
+	// 图片只在滚动到附近时才下载
+	return model, tea.Batch(cmd, m.images.fetch(m.viewport.YOffset, m.viewport.Height), m.images.flushKitty(), m.fetchQuotes(), m.fetchMembers())
+}
+
+func (m *Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		cmd  tea.Cmd
 		cmds []tea.Cmd
@@ -90,146 +283,1033 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		headerHeight := headerStyle.GetVerticalFrameSize()
-		statusHeight := statusStyle.GetVerticalFrameSize()
-		inputHeight := 1
-
-		m.viewport.Width = msg.Width
-		m.viewport.Height = msg.Height - headerHeight - statusHeight - inputHeight
-		m.textInput.Width = msg.Width - 2 // padding
-		headerStyle.Width(msg.Width)
-		statusStyle.Width(msg.Width)
-		rightMsgStyle = rightMsgStyle.Width(m.viewport.Width)
-
+		m.width, m.height = msg.Width, msg.Height
 		if !m.ready {
 			m.ready = true
 		}
-
-		m.updateViewportContent()
+		m.resize()
 
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "esc":
-			return m, tea.Quit
-		case "enter":
-			if m.activeChat != "" && m.textInput.Value() != "" {
-				chatType := m.appState.GetChatType(m.activeChat)
-				if chatType == "" {
-					m.statusText = "Error: Unknown chat type."
-				} else {
-					messageContent := m.textInput.Value()
-					err := m.bot.SendMessage(m.activeChat, chatType, messageContent)
-					if err != nil {
-						m.statusText = fmt.Sprintf("Error sending: %v", err)
-					} else {
-						sentMsg := adapter.Message{
-							SenderName: "You",
-							Content:    messageContent,
-							Time:       time.Now(),
-						}
-						m.messages = append(m.messages, sentMsg)
-						m.updateViewportContent()
-						m.viewport.GotoBottom()
-						m.textInput.Reset()
+		if m.boss.active || key.Matches(msg, m.keys.Boss) {
+			return m, m.updateBoss(msg)
+		}
+		if m.confirmQuit {
+			return m, m.updateConfirmQuit(msg)
+		}
+		if m.showHelp {
+			m.showHelp = false
+			return m, nil
+		}
+		if key.Matches(msg, m.keys.Quit) {
+			return m, m.quit()
+		}
+		if m.finderOpen {
+			return m, m.updateFinder(msg)
+		}
+		if key.Matches(msg, m.keys.Finder) {
+			m.finderOpen = true
+			return m, m.finder.open(m.sidebar.chats, m.sidebar.activity, m.sidebar.counts)
+		}
+		if m.sidebarFocused {
+			return m, m.updateSidebar(msg)
+		}
+		if m.selection.popup != nil {
+			return m, m.updatePopup(msg)
+		}
+		if m.selection.active {
+			return m, m.updateSelection(msg)
+		}
+		if m.mention.open && m.updateMentionKeys(msg) {
+			return m, nil
+		}
+		switch {
+		case msg.String() == "esc":
+			m.setReply(nil)
+			return m, nil
+		case key.Matches(msg, m.keys.Help) && m.composer.Value() == "":
+			m.showHelp = true
+			return m, nil
+		case key.Matches(msg, m.keys.Sidebar):
+			m.showSidebar = !m.showSidebar
+			m.resize()
+			return m, nil
+		case key.Matches(msg, m.keys.Complete) && m.canComplete():
+			m.complete()
+			return m, nil
+		case key.Matches(msg, m.keys.FocusSidebar):
+			if m.showSidebar {
+				m.sidebarFocused = true
+				m.sidebar.moveTo(m.activeChat)
+			}
+			return m, nil
+		case msg.String() == "up":
+			if m.composer.Line() == 0 {
+				m.browseHistory(-1)
+				return m, nil
+			}
+		case msg.String() == "down":
+			if m.composer.Line() == m.composer.LineCount()-1 {
+				m.browseHistory(1)
+				return m, nil
+			}
+		case key.Matches(msg, m.keys.JumpUnread):
+			m.jumpToFirstUnread()
+			return m, nil
+		case key.Matches(msg, m.keys.NextPane):
+			if len(m.panes) == 1 {
+				m.statusText = "Only one pane. Use /split or /vsplit to add one."
+				return m, nil
+			}
+			return m, m.focusPane((m.focus + 1) % len(m.panes))
+		case key.Matches(msg, m.keys.Select):
+			m.startSelection()
+			return m, nil
+		case key.Matches(msg, m.keys.Images):
+			m.statusText = m.images.toggle()
+			m.redraw()
+			return m, nil
+		case key.Matches(msg, m.keys.Theme):
+			m.nextTheme()
+			return m, nil
+		case key.Matches(msg, m.keys.LogView):
+			m.stealth = !m.stealth
+			m.statusText = "Log view off."
+			if m.stealth {
+				m.statusText = "Log view on."
+			}
+			m.redraw()
+			return m, nil
+		case key.Matches(msg, m.keys.Stats):
+			if m.showStats {
+				m.showStats = false
+				m.updateViewportContent()
+				m.viewport.GotoBottom()
+				return m, nil
+			}
+			if m.activeChat == "" {
+				m.statusText = "No active chat."
+				return m, nil
+			}
+			m.statusText = "Computing statistics..."
+			return m, loadStats(m.store, m.activeChat)
+		case key.Matches(msg, m.keys.Send):
+			text := m.composer.Value()
+			if name, args, ok := parseCommand(text); ok {
+				if cmd, ok := m.runCommand(name, args); ok {
+					if m.activeChat != "" {
+						m.recordInput(text)
 					}
+					m.composer.Reset()
+					cmds = append(cmds, cmd)
+				}
+				m.fitComposer()
+				return m, tea.Batch(cmds...)
+			}
+			if m.activeChat != "" && strings.TrimSpace(text) != "" {
+				if cmd, ok := m.send(strings.TrimPrefix(text, "/")); ok {
+					m.composer.Reset()
+					cmds = append(cmds, cmd)
 				}
 			}
+			m.fitComposer()
+			return m, tea.Batch(cmds...)
+		case key.Matches(msg, m.keys.Editor):
+			return m, openEditor(m.composer.Value())
 		}
 
-	case CachesPopulatedMsg:
+	case editorFinishedMsg:
+		content, err := os.ReadFile(msg.path)
+		info, statErr := os.Stat(msg.path)
+		os.Remove(msg.path)
+		if msg.err == nil {
+			msg.err = cmp.Or(err, statErr)
+		}
+		if msg.err != nil {
+			// 编辑器出错退出（如 vim 的 :cq）表示放弃，草稿保持原样
+			m.statusText = fmt.Sprintf("Editor failed, nothing sent: %v", msg.err)
+			break
+		}
+		text := strings.TrimRight(string(content), "\r\n")
+		if string(content) == msg.draft && info.ModTime().Equal(msg.modTime) {
+			// 没有保存就退出了
+			m.composer.SetValue(msg.draft)
+			m.fitComposer()
+			m.statusText = "Draft not saved in the editor, nothing sent."
+			break
+		}
+		if strings.TrimSpace(text) == "" {
+			m.statusText = "Empty message, nothing sent."
+			break
+		}
+		m.composer.SetValue(text)
 		if m.activeChat != "" {
-			chatName := m.appState.GetChatName(m.activeChat)
-			if chatName != "" {
-				m.headerText = fmt.Sprintf("Chat with %s", chatName)
+			if cmd, ok := m.send(text); ok {
+				m.composer.Reset()
+				cmds = append(cmds, cmd)
 			}
 		}
 
+	case sendResultMsg:
+		m.sending--
+		if i := m.paneOf(msg.chatID); i >= 0 {
+			m.inPane(i, func() { m.sentMessage(msg) })
+		}
+		if msg.err != nil {
+			m.statusText = fmt.Sprintf("Error sending: %v", msg.err)
+		}
+
+	case actionResultMsg:
+		return m, m.handleActionResult(msg)
+
+	case commandResultMsg:
+		m.handleCommandResult(msg)
+
+	case membersLoadedMsg:
+		m.handleMembersLoaded(msg)
+
+	case splitPaneMsg:
+		return m, m.handleSplitPane(msg)
+
+	case closePaneMsg:
+		cmd, err := m.closePane()
+		if err != nil {
+			m.statusText = "Error closing pane: " + err.Error()
+		}
+		return m, cmd
+
+	case clockTickMsg:
+		cmds = append(cmds, m.handleClockTick(time.Time(msg)))
+
+	case imageLoadedMsg:
+		if m.images.loaded(msg) || m.paneWants(msg.url) {
+			m.redraw()
+		}
+
+	case kittyFlushedMsg:
+		m.images.kittyFlushed(msg)
+
+	case bossTickMsg:
+		return m, m.boss.tick(msg)
+
+	case quoteLoadedMsg:
+		if q := m.quotes[msg.key]; q != nil {
+			q.loading = false
+			if msg.err != nil || msg.msg == nil {
+				q.failed = true
+				break
+			}
+			q.msg = msg.msg
+			m.redraw()
+		}
+
+	case CachesPopulatedMsg:
+		m.eachPane(func() {
+			if m.activeChat != "" {
+				chatName := m.appState.GetChatName(m.activeChat)
+				if chatName != "" {
+					m.headerText = fmt.Sprintf("Chat with %s", chatName)
+				}
+			}
+		})
+		return m, loadSidebar(m.store, msg.Chats)
+
+	case sidebarLoadedMsg:
+		if msg.err != nil {
+			m.statusText = fmt.Sprintf("Error loading chat list: %v", msg.err)
+		}
+		m.sidebar.setChats(msg.chats, msg.summaries)
+
 	case ActiveChatChangedMsg:
+		if i := m.paneOf(msg.ID); i >= 0 && i != m.focus {
+			// 已经在另一个窗格中打开了
+			m.finder.opened(msg.ID)
+			return m, m.focusPane(i)
+		}
+		m.saveDraft()
 		m.activeChat = msg.ID
+		m.loadInput()
+		m.selection = selection{width: m.selection.width, height: m.selection.height}
+		m.setReply(nil)
+		m.sidebar.moveTo(msg.ID)
+		m.finder.opened(msg.ID)
 		chatName := m.appState.GetChatName(msg.ID)
 		if chatName == "" {
 			chatName = msg.ID
 		}
 		m.headerText = fmt.Sprintf("Chat with %s", chatName)
 		m.messages = []adapter.Message{}
-		history, err := m.store.GetMessages(m.activeChat, 50)
-		if err != nil {
-			m.statusText = fmt.Sprintf("Error loading history: %v", err)
-		} else {
-			m.messages = history
+		m.names = make(map[string]string)
+		m.firstUnreadID = 0
+		m.showStats = false
+		m.updateViewportContent()
+		return m, loadHistory(m.store, m.activeChat, m.unread[m.activeChat] > 0)
+
+	case historyLoadedMsg:
+		return m, m.handleHistoryLoaded(msg)
+
+	case markReadFailedMsg:
+		m.statusText = fmt.Sprintf("Error marking chat as read: %v", msg.err)
+
+	case ChatReadMsg:
+		delete(m.unread, msg.ID)
+		delete(m.mentions, msg.ID)
+
+	case StorageErrorMsg:
+		m.statusText = fmt.Sprintf("Error saving %d messages: %v", msg.Failed, msg.Err)
+
+	case statsLoadedMsg:
+		if msg.chatID != m.activeChat {
+			break
 		}
+		if msg.err != nil {
+			m.statusText = fmt.Sprintf("Error computing statistics: %v", msg.err)
+			break
+		}
+		m.showStats = true
+		m.statsText = stats.Render(msg.report, m.viewport.Width-2)
+		m.statusText = "Statistics for this chat. Press Alt+S to return."
 		m.updateViewportContent()
-		m.viewport.GotoBottom()
+		m.viewport.GotoTop()
 
-	case adapter.Message:
+	case unreadLoadedMsg:
+		if msg.err != nil {
+			m.statusText = fmt.Sprintf("Error loading unread counters: %v", msg.err)
+			break
+		}
+		for _, info := range msg.infos {
+			if m.paneOf(info.ChatID) >= 0 {
+				continue
+			}
+			m.unread[info.ChatID] = info.Unread
+			m.mentions[info.ChatID] = info.Mentions
+		}
+
+	case adapter.Notice:
 		if msg.ChatID == m.activeChat {
-			m.messages = append(m.messages, msg)
-			m.updateViewportContent()
-			m.viewport.GotoBottom()
+			m.statusText = fmt.Sprintf("[%s] %s", msg.Time.Format("15:04"), msg.Text)
 		}
-		return m, waitForMessage(m.messageChan)
+
+	case adapter.Message:
+		m.sidebar.touch(msg, m.appState.GetChatName(msg.ChatID))
+		var cmd tea.Cmd
+		if i := m.paneOf(msg.ChatID); i >= 0 {
+			m.inPane(i, func() {
+				m.messages = append(m.messages, msg)
+				m.names[msg.SenderID] = msg.SenderName
+				if !m.showStats {
+					m.updateViewportContent()
+					if !m.selection.active {
+						m.viewport.GotoBottom()
+					}
+				}
+				// 伪装画面下没有真正看到，恢复时再标记已读
+				if !m.boss.active {
+					cmd = m.markActiveRead()
+				}
+			})
+		} else {
+			m.unread[msg.ChatID]++
+			if msg.Mentioned {
+				m.mentions[msg.ChatID]++
+			}
+		}
+		return m, cmd
+
+	case MessageStoredMsg:
+		if i := m.paneOf(msg.Message.ChatID); i >= 0 {
+			m.inPane(i, func() { m.messageStored(msg.Message) })
+		}
+		return m, nil
 	}
 
-	m.viewport, cmd = m.viewport.Update(msg)
-	cmds = append(cmds, cmd)
+	// 打字时的按键只交给输入框，否则 j、k、空格等会让消息列表跟着滚动
+	if keyMsg, ok := msg.(tea.KeyMsg); !ok || keyMsg.String() == "pgup" || keyMsg.String() == "pgdown" {
+		m.viewport, cmd = m.viewport.Update(msg)
+		cmds = append(cmds, cmd)
+	}
 
-	m.textInput, cmd = m.textInput.Update(msg)
+	m.composer, cmd = m.composer.Update(msg)
 	cmds = append(cmds, cmd)
+	m.fitComposer()
+
+	if m.finderOpen {
+		m.finder.input, cmd = m.finder.input.Update(msg)
+		cmds = append(cmds, cmd)
+	}
 
 	return m, tea.Batch(cmds...)
 }
 
-// View renders the TUI.
+// View renders the TUI. Image data waiting to be sent to Kitty goes in front
+// of the first line, see images.kittyView.
 func (m *Model) View() string {
+	return m.images.kittyView() + m.view()
+}
+
+func (m *Model) view() string {
 	if !m.ready {
 		return "Initializing..."
 	}
-	return fmt.Sprintf("%s\n%s\n%s\n%s",
+	if m.boss.active {
+		return m.boss.view(m.width, m.height)
+	}
+	x := 0
+	if m.showSidebar {
+		x = sidebarWidth
+	}
+	var messages string
+	if m.mention.open {
+		// 成员列表盖住的图片不画，以免图片的转义序列被截断
+		popup := m.mentionView()
+		messages = m.panesView(x, false)
+		messages = overlayBottom(messages, popup, min(m.mentionColumn(), max(lipgloss.Width(messages)-lipgloss.Width(popup), 0)))
+	} else {
+		messages = m.panesView(x, true)
+	}
+	body := messages + "\n" + m.replyView() + m.composer.View()
+	if m.confirmQuit {
+		body = lipgloss.Place(m.width, lipgloss.Height(body), lipgloss.Center, lipgloss.Center, m.confirmQuitView())
+	} else if m.showHelp {
+		body = lipgloss.Place(m.width, lipgloss.Height(body), lipgloss.Center, lipgloss.Center, m.helpView())
+	} else if m.finderOpen {
+		body = lipgloss.Place(m.width, lipgloss.Height(messages)+1, lipgloss.Center, lipgloss.Top,
+			m.finder.view(m.theme, m.width, m.unread))
+	} else if overlay := m.selection.overlay(m.theme, m.width); overlay != "" {
+		body = lipgloss.Place(m.width, lipgloss.Height(body), lipgloss.Center, lipgloss.Center, overlay)
+	} else if m.showSidebar {
+		body = lipgloss.JoinHorizontal(lipgloss.Top,
+			m.sidebar.view(m.theme, m.activeChat, m.unread, m.mentions, m.sidebarFocused),
+			body,
+		)
+	}
+	return fmt.Sprintf("%s\n%s\n%s",
 		m.headerView(),
-		m.viewport.View(),
-		m.textInput.View(),
+		body,
 		m.statusView(),
 	)
 }
 
+// updateBoss handles keys while the boss key is on, or the boss key itself.
+func (m *Model) updateBoss(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, m.keys.Boss):
+		cmds := []tea.Cmd{m.boss.toggle()}
+		if !m.boss.active {
+			m.eachPane(func() { cmds = append(cmds, m.markActiveRead()) })
+		}
+		return tea.Batch(cmds...)
+	case key.Matches(msg, m.keys.Quit):
+		// 先恢复窗口标题再退出
+		return tea.Sequence(m.boss.toggle(), m.quit())
+	}
+	// 其他按键都吞掉，不会误发消息
+	return nil
+}
+
+// quit exits, first asking for confirmation if messages are still being sent
+// or the input has text in it.
+func (m *Model) quit() tea.Cmd {
+	if !m.confirmQuit && (m.sending > 0 || strings.TrimSpace(m.composer.Value()) != "") {
+		m.confirmQuit = true
+		return nil
+	}
+	m.saveDraft()
+	return tea.Quit
+}
+
+// updateConfirmQuit handles the answer to the quit confirmation.
+func (m *Model) updateConfirmQuit(msg tea.KeyMsg) tea.Cmd {
+	if msg.String() == "y" || msg.String() == "Y" || key.Matches(msg, m.keys.Quit) {
+		return m.quit()
+	}
+	m.confirmQuit = false
+	m.statusText = "Quit cancelled."
+	return nil
+}
+
+// confirmQuitView renders the quit confirmation.
+func (m *Model) confirmQuitView() string {
+	lines := []string{m.theme.popupTitle.Render("Quit?"), ""}
+	if m.sending == 1 {
+		lines = append(lines, "1 message is still being sent.")
+	} else if m.sending > 1 {
+		lines = append(lines, fmt.Sprintf("%d messages are still being sent.", m.sending))
+	}
+	if strings.TrimSpace(m.composer.Value()) != "" {
+		lines = append(lines, "The input will be kept as a draft.")
+	}
+	lines = append(lines, "", m.theme.hint.Render("y quit · any other key cancel"))
+	return m.theme.menu.Render(strings.Join(lines, "\n"))
+}
+
+// resize lays out the panes for the current terminal size.
+func (m *Model) resize() {
+	if !m.showSidebar {
+		m.sidebarFocused = false
+	}
+	width, height := m.messageArea()
+	m.composer.SetWidth(width)
+	m.selection.resize(m.width, m.height)
+	m.sidebar.height = m.height - lipgloss.Height(m.theme.header.Render("")) - lipgloss.Height(m.theme.status.Render(""))
+	m.sidebar.clamp()
+
+	for i := range m.panes {
+		m.inPane(i, func() {
+			m.viewport.Width, m.viewport.Height = m.paneSize(i, width, height)
+			m.updateViewportContent()
+		})
+	}
+}
+
+// messageArea returns the size of the area above the input that the panes share.
+func (m *Model) messageArea() (width, height int) {
+	headerHeight := lipgloss.Height(m.theme.header.Render(""))
+	statusHeight := lipgloss.Height(m.theme.status.Render(""))
+	inputHeight := m.composer.Height()
+	if m.replyTo != nil {
+		inputHeight++
+	}
+	width = m.width
+	if m.showSidebar {
+		width -= sidebarWidth
+	}
+	return width, m.height - headerHeight - statusHeight - inputHeight
+}
+
+// updateSidebar handles keys while the chat list has focus.
+func (m *Model) updateSidebar(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, m.keys.FocusSidebar):
+		m.sidebarFocused = false
+		return nil
+	case key.Matches(msg, m.keys.Sidebar):
+		m.showSidebar = false
+		m.resize()
+		return nil
+	}
+	switch msg.String() {
+	case "esc":
+		m.sidebarFocused = false
+	case "up", "k":
+		m.sidebar.move(-1)
+	case "down", "j":
+		m.sidebar.move(1)
+	case "pgup":
+		m.sidebar.move(-m.sidebar.visibleRows())
+	case "pgdown":
+		m.sidebar.move(m.sidebar.visibleRows())
+	case "home", "g":
+		m.sidebar.move(-len(m.sidebar.chats))
+	case "end", "G":
+		m.sidebar.move(len(m.sidebar.chats))
+	case "enter":
+		chat, ok := m.sidebar.selected()
+		if !ok {
+			return nil
+		}
+		m.sidebarFocused = false
+		return m.openChat(chat)
+	}
+	return nil
+}
+
+// updateFinder handles keys while the quick switcher is open.
+func (m *Model) updateFinder(msg tea.KeyMsg) tea.Cmd {
+	if key.Matches(msg, m.keys.Finder) {
+		m.finderOpen = false
+		m.finder.input.Blur()
+		return nil
+	}
+	switch msg.String() {
+	case "esc":
+		m.finderOpen = false
+		m.finder.input.Blur()
+		return nil
+	case "up", "ctrl+p":
+		m.finder.move(-1)
+		return nil
+	case "down", "ctrl+n", "tab":
+		m.finder.move(1)
+		return nil
+	case "enter":
+		chat, ok := m.finder.selected()
+		if !ok {
+			return nil
+		}
+		m.finderOpen = false
+		m.finder.input.Blur()
+		return m.openChat(chat)
+	}
+	var cmd tea.Cmd
+	before := m.finder.input.Value()
+	m.finder.input, cmd = m.finder.input.Update(msg)
+	if m.finder.input.Value() != before {
+		m.finder.filter(m.sidebar.chats, m.sidebar.activity, m.sidebar.counts)
+	}
+	return cmd
+}
+
+// openChat makes chat the active chat, for the TUI as well as the controller.
+func (m *Model) openChat(chat adapter.ChatInfo) tea.Cmd {
+	m.appState.SetActiveChat(chat.ID)
+	return func() tea.Msg {
+		return ActiveChatChangedMsg{ID: chat.ID, Name: chat.Name}
+	}
+}
+
 func (m *Model) headerView() string {
-	return headerStyle.Render(m.headerText)
+	text := m.headerText
+	if badge := m.unreadBadge(); badge != "" {
+		text += "  " + badge
+	}
+	return m.theme.header.Render(text)
+}
+
+// unreadBadge summarizes unread messages in chats other than the active one.
+func (m *Model) unreadBadge() string {
+	total, mentions, chats := 0, 0, 0
+	for chatID, n := range m.unread {
+		if n == 0 || m.paneOf(chatID) >= 0 {
+			continue
+		}
+		total += n
+		mentions += m.mentions[chatID]
+		chats++
+	}
+	if total == 0 {
+		return ""
+	}
+	badge := fmt.Sprintf("[%d unread in %d chats", total, chats)
+	if mentions > 0 {
+		badge += fmt.Sprintf(", %d @me", mentions)
+	}
+	return m.theme.unread.Render(badge + "]")
+}
+
+// markActiveRead clears the unread counters of the active chat and returns a
+// command that moves its read marker to its latest message.
+func (m *Model) markActiveRead() tea.Cmd {
+	if m.activeChat == "" {
+		return nil
+	}
+	delete(m.unread, m.activeChat)
+	delete(m.mentions, m.activeChat)
+	return markRead(m.store, m.activeChat)
+}
+
+// handleHistoryLoaded shows the messages loaded when a chat was opened, keeping
+// the ones received while they were loading.
+func (m *Model) handleHistoryLoaded(msg historyLoadedMsg) tea.Cmd {
+	i := m.paneOf(msg.chatID)
+	if i < 0 {
+		return nil // 加载完之前已经换了聊天
+	}
+	if msg.err != nil {
+		m.statusText = fmt.Sprintf("Error loading history: %v", msg.err)
+		return nil
+	}
+	var cmd tea.Cmd
+	m.inPane(i, func() {
+		// 加载期间收到的消息可能已经写入数据库，也可能还在 Writer 的队列中
+		for _, live := range m.messages {
+			if !slices.ContainsFunc(msg.messages, func(old adapter.Message) bool { return sameMessage(old, live) }) {
+				msg.messages = append(msg.messages, live)
+			}
+		}
+		for id, name := range m.names {
+			msg.names[id] = name
+		}
+		m.messages, m.names = msg.messages, msg.names
+		if msg.unread {
+			m.firstUnreadID = firstUnreadAfter(m.messages, msg.lastRead)
+		}
+		m.updateViewportContent()
+		if !m.jumpToFirstUnread() {
+			m.viewport.GotoBottom()
+		}
+		cmd = m.markActiveRead()
+	})
+	return cmd
+}
+
+// messageStored gives a message shown before it was saved its ID, so the
+// unread marker and jumping to it work for messages received live.
+func (m *Model) messageStored(stored adapter.Message) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := &m.messages[i]
+		if msg.ID != 0 || !sameMessage(*msg, stored) {
+			continue
+		}
+		msg.ID = stored.ID
+		// 伪装画面下收到的消息还没有读过，见 adapter.Message 的处理
+		if m.boss.active && m.firstUnreadID == 0 {
+			m.firstUnreadID = stored.ID
+			if !m.showStats {
+				m.updateViewportContent()
+			}
+		}
+		return
+	}
+}
+
+// sameMessage reports whether a received message is the stored message old.
+func sameMessage(old, msg adapter.Message) bool {
+	if old.MessageID != "" || msg.MessageID != "" {
+		return old.MessageID == msg.MessageID
+	}
+	return old.SenderID == msg.SenderID && old.Time.Equal(msg.Time) && old.Content == msg.Content
+}
+
+// send shows text in the conversation and returns a command that sends it to
+// the active chat, or false if the text cannot be sent.
+func (m *Model) send(text string) (tea.Cmd, bool) {
+	if n := utf8.RuneCountInString(text); n > maxMessageLength {
+		m.statusText = fmt.Sprintf("Message too long: %d characters, QQ allows %d.", n, maxMessageLength)
+		return nil, false
+	}
+	content := m.expandMentions(text)
+	cmd, ok := m.sendContent(content, content)
+	if ok {
+		m.recordInput(text)
+		delete(m.picked, m.activeChat)
+	}
+	return cmd, ok
+}
+
+// sendContent sends CQ-coded content to the active chat, showing shown in
+// the conversation until it is stored. The two differ for images, which are
+// sent inline.
+func (m *Model) sendContent(content, shown string) (tea.Cmd, bool) {
+	chatType := m.appState.GetChatType(m.activeChat)
+	if chatType == "" {
+		m.statusText = "Error: Unknown chat type."
+		return nil, false
+	}
+	if m.replyTo != nil && m.replyTo.MessageID != "" {
+		reply := adapter.FormatCQ(adapter.Segment{Type: "reply", Data: map[string]string{"id": m.replyTo.MessageID}})
+		content, shown = reply+content, reply+shown
+	}
+	sentMsg := adapter.Message{
+		ChatID:     m.activeChat,
+		ChatType:   chatType,
+		SenderID:   m.bot.SelfID(),
+		SenderName: "You",
+		Content:    shown,
+		Time:       time.Now(),
+	}
+	m.setReply(nil)
+	m.messages = append(m.messages, sentMsg)
+	m.updateViewportContent()
+	m.viewport.GotoBottom()
+	m.sidebar.touch(sentMsg, "")
+
+	bot := m.bot
+	m.sending++
+	return func() tea.Msg {
+		id, err := bot.SendMessage(sentMsg.ChatID, chatType, content)
+		return sendResultMsg{chatID: sentMsg.ChatID, sentAt: sentMsg.Time, messageID: id, err: err}
+	}, true
+}
+
+// sentMessage records the result of sending a message shown in the active chat.
+func (m *Model) sentMessage(msg sendResultMsg) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ChatID != msg.chatID || !m.messages[i].Time.Equal(msg.sentAt) || !m.isSelf(m.messages[i]) {
+			continue
+		}
+		if msg.err != nil {
+			m.messages = append(m.messages[:i], m.messages[i+1:]...)
+			m.updateViewportContent()
+		} else {
+			m.messages[i].MessageID = msg.messageID
+		}
+		return
+	}
+}
+
+// isSelf reports whether msg was sent by the logged-in account, including
+// messages sent from here that have not been stored yet.
+func (m *Model) isSelf(msg adapter.Message) bool {
+	id := m.bot.SelfID()
+	return id != "" && msg.SenderID == id
+}
+
+// setReply sets the message the next sent message replies to, or clears it.
+func (m *Model) setReply(msg *adapter.Message) {
+	if (m.replyTo == nil) == (msg == nil) {
+		m.replyTo = msg
+		return
+	}
+	m.replyTo = msg
+	if m.ready {
+		m.resize()
+	}
+}
+
+// replyView renders the line above the composer that shows which message is being replied to.
+func (m *Model) replyView() string {
+	if m.replyTo == nil {
+		return ""
+	}
+	width := max(m.viewport.Width-m.theme.reply.GetHorizontalFrameSize(), 10)
+	line := fmt.Sprintf("↩ Replying to %s: %s", m.replyTo.SenderName, plainText(m.replyTo.Content, m.names))
+	line = runewidth.Truncate(line, width-len(" (Esc to cancel)"), "…") + " (Esc to cancel)"
+	return m.theme.reply.Render(line) + "\n"
+}
+
+// fitComposer grows the input with its content, up to composerMaxHeight lines.
+func (m *Model) fitComposer() {
+	h := min(max(m.composer.LineCount(), 1), composerMaxHeight)
+	if h != m.composer.Height() {
+		m.composer.SetHeight(h)
+		if m.ready {
+			m.resize()
+		}
+	}
+}
+
+// saveDraft stores the unsent input of the active chat.
+func (m *Model) saveDraft() {
+	if m.activeChat == "" {
+		return
+	}
+	text := m.composer.Value()
+	if m.historyPos < len(m.history) {
+		text = m.historyStash // 正在浏览历史时，输入框里是历史记录而不是草稿
+	}
+	if err := m.store.SaveDraft(m.activeChat, text); err != nil {
+		m.statusText = fmt.Sprintf("Error saving draft: %v", err)
+	}
+}
+
+// loadInput restores the draft and input history of the active chat.
+func (m *Model) loadInput() {
+	m.composer.Reset()
+	m.history = nil
+	if draft, err := m.store.GetDraft(m.activeChat); err != nil {
+		m.statusText = fmt.Sprintf("Error loading draft: %v", err)
+	} else {
+		m.composer.SetValue(draft)
+	}
+	if history, err := m.store.GetInputHistory(m.activeChat, inputHistorySize); err != nil {
+		m.statusText = fmt.Sprintf("Error loading input history: %v", err)
+	} else {
+		m.history = history
+	}
+	m.historyPos = len(m.history)
+}
+
+// recordInput appends a sent message to the input history and clears the draft.
+func (m *Model) recordInput(text string) {
+	if len(m.history) == 0 || m.history[len(m.history)-1] != text {
+		m.history = append(m.history, text)
+		if err := m.store.AddInputHistory(m.activeChat, text); err != nil {
+			m.statusText = fmt.Sprintf("Error saving input history: %v", err)
+		}
+	}
+	m.historyPos = len(m.history)
+	if err := m.store.SaveDraft(m.activeChat, ""); err != nil {
+		m.statusText = fmt.Sprintf("Error clearing draft: %v", err)
+	}
+}
+
+// browseHistory moves through the input history like a shell; delta is -1 for older and 1 for newer.
+func (m *Model) browseHistory(delta int) {
+	pos := m.historyPos + delta
+	if pos < 0 || pos > len(m.history) {
+		return
+	}
+	if m.historyPos == len(m.history) {
+		m.historyStash = m.composer.Value()
+	}
+	m.historyPos = pos
+	if pos == len(m.history) {
+		m.composer.SetValue(m.historyStash)
+	} else {
+		m.composer.SetValue(m.history[pos])
+	}
+	m.fitComposer()
+}
+
+// jumpToFirstUnread scrolls the viewport to the first unread message, if it is loaded.
+func (m *Model) jumpToFirstUnread() bool {
+	if m.firstUnreadID == 0 {
+		return false
+	}
+	for i, msg := range m.messages {
+		if msg.ID == m.firstUnreadID && i < len(m.msgLines) {
+			// 把 "新消息" 分隔线也留在视野内
+			m.viewport.SetYOffset(max(m.msgLines[i]-1, 0))
+			return true
+		}
+	}
+	return false
+}
+
+// firstUnreadAfter returns the ID of the first stored message after the read marker.
+func firstUnreadAfter(messages []adapter.Message, lastRead int64) int64 {
+	for _, msg := range messages {
+		if msg.ID > lastRead {
+			return msg.ID
+		}
+	}
+	return 0
 }
 
 func (m *Model) statusView() string {
-	return statusStyle.Render(m.statusText)
+	return m.theme.status.Render(m.statusText)
 }
 
 func (m *Model) updateViewportContent() {
+	if m.showStats {
+		m.viewport.SetContent(m.theme.leftMsg.Render(m.statsText))
+		return
+	}
+	if m.stealth {
+		m.images.placements = m.images.placements[:0]
+		clear(m.images.wanted)
+		m.updateStealthContent()
+		return
+	}
 	var content strings.Builder
-	for _, msg := range m.messages {
-		var styledSender string
-		var finalMsgStyle lipgloss.Style
+	m.msgLines = m.msgLines[:0]
+	m.images.placements = m.images.placements[:0]
+	clear(m.images.wanted)
+	var renders [][]*imageRender // 每条消息中画出的图片
+	now := time.Now()
+	m.renderedAt = now
+	for i, msg := range m.messages {
+		if i == 0 || !sameDay(m.messages[i-1].Time, msg.Time) {
+			content.WriteString(m.daySeparator(msg.Time, now) + "\n")
+		}
+		unreadMark := msg.ID != 0 && msg.ID == m.firstUnreadID
+		if unreadMark {
+			content.WriteString(m.theme.unread.Render("── New messages ──") + "\n")
+		}
+		m.msgLines = append(m.msgLines, strings.Count(content.String(), "\n"))
 
-		if msg.SenderName == "You" {
-			styledSender = selfSenderStyle.Render(msg.SenderName)
-			finalMsgStyle = rightMsgStyle
-		} else {
-			styledSender = senderStyle.Render(msg.SenderName)
-			finalMsgStyle = leftMsgStyle
+		self := m.isSelf(msg)
+		finalMsgStyle := m.theme.leftMsg
+		if self {
+			finalMsgStyle = m.theme.rightMsg.Width(m.viewport.Width)
+		}
+
+		if m.selection.active && i == m.selection.cursor {
+			finalMsgStyle = selectedStyle(m.theme, finalMsgStyle, self, m.viewport.Width)
 		}
 
-		simplifiedContent := simplifyCQCodes(msg.Content)
-		formattedMsg := fmt.Sprintf("%s\n%s", styledSender, simplifiedContent)
+		body, msgRenders := m.renderBody(msg, m.msgLines[i])
+		renders = append(renders, msgRenders)
+		formattedMsg := body
+		if i == 0 || unreadMark || !m.groupedWith(m.messages[i-1], msg) {
+			formattedMsg = m.messageHeader(msg, self, now) + "\n" + body
+		}
 		content.WriteString(finalMsgStyle.Render(formattedMsg) + "\n")
 	}
 	m.viewport.SetContent(content.String())
+	if len(m.images.wanted) > 0 {
+		lines := strings.Split(content.String(), "\n")
+		for i, r := range renders {
+			m.images.place(lines, m.msgLines[i], r)
+		}
+	}
+}
+
+// editorFinishedMsg is sent when the external editor opened with Alt+E exits.
+type editorFinishedMsg struct {
+	path    string
+	draft   string    // 写入文件的草稿
+	modTime time.Time // 打开编辑器前文件的修改时间
+	err     error
+}
+
+// openEditor is a command that edits draft in $VISUAL or $EDITOR and sends the
+// saved text. Nothing is sent if the editor fails or the file is not saved.
+func openEditor(draft string) tea.Cmd {
+	f, err := os.CreateTemp("", "onebot-tui-*.txt")
+	if err != nil {
+		return func() tea.Msg { return editorFinishedMsg{err: err} }
+	}
+	_, err = f.WriteString(draft)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(f.Name())
+	}
+	if err != nil {
+		return func() tea.Msg { return editorFinishedMsg{path: f.Name(), draft: draft, err: err} }
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorFinishedMsg{path: f.Name(), draft: draft, modTime: info.ModTime(), err: err}
+	})
+}
+
+// loadSidebar is a command that loads the latest activity of every chat for the sidebar.
+func loadSidebar(store storage.Backend, chats []adapter.ChatInfo) tea.Cmd {
+	return func() tea.Msg {
+		summaries, err := store.ChatSummaries()
+		return sidebarLoadedMsg{chats: chats, summaries: summaries, err: err}
+	}
 }
 
-func simplifyCQCodes(content string) string {
-	content = cqImageRegex.ReplaceAllString(content, "[图片]")
-	content = cqForwardRegex.ReplaceAllString(content, "[转发]")
-	return content
+// loadStats is a command that computes the statistics of a chat.
+func loadStats(store storage.Backend, chatID string) tea.Cmd {
+	return func() tea.Msg {
+		report, err := stats.Compute(store, stats.Options{ChatID: chatID})
+		return statsLoadedMsg{chatID: chatID, report: report, err: err}
+	}
 }
 
-// waitForMessage is a command that waits for a new message on the message channel.
-func waitForMessage(ch chan adapter.Message) tea.Cmd {
+// loadUnread is a command that loads the unread counters from storage.
+func loadUnread(store storage.Backend) tea.Cmd {
 	return func() tea.Msg {
-		return <-ch
+		infos, err := store.GetUnread()
+		return unreadLoadedMsg{infos: infos, err: err}
+	}
+}
+
+// loadHistory is a command that loads the recent messages of a chat. The
+// read marker is only looked up when the chat has unread messages.
+func loadHistory(store storage.Backend, chatID string, unread bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := historyLoadedMsg{chatID: chatID, unread: unread}
+		if unread {
+			// 先于加载期间可能发出的 markRead 读取
+			if msg.lastRead, msg.err = store.LastReadID(chatID); msg.err != nil {
+				return msg
+			}
+		}
+		if msg.messages, msg.err = store.GetMessages(chatID, 50); msg.err != nil {
+			return msg
+		}
+		msg.names, msg.err = store.SenderNames(chatID)
+		return msg
+	}
+}
+
+// markRead is a command that moves the read marker of a chat to its latest
+// message. The Writer commits the queued messages first, so this must not run
+// in Update.
+func markRead(store storage.Backend, chatID string) tea.Cmd {
+	return func() tea.Msg {
+		if err := store.MarkRead(chatID); err != nil {
+			return markReadFailedMsg{err: err}
+		}
+		return nil
 	}
 }
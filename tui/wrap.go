@@ -0,0 +1,108 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// wrapText wraps s, which may contain ANSI styles, to width cells. Latin words
+// move to the next line whole, while CJK text, which has no spaces, breaks
+// between any two wide characters. Styles are carried over the breaks.
+func wrapText(s string, width int) string {
+	if width < 1 {
+		return s
+	}
+	w := wrapper{width: width}
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			w.out.WriteByte('\n')
+			w.lineWidth = 0
+		}
+		w.wrapLine(line)
+	}
+	return w.out.String()
+}
+
+type wrapper struct {
+	width int
+	out   strings.Builder
+
+	lineWidth int
+	word      []string // 当前词的字符和转义序列
+	wordWidth int
+	space     int      // 词前面待写的空格数
+	styles    []string // 已经写出、仍然有效的 SGR 序列，换行后重新设置
+}
+
+func (w *wrapper) wrapLine(line string) {
+	p := ansi.GetParser()
+	defer ansi.PutParser(p)
+	var state byte
+	for len(line) > 0 {
+		seq, width, n, newState := ansi.DecodeSequence(line, state, p)
+		line, state = line[n:], newState
+		switch {
+		case width == 0:
+			w.word = append(w.word, seq)
+		case seq == " ":
+			w.flush()
+			w.space++
+		case width > 1:
+			// 宽字符前后都可以换行
+			w.flush()
+			w.word, w.wordWidth = append(w.word, seq), width
+			w.flush()
+		default:
+			if w.wordWidth+width > w.width {
+				w.flush() // 比一行还长的词只能断开
+			}
+			w.word = append(w.word, seq)
+			w.wordWidth += width
+		}
+	}
+	w.flush()
+	// 行尾放得下的空格保留，图片等按行比较的内容要和原来一样
+	if w.space > 0 && w.lineWidth+w.space <= w.width {
+		w.out.WriteString(strings.Repeat(" ", w.space))
+	}
+	w.space = 0
+}
+
+// flush writes the pending spaces and word, breaking the line first if they do not fit.
+func (w *wrapper) flush() {
+	if len(w.word) == 0 {
+		return
+	}
+	if w.wordWidth > 0 && w.lineWidth > 0 && w.lineWidth+w.space+w.wordWidth > w.width {
+		w.newline()
+	} else {
+		w.out.WriteString(strings.Repeat(" ", w.space))
+		w.lineWidth += w.space
+	}
+	for _, seq := range w.word {
+		if isSGR(seq) {
+			if seq == "\x1b[m" || seq == "\x1b[0m" {
+				w.styles = w.styles[:0]
+			} else {
+				w.styles = append(w.styles, seq)
+			}
+		}
+		w.out.WriteString(seq)
+	}
+	w.lineWidth += w.wordWidth
+	w.word, w.wordWidth, w.space = w.word[:0], 0, 0
+}
+
+func (w *wrapper) newline() {
+	if len(w.styles) > 0 {
+		w.out.WriteString(ansiReset)
+	}
+	w.out.WriteByte('\n')
+	w.out.WriteString(strings.Join(w.styles, ""))
+	w.lineWidth = 0
+}
+
+func isSGR(seq string) bool {
+	return strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m")
+}
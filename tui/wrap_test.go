@@ -0,0 +1,33 @@
+package tui
+
+import "testing"
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		width int
+		want  string
+	}{
+		{"fits", "hello world", 20, "hello world"},
+		{"words", "hello world", 5, "hello\nworld"},
+		{"long word", "abcdefgh", 3, "abc\ndef\ngh"},
+		{"existing lines", "a b\nc", 1, "a\nb\nc"},
+		{"trailing space", "ab ", 3, "ab "},
+		{"no width", "hello world", 0, "hello world"},
+		// 汉字之间没有空格，任意两个字之间都可以换行
+		{"cjk", "你好世界", 5, "你好\n世界"},
+		{"cjk after latin", "ab你好", 4, "ab你\n好"},
+		{"latin after cjk", "你好 hello", 6, "你好\nhello"},
+		// 换行时先重置样式，下一行开头再设置回来
+		{"style carried", "\x1b[31mhello world\x1b[0m", 5, "\x1b[31mhello\x1b[0m\n\x1b[31mworld\x1b[0m"},
+		{"styles stacked", "\x1b[1m\x1b[31mab cd\x1b[m", 2, "\x1b[1m\x1b[31mab\x1b[0m\n\x1b[1m\x1b[31mcd\x1b[m"},
+		{"style ended", "\x1b[1m你好\x1b[m世界", 4, "\x1b[1m你好\x1b[m\n世界"},
+		{"cjk style carried", "\x1b[32m你好世界\x1b[0m", 4, "\x1b[32m你好\x1b[0m\n\x1b[32m世界\x1b[0m"},
+	}
+	for _, tt := range tests {
+		if got := wrapText(tt.in, tt.width); got != tt.want {
+			t.Errorf("%s: wrapText(%q, %d) = %q, want %q", tt.name, tt.in, tt.width, got, tt.want)
+		}
+	}
+}